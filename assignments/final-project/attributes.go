@@ -0,0 +1,461 @@
+// CST8333 Cheese Directory App - Custom Attributes - Lucas Estienne
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"database/sql"
+	"errors"
+	"flag"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// types of custom attributes, the validation of text is a regular expression,
+// of numbers a "min..max" range (either side may be empty) and of choices the options separated by "|"
+const (
+	AttributeText = "text"
+	AttributeNumber = "number"
+	AttributeBool = "bool"
+	AttributeDate = "date"
+	AttributeChoice = "choice"
+)
+
+var attributeTypes = []string { AttributeText, AttributeNumber, AttributeBool, AttributeDate, AttributeChoice }
+
+// prefix of the filter columns matching custom attributes, e.g. attr.supplier=Fromagerie
+const AttributeFilterPrefix = "attr."
+
+// names of custom attributes, e.g. price_per_kg
+var attributeNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// simple data structure describing a custom attribute defined by an admin
+type CustomAttribute struct {
+	Name string
+	Type string
+	Validation string
+}
+
+// function to create the custom attribute tables
+func migrateCustomAttributes(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE custom_attributes (
+			name TEXT PRIMARY KEY,
+			type TEXT NOT NULL,
+			validation TEXT NOT NULL DEFAULT ''
+		)`,
+		`CREATE TABLE cheese_attributes (
+			record_id INTEGER NOT NULL REFERENCES cheeses(id),
+			name TEXT NOT NULL REFERENCES custom_attributes(name),
+			value TEXT NOT NULL,
+			PRIMARY KEY (record_id, name)
+		)`,
+	)
+}
+
+// function to check the definition of a custom attribute
+func (a CustomAttribute) check() error {
+	if !attributeNamePattern.MatchString(a.Name) {
+		return fmt.Errorf("invalid attribute name %q, expected lowercase letters, digits and underscores", a.Name)
+	}
+
+	switch a.Type {
+		case AttributeText:
+			if _, err := regexp.Compile(a.Validation); err != nil {
+				return fmt.Errorf("invalid pattern %q: %v", a.Validation, err)
+			}
+		case AttributeNumber:
+			if _, _, err := parseNumberRange(a.Validation); err != nil {
+				return err
+			}
+		case AttributeChoice:
+			if len(a.choices()) == 0 {
+				return errors.New("a choice attribute needs options separated by \"|\"")
+			}
+		case AttributeBool, AttributeDate:
+			if a.Validation != "" {
+				return fmt.Errorf("%s attributes have no validation", a.Type)
+			}
+		default:
+			return fmt.Errorf("unknown attribute type %q, expected one of %v", a.Type, attributeTypes)
+	}
+	return nil
+}
+
+// helper function to parse a "min..max" range of numbers, either side may be empty
+func parseNumberRange(s string) (sql.NullFloat64, sql.NullFloat64, error) {
+	if strings.TrimSpace(s) == "" {
+		return sql.NullFloat64{}, sql.NullFloat64{}, nil
+	}
+
+	parts := strings.SplitN(s, "..", 2)
+	if len(parts) != 2 {
+		return sql.NullFloat64{}, sql.NullFloat64{}, fmt.Errorf("invalid range %q, expected min..max", s)
+	}
+	min, max := parseNullFloat(parts[0]), parseNullFloat(parts[1])
+	if (!min.Valid && strings.TrimSpace(parts[0]) != "") || (!max.Valid && strings.TrimSpace(parts[1]) != "") ||
+		(min.Valid && max.Valid && max.Float64 < min.Float64) {
+		return sql.NullFloat64{}, sql.NullFloat64{}, fmt.Errorf("invalid range %q, expected min..max", s)
+	}
+	return min, max, nil
+}
+
+// helper function to list the options of a choice attribute
+func (a CustomAttribute) choices() []string {
+	var options []string
+	for _, o := range strings.Split(a.Validation, "|") {
+		if o = strings.TrimSpace(o); o != "" {
+			options = append(options, o)
+		}
+	}
+	return options
+}
+
+// function to validate a value of a custom attribute, returns the value as it is stored, blanks and N/A are missing
+func (a CustomAttribute) validate(value string) (sql.NullString, error) {
+	v := nullString(value)
+	if !v.Valid {
+		return v, nil
+	}
+
+	switch a.Type {
+		case AttributeText:
+			if !regexp.MustCompile(a.Validation).MatchString(v.String) {
+				return sql.NullString{}, fmt.Errorf("%s must match %s", a.Name, a.Validation)
+			}
+		case AttributeNumber:
+			f, err := strconv.ParseFloat(v.String, 64)
+			if err != nil {
+				return sql.NullString{}, fmt.Errorf("%s must be a number", a.Name)
+			}
+			min, max, _ := parseNumberRange(a.Validation)
+			if (min.Valid && f < min.Float64) || (max.Valid && f > max.Float64) {
+				return sql.NullString{}, fmt.Errorf("%s must be in the range %s", a.Name, a.Validation)
+			}
+			v.String = strconv.FormatFloat(f, 'f', -1, 64)
+		case AttributeBool:
+			b, err := strconv.ParseBool(v.String)
+			if err != nil {
+				return sql.NullString{}, fmt.Errorf("%s must be true or false", a.Name)
+			}
+			v.String = strconv.FormatBool(b)
+		case AttributeDate:
+			d, err := parseDate(v.String)
+			if err != nil {
+				return sql.NullString{}, err
+			}
+			v.String = d.String()
+		case AttributeChoice:
+			for _, o := range a.choices() {
+				if strings.EqualFold(o, v.String) {
+					v.String = o
+					return v, nil
+				}
+			}
+			return sql.NullString{}, fmt.Errorf("%s must be one of %v", a.Name, a.choices())
+	}
+	return v, nil
+}
+
+// function to load the custom attributes, by name
+func loadCustomAttributes(database *sql.DB) []CustomAttribute {
+	var attrs []CustomAttribute
+
+	rows, err := database.Query(`SELECT name, type, validation FROM custom_attributes ORDER BY name`)
+	check(err)
+	for rows.Next() {
+		var a CustomAttribute
+		if err = rows.Scan(&a.Name, &a.Type, &a.Validation); err != nil {
+			log.Fatal(err)
+		}
+		attrs = append(attrs, a)
+	}
+	rows.Close()
+
+	return attrs
+}
+
+// function to define a custom attribute, or change the type and validation of an existing one
+func defineCustomAttribute(database *sql.DB, a CustomAttribute) error {
+	a.Name = strings.TrimSpace(a.Name)
+	a.Validation = strings.TrimSpace(a.Validation)
+	if err := a.check(); err != nil {
+		return err
+	}
+
+	_, err := database.Exec(`
+		INSERT INTO custom_attributes (name, type, validation) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET type = excluded.type, validation = excluded.validation
+	`, a.Name, a.Type, a.Validation)
+	return err
+}
+
+// function to delete a custom attribute with its values on every cheese
+func deleteCustomAttribute(database *sql.DB, name string) error {
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`DELETE FROM custom_attributes WHERE name = ?`, name)
+	if err == nil {
+		if n, _ := result.RowsAffected(); n == 0 {
+			err = fmt.Errorf("no custom attribute named %q", name)
+		}
+	}
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM cheese_attributes WHERE name = ?`, name)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// function to load the custom attribute values of every record, by record id
+func loadRecordAttributes(q querier) map[int64]map[string]string {
+	values := map[int64]map[string]string{}
+
+	rows, err := q.Query(`SELECT record_id, name, value FROM cheese_attributes`)
+	check(err)
+	for rows.Next() {
+		var (
+			id int64
+			name, value string
+		)
+		if err = rows.Scan(&id, &name, &value); err != nil {
+			log.Fatal(err)
+		}
+		if values[id] == nil {
+			values[id] = map[string]string{}
+		}
+		values[id][name] = value
+	}
+	rows.Close()
+
+	return values
+}
+
+// function to store the custom attribute values of a record, values of attributes no longer defined are dropped
+func insertRecordAttributes(q querier, recordId int64, values map[string]string) error {
+	for name, value := range values {
+		_, err := q.Exec(`
+			INSERT INTO cheese_attributes (record_id, name, value)
+			SELECT ?, name, ? FROM custom_attributes WHERE name = ?
+		`, recordId, value, name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// function to prompt for the custom attribute values of a record, asking again until a value is valid
+func readAttributeValues(attrs []CustomAttribute, r Record, keep bool) Record {
+	// only the values of the attributes still defined are kept
+	values := map[string]string{}
+	for _, a := range attrs {
+		if value, ok := r.Attributes[a.Name]; ok {
+			values[a.Name] = value
+		}
+	}
+
+	for _, a := range attrs {
+		toRead := fmt.Sprintf("%s (%s", a.Name, a.Type)
+		if a.Validation != "" {
+			toRead += " " + a.Validation
+		}
+		toRead += ")"
+
+		for {
+			var s string
+			if keep {
+				def := MissingValue
+				if v, ok := values[a.Name]; ok {
+					def = v
+				}
+				s = readNewOrKeepDefaultString(toRead, def)
+			} else {
+				s = readString(toRead)
+			}
+
+			v, err := a.validate(s)
+			if err != nil {
+				fmt.Printf("\n%v\n", err)
+				continue
+			}
+			if v.Valid {
+				values[a.Name] = v.String
+			} else {
+				delete(values, a.Name)
+			}
+			break
+		}
+	}
+
+	r.Attributes = nil
+	if len(values) > 0 {
+		r.Attributes = values
+	}
+	return r
+}
+
+// helper function to list the names of the custom attributes a record has, sorted
+func attributeNames(values map[string]string) []string {
+	var names []string
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// helper function to list the filter columns of custom attributes
+func attributeColumns(attrs []CustomAttribute) []string {
+	var columns []string
+	for _, a := range attrs {
+		columns = append(columns, AttributeFilterPrefix + a.Name)
+	}
+	return columns
+}
+
+// helper function to check if a filter column matches on a custom attribute
+func isAttributeColumn(column string) bool {
+	return strings.HasPrefix(column, AttributeFilterPrefix) && len(column) > len(AttributeFilterPrefix)
+}
+
+// helper function to build the condition matching a custom attribute, ignoring case and number formatting
+func attributeCondition(f Filter) (string, []interface{}) {
+	name := strings.TrimPrefix(f.Column, AttributeFilterPrefix)
+	value := strings.TrimSpace(f.Value)
+
+	condition := `LOWER(v.value) = LOWER(?)`
+	args := []interface{}{name, value}
+	if n := parseNullFloat(value); n.Valid {
+		condition += ` OR (a.type = 'number' AND CAST(v.value AS REAL) = ?)`
+		args = append(args, n.Float64)
+	}
+
+	return `id IN (SELECT v.record_id FROM cheese_attributes v JOIN custom_attributes a ON a.name = v.name
+		WHERE v.name = ? AND (` + condition + `))`, args
+}
+
+// helper function to get the export headers of the custom attributes
+func attributeHeaders(attrs []CustomAttribute) []string {
+	var headers []string
+	for _, a := range attrs {
+		headers = append(headers, a.Name)
+	}
+	return headers
+}
+
+// helper function to convert the custom attribute values of a record to a slice matching attributeHeaders
+func attributesToSlice(r Record, attrs []CustomAttribute) []string {
+	var values []string
+	for _, a := range attrs {
+		values = append(values, r.Attributes[a.Name])
+	}
+	return values
+}
+
+// function to print the custom attributes
+func printCustomAttributes(attrs []CustomAttribute) {
+	rows := [][]string{{"Name", "Type", "Validation"}}
+	for _, a := range attrs {
+		rows = append(rows, []string{a.Name, a.Type, a.Validation})
+	}
+
+	writeAlignedTable(os.Stdout, rows)
+}
+
+// function to view and define the custom attributes from the menu, returns whether they changed
+func manageCustomAttributes(database *sql.DB, user User) bool {
+	selection := 0
+
+	fmt.Printf("\nCustom attributes...\n\n")
+	fmt.Println(" 1. List the attributes")
+	if hasRole(user, RoleAdmin) {
+		fmt.Println(" 2. Define or change an attribute")
+		fmt.Println(" 3. Delete an attribute")
+	}
+
+	// loop until selection is valid
+	for selection == 0 {
+		fmt.Printf("Please choose an option: ")
+
+		_, err := fmt.Scanf("%d", &selection)
+		if isEndOfInput(err) {
+			exitAtEndOfInput()
+		}
+
+		if err != nil || selection < 1 || selection > 3 {
+			selection = 0
+			fmt.Println("\nPlease enter a valid option.")
+		} else if selection > 1 && !hasRole(user, RoleAdmin) {
+			writeAuditLog(database, user.Username, "custom attributes", AuditDenied, "role "+user.Role)
+			fmt.Printf("\nYour role (%s) does not allow this option.\n", user.Role)
+			return false
+		}
+	}
+
+	var err error
+
+	switch selection {
+		case 1:
+			printCustomAttributes(loadCustomAttributes(database))
+		case 2:
+			a := CustomAttribute{Name: readRequiredString("attribute name (e.g. price_per_kg)")}
+			a.Type = readColumnChoice("type", attributeTypes, false)
+			a.Validation = readString("validation (pattern, min..max range or options separated by |, Enter for none)")
+			err = defineCustomAttribute(database, a)
+			if err == nil {
+				writeAuditLog(database, user.Username, "define attribute", AuditAllowed, a.Name+" "+a.Type+" "+a.Validation)
+				return true
+			}
+		case 3:
+			name := readColumnChoice("attribute", attributeHeaders(loadCustomAttributes(database)), false)
+			err = deleteCustomAttribute(database, name)
+			if err == nil {
+				writeAuditLog(database, user.Username, "delete attribute", AuditAllowed, name)
+				return true
+			}
+	}
+
+	if err != nil {
+		fmt.Printf("\nError: %v\n", err)
+	}
+	return false
+}
+
+// function to run the "attributes" command
+func attributesCommand(database *sql.DB, user User, args []string) error {
+	printCustomAttributes(loadCustomAttributes(database))
+	return nil
+}
+
+// function to run the "attribute-define" command
+func attributeDefineCommand(database *sql.DB, user User, args []string) error {
+	flags := flag.NewFlagSet("attribute-define", flag.ContinueOnError)
+	validation := flags.String("validation", "", "pattern of text, min..max range of numbers or options of choices separated by |")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return fmt.Errorf("expected a name and a type, one of %v", attributeTypes)
+	}
+
+	return defineCustomAttribute(database, CustomAttribute{flags.Arg(0), flags.Arg(1), *validation})
+}
+
+// function to run the "attribute-delete" command
+func attributeDeleteCommand(database *sql.DB, user User, args []string) error {
+	if len(args) != 1 {
+		return errors.New("expected the name of the attribute")
+	}
+	return deleteCustomAttribute(database, args[0])
+}
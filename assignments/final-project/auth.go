@@ -0,0 +1,481 @@
+// CST8333 Cheese Directory App - Users, Roles and Audit Log - Lucas Estienne
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"database/sql"
+	"log"
+	"time"
+	"errors"
+	"bufio"
+	"strings"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+)
+
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin = "admin"
+)
+
+// environment variable holding an API key, used instead of the username/password prompt
+const ApiKeyEnvVar = "CHEESEDIR_API_KEY"
+
+const PasswordHashIterations = 100000
+const MaxLoginAttempts = 3
+
+const (
+	AuditAllowed = "allowed"
+	AuditDenied = "denied"
+)
+
+// simple data structure containing an authenticated user
+type User struct {
+	Id int
+	Username string
+	Role string
+}
+
+// simple data structure containing an audit log entry
+type AuditEntry struct {
+	CreatedAt string
+	Username string
+	Action string
+	Outcome string
+	Detail string
+}
+
+// minimum role required for each menu option, options not listed are open to every role
+var optionRoles = map[int]string {
+	OptionReload: RoleEditor,
+	OptionCreate: RoleEditor,
+	OptionEdit: RoleEditor,
+	OptionDelete: RoleEditor,
+	OptionManageUsers: RoleAdmin,
+	OptionMerge: RoleEditor,
+}
+
+// function to create the users and audit log tables
+func initUsersTables(database *sql.DB) {
+	statement, _ := database.Prepare(`
+		CREATE TABLE IF NOT EXISTS users (
+			id INTEGER PRIMARY KEY,
+			username TEXT NOT NULL UNIQUE,
+			password_hash TEXT,
+			salt TEXT,
+			api_key_hash TEXT,
+			role TEXT NOT NULL
+		)
+	`)
+	statement.Exec()
+
+	statement, _ = database.Prepare(`
+		CREATE TABLE IF NOT EXISTS audit_log (
+			id INTEGER PRIMARY KEY,
+			created_at TEXT,
+			username TEXT,
+			action TEXT,
+			outcome TEXT,
+			detail TEXT
+		)
+	`)
+	statement.Exec()
+}
+
+// helper function to rank roles, higher ranks include the permissions of lower ones
+func roleRank(role string) int {
+	switch role {
+		case RoleViewer:
+			return 1
+		case RoleEditor:
+			return 2
+		case RoleAdmin:
+			return 3
+	}
+	return 0
+}
+
+// helper to check if a user has at least the given role
+func hasRole(user User, role string) bool {
+	return roleRank(user.Role) >= roleRank(role)
+}
+
+// helper to check if a user is allowed to execute a menu option
+func canExecute(user User, option int) bool {
+	role, ok := optionRoles[option]
+	if !ok {
+		return true
+	}
+	return hasRole(user, role)
+}
+
+// helper function to hash a password with its salt
+func hashSecret(secret string, salt string) string {
+	key, err := pbkdf2.Key(sha256.New, secret, []byte(salt), PasswordHashIterations, 32)
+	check(err)
+	return hex.EncodeToString(key)
+}
+
+// helper function to hash an API key, keys are random so a single round is enough
+func hashApiKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// helper function to generate a random hex token
+func newRandomToken(numBytes int) string {
+	b := make([]byte, numBytes)
+	_, err := rand.Read(b)
+	check(err)
+	return hex.EncodeToString(b)
+}
+
+// function to create a user account
+func createUser(database *sql.DB, username string, password string, role string) error {
+	if strings.TrimSpace(username) == "" {
+		return errors.New("username cannot be empty")
+	}
+	if password == "" {
+		return errors.New("password cannot be empty")
+	}
+	if roleRank(role) == 0 {
+		return fmt.Errorf("unknown role %q, expected %s, %s or %s", role, RoleViewer, RoleEditor, RoleAdmin)
+	}
+
+	salt := newRandomToken(16)
+
+	statement, _ := database.Prepare(`
+		INSERT INTO users (username, password_hash, salt, role) VALUES (?, ?, ?, ?)
+	`)
+	_, err := statement.Exec(username, hashSecret(password, salt), salt, role)
+	return err
+}
+
+// function to change the role of a user account
+func setUserRole(database *sql.DB, username string, role string) error {
+	if roleRank(role) == 0 {
+		return fmt.Errorf("unknown role %q, expected %s, %s or %s", role, RoleViewer, RoleEditor, RoleAdmin)
+	}
+
+	statement, _ := database.Prepare(`
+		UPDATE users SET role = ? WHERE username = ?
+	`)
+	result, err := statement.Exec(role, username)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("no user named %q", username)
+	}
+	return nil
+}
+
+// function to delete a user account
+func deleteUser(database *sql.DB, username string) error {
+	statement, _ := database.Prepare(`
+		DELETE FROM users WHERE username = ?
+	`)
+	result, err := statement.Exec(username)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("no user named %q", username)
+	}
+	return nil
+}
+
+// function to generate a new API key for a user, replacing any previous key
+func generateApiKey(database *sql.DB, username string) (string, error) {
+	key := "cdk_" + newRandomToken(24)
+
+	statement, _ := database.Prepare(`
+		UPDATE users SET api_key_hash = ? WHERE username = ?
+	`)
+	result, err := statement.Exec(hashApiKey(key), username)
+	if err != nil {
+		return "", err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return "", fmt.Errorf("no user named %q", username)
+	}
+	return key, nil
+}
+
+// function to authenticate a user by username and password
+func authenticatePassword(database *sql.DB, username string, password string) (User, bool) {
+	var (
+		u User
+		passwordHash string
+		salt string
+	)
+
+	statement, _ := database.Prepare(`
+		SELECT id, username, role, password_hash, salt FROM users WHERE username = ?
+	`)
+	err := statement.QueryRow(username).Scan(&u.Id, &u.Username, &u.Role, &passwordHash, &salt)
+	if err != nil {
+		return User{}, false
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashSecret(password, salt)), []byte(passwordHash)) != 1 {
+		return User{}, false
+	}
+	return u, true
+}
+
+// function to authenticate a user by API key
+func authenticateApiKey(database *sql.DB, key string) (User, bool) {
+	var u User
+
+	if key == "" {
+		return User{}, false
+	}
+
+	statement, _ := database.Prepare(`
+		SELECT id, username, role FROM users WHERE api_key_hash = ?
+	`)
+	err := statement.QueryRow(hashApiKey(key)).Scan(&u.Id, &u.Username, &u.Role)
+	if err != nil {
+		return User{}, false
+	}
+	return u, true
+}
+
+// function to select all users from database
+func getUsers(database *sql.DB) []User {
+	var users []User
+
+	statement, _ := database.Prepare(`
+		SELECT id, username, role FROM users ORDER BY username ASC
+	`)
+	rows, _ := statement.Query()
+	defer rows.Close()
+
+	for rows.Next() {
+		var u User
+		err := rows.Scan(&u.Id, &u.Username, &u.Role)
+		if err != nil {
+			log.Fatal(err)
+		}
+		users = append(users, u)
+	}
+
+	return users
+}
+
+// function to write an entry to the audit log
+func writeAuditLog(database *sql.DB, username string, action string, outcome string, detail string) {
+	statement, _ := database.Prepare(`
+		INSERT INTO audit_log (created_at, username, action, outcome, detail) VALUES (?, ?, ?, ?, ?)
+	`)
+	statement.Exec(time.Now().UTC().Format(time.RFC3339), username, action, outcome, detail)
+}
+
+// function to select the most recent audit log entries
+func getAuditLog(database *sql.DB, limit int) []AuditEntry {
+	var entries []AuditEntry
+
+	statement, _ := database.Prepare(`
+		SELECT created_at, username, action, outcome, detail FROM audit_log ORDER BY id DESC LIMIT ?
+	`)
+	rows, _ := statement.Query(limit)
+	defer rows.Close()
+
+	for rows.Next() {
+		var e AuditEntry
+		err := rows.Scan(&e.CreatedAt, &e.Username, &e.Action, &e.Outcome, &e.Detail)
+		if err != nil {
+			log.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+
+	return entries
+}
+
+// helper function to get the label of a menu option for the audit log
+func optionLabel(option int) string {
+	for _, o := range menuOptions {
+		if o.Option == option {
+			return o.Label
+		}
+	}
+	return fmt.Sprintf("option %d", option)
+}
+
+// function to check a menu selection against the user's role, writing protected actions to the audit log
+func authorizeOption(database *sql.DB, user User, option int) bool {
+	if !canExecute(user, option) {
+		writeAuditLog(database, user.Username, optionLabel(option), AuditDenied, "role "+user.Role)
+		fmt.Printf("\nYour role (%s) does not allow this option.\n", user.Role)
+		return false
+	}
+	if _, protected := optionRoles[option]; protected {
+		writeAuditLog(database, user.Username, optionLabel(option), AuditAllowed, "role "+user.Role)
+	}
+	return true
+}
+
+// helper function to read a password from stdin without echoing it, the terminal echo is turned off with stty
+// where it exists, elsewhere, e.g. on Windows or with piped input, the password is shown as it is typed
+func readPassword(toRead string) string {
+	stty := func(arg string) error {
+		cmd := exec.Command("stty", arg)
+		cmd.Stdin = os.Stdin
+		return cmd.Run()
+	}
+	if stty("-echo") != nil {
+		return readRequiredString(toRead + " (shown as typed)")
+	}
+
+	s := ""
+	for s == "" {
+		fmt.Printf("Please enter the %s: ", toRead)
+		scanner := bufio.NewScanner(os.Stdin)
+		ok := scanner.Scan()
+		fmt.Println()
+		if !ok {
+			// echo is turned back on before exiting
+			stty("echo")
+			exitAtEndOfInput()
+		}
+		s = strings.TrimSpace(scanner.Text())
+	}
+	stty("echo")
+	return s
+}
+
+// function to authenticate the user of the app, exits if authentication fails
+func login(database *sql.DB) User {
+
+	// a key in the environment skips the prompts
+	if key := os.Getenv(ApiKeyEnvVar); key != "" {
+		u, ok := authenticateApiKey(database, key)
+		if !ok {
+			writeAuditLog(database, "", "login", AuditDenied, "invalid API key")
+			fmt.Println("Invalid API key.")
+			os.Exit(1)
+		}
+		writeAuditLog(database, u.Username, "login", AuditAllowed, "API key")
+		return u
+	}
+
+	// the first account created is the administrator
+	if len(getUsers(database)) == 0 {
+		fmt.Println("\nNo user accounts exist yet, creating the administrator account.")
+		username := readRequiredString("administrator username")
+		password := readPassword("administrator password")
+		err := createUser(database, username, password, RoleAdmin)
+		check(err)
+		writeAuditLog(database, username, "create user", AuditAllowed, "initial administrator")
+		u, _ := authenticatePassword(database, username, password)
+		return u
+	}
+
+	for attempt := 1; attempt <= MaxLoginAttempts; attempt++ {
+		fmt.Println("\nPlease log in.")
+		username := readRequiredString("username")
+		password := readPassword("password")
+
+		u, ok := authenticatePassword(database, username, password)
+		if ok {
+			writeAuditLog(database, u.Username, "login", AuditAllowed, "password")
+			return u
+		}
+		writeAuditLog(database, username, "login", AuditDenied, "invalid username or password")
+		fmt.Println("\nInvalid username or password.")
+	}
+
+	fmt.Println("Too many failed login attempts.")
+	os.Exit(1)
+	return User{}
+}
+
+// function to list, create, edit and delete user accounts
+func manageUsers(database *sql.DB, user User) {
+	selection := 0
+
+	fmt.Printf("\nManage users...\n\n")
+	fmt.Println(" 1. List users")
+	fmt.Println(" 2. Create a user")
+	fmt.Println(" 3. Change a user's role")
+	fmt.Println(" 4. Delete a user")
+	fmt.Println(" 5. Generate an API key")
+	fmt.Println(" 6. Display the audit log")
+
+	// loop until selection is valid
+	for selection == 0 {
+		fmt.Printf("Please choose an option: ")
+
+		_, err := fmt.Scanf("%d", &selection)
+		if isEndOfInput(err) {
+			exitAtEndOfInput()
+		}
+
+		if err != nil || selection < 1 || selection > 6 {
+			selection = 0
+			fmt.Println("\nPlease enter a valid integer between 1 and 6.")
+		}
+	}
+
+	var err error
+
+	switch selection {
+		case 1:
+			for _, u := range getUsers(database) {
+				fmt.Printf(" %-20s %s\n", u.Username, u.Role)
+			}
+		case 2:
+			username := readRequiredString("username")
+			password := readPassword("password")
+			role := readRequiredString("role (viewer, editor or admin)")
+			err = createUser(database, username, password, role)
+			if err == nil {
+				writeAuditLog(database, user.Username, "create user", AuditAllowed, username+" as "+role)
+				fmt.Printf("\nCreated user %s.\n", username)
+			}
+		case 3:
+			username := readRequiredString("username")
+			role := readRequiredString("new role (viewer, editor or admin)")
+			err = setUserRole(database, username, role)
+			if err == nil {
+				writeAuditLog(database, user.Username, "change role", AuditAllowed, username+" to "+role)
+				fmt.Printf("\nUser %s is now %s.\n", username, role)
+			}
+		case 4:
+			username := readRequiredString("username")
+			if username == user.Username {
+				err = errors.New("you cannot delete your own account")
+			} else {
+				err = deleteUser(database, username)
+			}
+			if err == nil {
+				writeAuditLog(database, user.Username, "delete user", AuditAllowed, username)
+				fmt.Printf("\nDeleted user %s.\n", username)
+			}
+		case 5:
+			username := readRequiredString("username")
+			var key string
+			key, err = generateApiKey(database, username)
+			if err == nil {
+				writeAuditLog(database, user.Username, "generate API key", AuditAllowed, username)
+				fmt.Printf("\nAPI key for %s (shown only once, set it in %s): %s\n", username, ApiKeyEnvVar, key)
+			}
+		case 6:
+			for _, e := range getAuditLog(database, 25) {
+				fmt.Printf(" %s %-15s %-8s %s (%s)\n", e.CreatedAt, e.Username, e.Outcome, e.Action, e.Detail)
+			}
+	}
+
+	if err != nil {
+		fmt.Printf("\nError: %v\n", err)
+	}
+}
@@ -0,0 +1,361 @@
+// CST8333 Cheese Directory App - Cheese Board Builder - Lucas Estienne
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"database/sql"
+	"errors"
+	"flag"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// most firmness categories a board must mix
+const boardFirmnessMix = 3
+
+// most combinations tried before giving up on a board
+const boardSearchLimit = 1000000
+
+// number of cheeses of a board when none is given, there are few more milk types
+const DefaultBoardSize = 3
+
+// simple data structure containing the constraints a cheese board must satisfy
+type BoardConstraints struct {
+	Size int
+	Province string
+	DistinctMilk bool
+	MixFirmness bool
+	Organic bool
+	InStock bool
+}
+
+// simple data structure containing a cheese chosen for a board and the reasons it was chosen
+type BoardPick struct {
+	Record Record
+	Reasons []string
+}
+
+// helper function to get the milk types of a record as one value, e.g. "cow and goat"
+func boardMilk(r Record) string {
+	types := r.MilkTypes()
+	sort.Strings(types)
+	return strings.ToLower(renderMilkTypes(types).String)
+}
+
+// helper function to get the individual milk types of a record, e.g. "cow" and "goat" for a mixed milk cheese
+func boardMilkTypes(r Record) []string {
+	var types []string
+	for _, t := range r.MilkTypes() {
+		types = append(types, strings.ToLower(t))
+	}
+	return types
+}
+
+// function to describe the quantity in stock of each cheese, by CheeseId
+func getStockSummary(database *sql.DB) map[int]string {
+	stock := map[int]string{}
+
+	rows, err := database.Query(`
+		SELECT cheese_id, unit, SUM(quantity) FROM inventory_lots
+		GROUP BY cheese_id, unit HAVING SUM(quantity) > 0 ORDER BY cheese_id, unit
+	`)
+	check(err)
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cheeseId int
+			unit string
+			quantity float64
+		)
+		if err = rows.Scan(&cheeseId, &unit, &quantity); err != nil {
+			log.Fatal(err)
+		}
+		if stock[cheeseId] != "" {
+			stock[cheeseId] += " and "
+		}
+		stock[cheeseId] += formatQuantity(quantity, unit)
+	}
+
+	return stock
+}
+
+// function to list the cheeses that may go on a board, one record per cheese, the best rated first
+func boardCandidates(rs []Record, stock map[int]string, c BoardConstraints) []Record {
+	var candidates []Record
+	seen := map[int]bool{}
+
+	for _, r := range rs {
+		switch {
+			case seen[r.CheeseId]:
+			case c.Province != "" && !strings.EqualFold(strings.TrimSpace(r.ManufacturerProvCode.String), c.Province):
+			case c.InStock && stock[r.CheeseId] == "":
+			case c.DistinctMilk && len(boardMilkTypes(r)) == 0:
+			// categories such as fresh or veined say nothing of firmness, it is computed from moisture and fat
+			case c.MixFirmness && r.FirmnessClass() == UnknownClass:
+			default:
+				seen[r.CheeseId] = true
+				candidates = append(candidates, r)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.AverageRating.Float64 != b.AverageRating.Float64 {
+			return a.AverageRating.Float64 > b.AverageRating.Float64
+		}
+		if a.ReviewCount != b.ReviewCount {
+			return a.ReviewCount > b.ReviewCount
+		}
+		return a.CheeseId < b.CheeseId
+	})
+	return candidates
+}
+
+// function to assemble a cheese board satisfying the constraints, preferring the best rated cheeses
+func buildBoard(rs []Record, stock map[int]string, c BoardConstraints) ([]BoardPick, error) {
+	if c.Size < 1 {
+		return nil, fmt.Errorf("invalid board size %d", c.Size)
+	}
+
+	candidates := boardCandidates(rs, stock, c)
+	if len(candidates) < c.Size {
+		return nil, fmt.Errorf("only %d cheeses match the constraints, a board needs %d", len(candidates), c.Size)
+	}
+
+	// milk types from each candidate on, to know early when too few new milk types are left
+	milksLeft := make([]map[string]bool, len(candidates) + 1)
+	milksLeft[len(candidates)] = map[string]bool{}
+	for i := len(candidates) - 1; i >= 0; i-- {
+		milksLeft[i] = map[string]bool{}
+		for m := range milksLeft[i + 1] {
+			milksLeft[i][m] = true
+		}
+		for _, m := range boardMilkTypes(candidates[i]) {
+			milksLeft[i][m] = true
+		}
+	}
+	if c.DistinctMilk && len(milksLeft[0]) < c.Size {
+		return nil, fmt.Errorf("only %d milk types among the cheeses matching the constraints, a board of distinct milk types needs %d",
+			len(milksLeft[0]), c.Size)
+	}
+
+	firmnessNeeded := 0
+	if c.MixFirmness {
+		firmnessNeeded = c.Size
+		if firmnessNeeded > boardFirmnessMix {
+			firmnessNeeded = boardFirmnessMix
+		}
+	}
+
+	// number of organic cheeses from each candidate on, to know early when no organic cheese is left
+	organicLeft := make([]int, len(candidates) + 1)
+	for i := len(candidates) - 1; i >= 0; i-- {
+		organicLeft[i] = organicLeft[i + 1]
+		if candidates[i].Organic {
+			organicLeft[i]++
+		}
+	}
+
+	var chosen []Record
+	milks, firmness := map[string]int{}, map[string]int{}
+	organic, steps := 0, 0
+
+	// depth-first search through the candidates, best rated first, undoing choices that lead nowhere
+	var search func(start int) bool
+	search = func(start int) bool {
+		left := c.Size - len(chosen)
+		if left == 0 {
+			return (!c.Organic || organic > 0) && len(firmness) >= firmnessNeeded
+		}
+		steps++
+		if steps > boardSearchLimit || len(candidates) - start < left || len(firmness) + left < firmnessNeeded {
+			return false
+		}
+		if c.Organic && organic == 0 && organicLeft[start] == 0 {
+			return false
+		}
+		if c.DistinctMilk {
+			// each cheese left needs a milk type no chosen cheese has
+			unused := 0
+			for m := range milksLeft[start] {
+				if milks[m] == 0 {
+					unused++
+				}
+			}
+			if unused < left {
+				return false
+			}
+		}
+
+		for i := start; i < len(candidates); i++ {
+			r := candidates[i]
+			milk, firm := boardMilkTypes(r), r.FirmnessClass()
+			// a mixed milk cheese shares its milk types with the cheeses of each of them
+			shared := false
+			for _, m := range milk {
+				shared = shared || milks[m] > 0
+			}
+			if c.DistinctMilk && shared {
+				continue
+			}
+
+			chosen = append(chosen, r)
+			for _, m := range milk {
+				milks[m]++
+			}
+			firmness[firm]++
+			if r.Organic {
+				organic++
+			}
+			if search(i + 1) {
+				return true
+			}
+			chosen = chosen[:len(chosen) - 1]
+			for _, m := range milk {
+				if milks[m]--; milks[m] == 0 {
+					delete(milks, m)
+				}
+			}
+			if firmness[firm]--; firmness[firm] == 0 {
+				delete(firmness, firm)
+			}
+			if r.Organic {
+				organic--
+			}
+		}
+		return false
+	}
+
+	if !search(0) {
+		return nil, errors.New("no combination of cheeses satisfies the constraints, try relaxing some")
+	}
+
+	var picks []BoardPick
+	for _, r := range chosen {
+		p := BoardPick{Record: r}
+		if c.DistinctMilk {
+			p.Reasons = append(p.Reasons, "the only " + boardMilk(r) + " milk cheese")
+		} else if milk := boardMilk(r); milk != "" {
+			p.Reasons = append(p.Reasons, milk + " milk")
+		}
+		if c.MixFirmness && firmness[r.FirmnessClass()] == 1 {
+			p.Reasons = append(p.Reasons, "the only " + r.FirmnessClass() + " cheese")
+		} else if firm := r.FirmnessClass(); firm != UnknownClass {
+			p.Reasons = append(p.Reasons, firm)
+		}
+		if r.Organic && c.Organic && organic == 1 {
+			p.Reasons = append(p.Reasons, "the organic cheese")
+		} else if r.Organic {
+			p.Reasons = append(p.Reasons, "organic")
+		}
+		if c.Province != "" {
+			p.Reasons = append(p.Reasons, "made in " + strings.ToUpper(c.Province))
+		}
+		if r.ReviewCount > 0 {
+			p.Reasons = append(p.Reasons, describeRating(r))
+		}
+		if stock[r.CheeseId] != "" {
+			p.Reasons = append(p.Reasons, stock[r.CheeseId] + " in stock")
+		}
+		picks = append(picks, p)
+	}
+	return picks, nil
+}
+
+// helper function to get the CheeseIds of the cheeses of a board
+func boardCheeseIds(picks []BoardPick) []int {
+	var ids []int
+	for _, p := range picks {
+		ids = append(ids, p.Record.CheeseId)
+	}
+	return ids
+}
+
+// function to print a cheese board as a table with the reasons each cheese was chosen
+func printBoard(picks []BoardPick) {
+	rows := [][]string{{"#", "CheeseId", "CheeseName", "ManufacturerName", "Why"}}
+	for i, p := range picks {
+		rows = append(rows, []string{
+			strconv.Itoa(i + 1), strconv.Itoa(p.Record.CheeseId), displayString(p.Record.CheeseName),
+			displayString(p.Record.ManufacturerName), strings.Join(p.Reasons, "; "),
+		})
+	}
+
+	writeAlignedTable(os.Stdout, rows)
+}
+
+// function to build a cheese board, print it and save it as a collection when a name is given
+func outputBoard(database *sql.DB, user User, c BoardConstraints, collection string) error {
+	picks, err := buildBoard(getAllCheeses(database), getStockSummary(database), c)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\nCheese board of %d cheeses:\n\n", len(picks))
+	printBoard(picks)
+
+	if collection == "" {
+		return nil
+	}
+	added, err := addToCollection(database, user.Username, collection, boardCheeseIds(picks))
+	if err == nil {
+		fmt.Printf("\nAdded %d cheeses to collection %s.\n", added, collection)
+	}
+	return err
+}
+
+// helper function to read a yes/no constraint from stdin, Enter keeps the default
+func readConstraint(toRead string, def bool) bool {
+	b, err := strconv.ParseBool(readNewOrKeepDefaultString(toRead + " (true/false)", strconv.FormatBool(def)))
+	if err != nil {
+		return def
+	}
+	return b
+}
+
+// function to build a cheese board from the menu
+func displayBoard(database *sql.DB, user User) {
+	c := BoardConstraints{}
+
+	size, err := strconv.Atoi(readNewOrKeepDefaultString("number of cheeses", strconv.Itoa(DefaultBoardSize)))
+	if err != nil {
+		size = DefaultBoardSize
+	}
+	c.Size = size
+	c.DistinctMilk = readConstraint("distinct milk types", true)
+	c.MixFirmness = readConstraint("mix of firmness categories", true)
+	c.Organic = readConstraint("at least one organic cheese", true)
+	c.InStock = readConstraint("only cheeses in stock", true)
+	c.Province = readString("province code (Enter for any)")
+	collection := readString("save as collection (Enter to not save)")
+
+	if err = outputBoard(database, user, c, collection); err != nil {
+		fmt.Printf("\nError: %v\n", err)
+	}
+}
+
+// function to run the "board" command
+func boardCommand(database *sql.DB, user User, args []string) error {
+	flags := flag.NewFlagSet("board", flag.ContinueOnError)
+	size := flags.Int("n", DefaultBoardSize, "number of cheeses")
+	province := flags.String("province", "", "province code of the manufacturers, any when empty")
+	distinctMilk := flags.Bool("distinct-milk", true, "each cheese of a different milk type")
+	mixFirmness := flags.Bool("mix-firmness", true, fmt.Sprintf("at least %d firmness categories", boardFirmnessMix))
+	organic := flags.Bool("organic", true, "at least one organic cheese")
+	inStock := flags.Bool("in-stock", true, "only cheeses in stock")
+	collection := flags.String("save", "", "collection to add the board to")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+
+	c := BoardConstraints{*size, strings.TrimSpace(*province), *distinctMilk, *mixFirmness, *organic, *inStock}
+	return outputBoard(database, user, c, *collection)
+}
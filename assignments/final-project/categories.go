@@ -0,0 +1,147 @@
+// CST8333 Cheese Directory App - Category Consistency Checker - Lucas Estienne
+
+package main
+
+import (
+	"fmt"
+	"database/sql"
+	"flag"
+	"strings"
+)
+
+// firmness class declared by each category, categories not listed (fresh, veined) are not about firmness
+var categoryFirmness = map[string]string {
+	"Soft Cheese": "soft",
+	"Pâte molle": "soft",
+	"Semi-soft Cheese": "semi-soft",
+	"Pâte demi-ferme": "semi-soft",
+	"Firm Cheese": "firm",
+	"Pâte ferme": "firm",
+	"Hard Cheese": "hard",
+	"Pâte dure": "hard",
+}
+
+// category suggested for each firmness class
+var firmnessCategory = map[string]string {
+	"soft": "Soft Cheese",
+	"semi-soft": "Semi-soft Cheese",
+	"firm": "Firm Cheese",
+	"hard": "Hard Cheese",
+}
+
+// simple data structure containing a record whose category contradicts its computed firmness
+type CategoryMismatch struct {
+	Index int
+	Record Record
+	Declared string
+	Computed string
+	MFFB float64
+}
+
+// function to compare each record's declared category with its MFFB firmness class
+func checkCategoryConsistency(records []Record) []CategoryMismatch {
+	var mismatches []CategoryMismatch
+
+	for i, r := range records {
+		declared, ok := categoryFirmness[strings.TrimSpace(r.CategoryType.String)]
+		if !ok {
+			continue
+		}
+		mffb, ok := r.MFFB()
+		if !ok {
+			continue
+		}
+		if computed := classify(mffb, firmnessClasses); computed != declared {
+			mismatches = append(mismatches, CategoryMismatch{i, r, declared, computed, mffb})
+		}
+	}
+
+	return mismatches
+}
+
+// helper function to describe a mismatch with the numbers that triggered it
+func describeCategoryMismatch(m CategoryMismatch) string {
+	return fmt.Sprintf("#%d CheeseId %d %s: declared %q (%s) but %.2f%% moisture and %.2f%% fat give MFFB %.1f%% (%s)",
+		m.Index, m.Record.CheeseId, displayString(m.Record.CheeseName), m.Record.CategoryType.String, m.Declared,
+		m.Record.MoisturePercent.Float64, m.Record.FatContentPercent.Float64, m.MFFB, m.Computed)
+}
+
+// function to list category mismatches and let editors fix them, returns the records and whether any changed
+func checkCategories(database *sql.DB, user User, records []Record) ([]Record, bool) {
+	changed := false
+
+	fmt.Printf("\nChecking categories against the firmness computed from moisture on fat-free basis...\n\n")
+
+	mismatches := checkCategoryConsistency(records)
+	for _, m := range mismatches {
+		fmt.Println(describeCategoryMismatch(m))
+	}
+	fmt.Printf("\n%d of %d records have a category contradicting their MFFB.\n", len(mismatches), len(records))
+
+	if len(mismatches) == 0 || !hasRole(user, RoleEditor) {
+		return records, false
+	}
+	if !strings.HasPrefix(strings.ToLower(readNewOrKeepDefaultString("fix the mismatches now (y/n)", "n")), "y") {
+		return records, false
+	}
+
+	vocab := loadVocabulary(database)
+	attrs := loadCustomAttributes(database)
+	for _, m := range mismatches {
+		suggested := firmnessCategory[m.Computed]
+
+		fmt.Printf("\n%s\n", describeCategoryMismatch(m))
+		choice := strings.ToLower(readNewOrKeepDefaultString(
+			fmt.Sprintf("action: [c]hange category to %q, [e]dit the record, [s]kip, [q]uit", suggested), "s"))
+
+		switch {
+			case strings.HasPrefix(choice, "c"):
+				records[m.Index].CategoryType = nullString(suggested)
+				records[m.Index].LastUpdateDate = today()
+			case strings.HasPrefix(choice, "e"):
+				// same prompts as editing a record from the menu
+				records[m.Index] = editRecordFields(records[m.Index], vocab, attrs)
+			case strings.HasPrefix(choice, "q"):
+				return records, changed
+			default:
+				continue
+		}
+
+		changed = true
+		writeAuditLog(database, user.Username, "fix category", AuditAllowed,
+			fmt.Sprintf("CheeseId %d: %q to %q", m.Record.CheeseId, m.Record.CategoryType.String, records[m.Index].CategoryType.String))
+		fmt.Printf("\n Changed the record to record: \n%s\n", renderRecord(records[m.Index]))
+	}
+
+	return records, changed
+}
+
+// function to run the "check-categories" command
+func checkCategoriesCommand(database *sql.DB, user User, args []string) error {
+	flags := flag.NewFlagSet("check-categories", flag.ContinueOnError)
+	fix := flags.Bool("fix", false, "fix the mismatches interactively")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	records := getAllCheeses(database)
+
+	if !*fix {
+		for _, m := range checkCategoryConsistency(records) {
+			fmt.Println(describeCategoryMismatch(m))
+		}
+		return nil
+	}
+
+	if !hasRole(user, RoleEditor) {
+		writeAuditLog(database, user.Username, "check-categories -fix", AuditDenied, "role "+user.Role)
+		return fmt.Errorf("your role (%s) does not allow fixing records", user.Role)
+	}
+
+	records, changed := checkCategories(database, user, records)
+	if changed {
+		// sync records data structure with database, same as the menu
+		syncDb(records, database)
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"database/sql"
 	"log"
@@ -18,6 +19,7 @@ import (
 )
 
 const NumRecordsToLoad = 10000
+const DataFilePath = "data/canadianCheeseDirectory.csv"
 
 const (
 	OptionReload = 1
@@ -28,67 +30,122 @@ const (
 	OptionEdit = 6
 	OptionDelete = 7
 	OptionSearch = 8
-	OptionExit = 9
+	OptionManageUsers = 9
+	OptionStatistics = 10
+	OptionPivot = 11
+	OptionQuality = 12
+	OptionCheckCategories = 13
+	OptionManufacturers = 14
+	OptionVocabularies = 15
+	OptionRecentChanges = 16
+	OptionCustomAttributes = 17
+	OptionReviews = 18
+	OptionCollections = 19
+	OptionInventory = 20
+	OptionSimilar = 21
+	OptionBoard = 22
+	OptionCompare = 23
+	OptionDiff = 24
+	OptionMerge = 25
+	OptionDuplicates = 26
+	OptionExit = 27
 )
 
 // simple data structure containing a string
 type Record struct {
 	CheeseId int
-	CheeseName string
-	ManufacturerName string
-	ManufacturerProvCode string
-	ManufacturingType string
-	WebSite string
-	FatContentPercent float32
-	MoisturePercent float32
-	Particularities string
-	Flavour string
-	Characteristics string
-	Ripening string
+	CheeseName sql.NullString
+	ManufacturerName sql.NullString
+	ManufacturerProvCode sql.NullString
+	ManufacturingType sql.NullString
+	WebSite sql.NullString
+	FatContentPercent sql.NullFloat64
+	MoisturePercent sql.NullFloat64
+	Particularities sql.NullString
+	Flavour sql.NullString
+	Characteristics sql.NullString
+	Ripening sql.NullString
 	Organic bool
-	CategoryType string
-	MilkType string
-	MilkTreatmentType string
-	RindType string
-	LastUpdateDate string
+	CategoryType sql.NullString
+	MilkType sql.NullString
+	MilkTreatmentType sql.NullString
+	RindType sql.NullString
+	LastUpdateDate Date
+	AverageRating sql.NullFloat64
+	ReviewCount int
+	// values of the custom attributes, by attribute name
+	Attributes map[string]string
 }
 
 // main function, this is the entrypoint
 func main() {
 
-	// load data
-	records := loadData("data/canadianCheeseDirectory.csv", NumRecordsToLoad)
-
 	// init db
 	database := initCheesesDatabase("./cheesedir.db")
 
-	// sync in-memory records data structure with database 
-	syncDb(records, database)
+	// data files are decoded as the environment says, or else their encoding is detected
+	if encoding := os.Getenv(EncodingEnvVar); encoding != "" {
+		check(setDataFileEncoding(encoding))
+	}
+
+	// load records from the database, or from the data file the first time
+	records := getAllCheeses(database)
+	if len(records) == 0 {
+		records = importRecords(database, findDataFile(DataFilePath))
+	}
+
+	// categorical values are picked from the controlled vocabularies
+	seedVocabularies(database, findDataFile(DataFilePath))
+	vocab := loadVocabulary(database)
+	// custom attributes are prompted for after the fields
+	attrs := loadCustomAttributes(database)
+
+	// authenticate before showing the menu
+	user := login(database)
+
+	// run a single command instead of the menu when one is given, e.g. "cheesedir stats"
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(database, user, os.Args[1:]))
+	}
 
 	// loop until exit
 	for true {
-		// display menu and process choice
-		switch selection := showMenu(); selection {
+		// display menu and get choice
+		selection := showMenu(user)
+
+		// make sure the user's role allows the choice, denied attempts are audited
+		if !authorizeOption(database, user, selection) {
+			time.Sleep(1 * time.Second)
+			continue
+		}
+
+		// process choice
+		switch selection {
 			case OptionReload:
+				// the data file may be compressed, stdin is left to the menu
+				filePath := readNewOrKeepDefaultString("data file to reload from", findDataFile(DataFilePath))
+				if filePath == StdinPath {
+					fmt.Println("\nData can only be read from stdin by commands.")
+					break
+				}
+				readEncoding()
 				fmt.Println("Reloading data...")
-				// reload records
-				records = loadData("data/canadianCheeseDirectory.csv", NumRecordsToLoad)
-				// sync in-memory records data structure with database 
-				syncDb(records, database)
+				// reload records, mapping categorical values onto the vocabularies
+				records = importRecords(database, filePath)
 			case OptionPersist:
 				persistToFile(database, "cheese_directory_output.csv")
 			case OptionDisplayAll:
 				displayAllRecords(database)
 			case OptionCreate:
 				// create record
-				records = createRecord(records)
+				records = createRecord(records, vocab, attrs)
 				// sync in-memory records data structure with database 
 				syncDb(records, database)
 			case OptionDisplay:
 				displayRecord(database)
 			case OptionEdit:
 				// edit record
-				editRecord(records)
+				editRecord(records, vocab, attrs)
 				// sync in-memory records data structure with database 
 				syncDb(records, database)
 			case OptionDelete:
@@ -98,6 +155,59 @@ func main() {
 				syncDb(records, database)
 			case OptionSearch:
 				searchRecords(database)
+			case OptionManageUsers:
+				manageUsers(database, user)
+			case OptionStatistics:
+				displayStatistics(database)
+			case OptionPivot:
+				displayPivotTable(database)
+			case OptionQuality:
+				displayQualityReport(database)
+			case OptionCheckCategories:
+				// check categories, editors can fix the mismatches
+				var changed bool
+				records, changed = checkCategories(database, user, records)
+				if changed {
+					// sync in-memory records data structure with database 
+					syncDb(records, database)
+				}
+			case OptionManufacturers:
+				if manageManufacturers(database, user) {
+					// reload in-memory records to get the new manufacturer names
+					records = getAllCheeses(database)
+				}
+			case OptionVocabularies:
+				if manageVocabularies(database, user) {
+					// reload the pick-lists with the new terms
+					vocab = loadVocabulary(database)
+				}
+			case OptionRecentChanges:
+				displayRecentChanges(database)
+			case OptionCustomAttributes:
+				if manageCustomAttributes(database, user) {
+					// reload the attributes prompted for, and the records without the values of deleted attributes
+					attrs = loadCustomAttributes(database)
+					records = getAllCheeses(database)
+				}
+			case OptionReviews:
+				manageReviews(database, user)
+			case OptionCollections:
+				manageCollections(database, user)
+			case OptionInventory:
+				manageInventory(database, user)
+			case OptionSimilar:
+				displaySimilar(database)
+			case OptionBoard:
+				displayBoard(database, user)
+			case OptionCompare:
+				displayComparison(database)
+			case OptionDiff:
+				displayDiff(database)
+			case OptionMerge:
+				// merge a new release, keeping local creates, edits and deletes
+				records = displayMergeImport(database, records)
+			case OptionDuplicates:
+				records = manageDuplicates(database, user, records)
 			case OptionExit:
 				fmt.Println("Goodbye")
 				return
@@ -113,32 +223,24 @@ func initCheesesDatabase(filePath string) *sql.DB {
 	// open db
 	database, _ := sql.Open("sqlite3", filePath)
 
-	// create table if not exist
+	// create table if not exist, its columns come from the field registry
 	statement, _ := database.Prepare(`
 		CREATE TABLE IF NOT EXISTS cheeses (
-			id INTEGER PRIMARY KEY,
-			cheese_id INTEGER,
-			cheese_name TEXT,
-			manufacturer_name TEXT,
-			manufacturer_prov_code TEXT,
-			manufacturing_type TEXT,
-			website TEXT,
-			fat_content_percent REAL,
-			moisture_percent REAL,
-			particularities TEXT,
-			flavour TEXT,
-			characteristics TEXT,
-			ripening TEXT,
-			organic INTEGER,
-			category_type TEXT,
-			milk_type TEXT,
-			milk_treatment_type TEXT,
-			rind_type TEXT,
-			last_update_date TEXT
+			id INTEGER PRIMARY KEY
 		)
 	`)
 	statement.Exec()
 
+	// create user and audit tables
+	initUsersTables(database)
+
+	// columns of the record fields, the migrations read them
+	addFieldColumns(database)
+	// bring older databases up to date
+	runMigrations(database)
+	// view of the record fields
+	syncFieldSchema(database)
+
 	return database
 }
 
@@ -151,27 +253,32 @@ func syncDb(records []Record, database *sql.DB) {
 	`)
 	// exec delete
 	statement.Exec()
+	_, err := database.Exec(`DELETE FROM cheese_milk_types`)
+	check(err)
+	_, err = database.Exec(`DELETE FROM cheese_attributes`)
+	check(err)
+
+	// categorical values are stored as the codes of their terms
+	vocab := loadVocabulary(database)
 
 	// loop through all records
 	for i := 0; i < len(records); i++ {
-		// prepare insert
-		statement, _ = database.Prepare(`
-			INSERT INTO cheeses (
-				cheese_id, cheese_name, manufacturer_name, manufacturer_prov_code,
-				manufacturing_type, website, fat_content_percent, moisture_percent,
-				particularities, flavour, characteristics, ripening,
-				organic, category_type, milk_type, milk_treatment_type,
-				rind_type, last_update_date
-			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-		`)
+		// manufacturers have their own table
+		manufacturerId, err := manufacturerIdForRecord(database, records[i])
+		check(err)
+
+		// prepare insert, stored fields come from the field registry
+		statement, _ = database.Prepare(insertRecordSql())
 		// exec insert
-		statement.Exec(
-			records[i].CheeseId, records[i].CheeseName, records[i].ManufacturerName, records[i].ManufacturerProvCode,
-			records[i].ManufacturingType, records[i].WebSite, records[i].FatContentPercent, records[i].MoisturePercent,
-			records[i].Particularities, records[i].Flavour, records[i].Characteristics, records[i].Ripening,
-			records[i].Organic, records[i].CategoryType, records[i].MilkType, records[i].MilkTreatmentType,
-			records[i].RindType, records[i].LastUpdateDate,
-		)
+		result, err := statement.Exec(insertRecordArgs(vocab.storedRecord(records[i]), manufacturerId)...)
+		check(err)
+
+		// milk types are also stored as a set
+		recordId, err := result.LastInsertId()
+		check(err)
+		check(insertMilkTypes(database, recordId, milkTypeCodes(vocab, records[i].MilkType.String)))
+		// custom attributes are stored in their own table
+		check(insertRecordAttributes(database, recordId, records[i].Attributes))
 	}
 }
 
@@ -183,62 +290,40 @@ func check(e error) {
     }
 }
 
-// helper function to return the first of two non empty strings, or the string "N/A"
-func getFirstNonEmptyStringOrNA(first string, second string) string {
-	if strings.TrimSpace(first) != "" {
-		return first
-	} else if strings.TrimSpace(second) != "" {
-		return second
-	} else {
-		return "N/A"
-	}
-}
-
-// helper function to read CSV
+// helper function to read CSV, plain, gzip-compressed, in a zip archive or from stdin, transcoded to UTF-8
 func getLinesFromCSV(filePath string) (lines [][]string, err error) {
 	// open file
-	file, err := os.Open(filePath)
-	check(err)
+	file, err := openDataFile(filePath)
+	if err != nil {
+		return nil, err
+	}
 	defer file.Close() // defer closing the file until function returns
 
-	// create CSV Reader from file
-	reader := csv.NewReader(file)
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	text, decoding, err := decodeText(data, dataFileEncoding)
+	if err != nil {
+		return nil, err
+	}
+	warnInvalidEncoding(filePath, decoding)
+
+	// create CSV Reader from the text
+	reader := csv.NewReader(strings.NewReader(text))
 	return reader.ReadAll()
 }
 
-// function to convert CSV line to Record object
-func lineToRecord(line []string) Record {
-
-	// parse some values from strings
-	cheeseId, err := strconv.ParseInt(line[0], 10, 64)
-	if err != nil { cheeseId = 0 }
-	fatContentPercent, err := strconv.ParseFloat(line[10], 32)
-	if err != nil { fatContentPercent = 0.0 }
-	moisturePercent, err := strconv.ParseFloat(line[11], 32)
-	if err != nil { moisturePercent = 0.0 }
-	organic, err := strconv.ParseBool(line[20])
-	if err != nil { organic = false }
-
-	return Record {
-		CheeseId: int(cheeseId),
-		CheeseName: getFirstNonEmptyStringOrNA(line[1], line[2]),
-		ManufacturerName: getFirstNonEmptyStringOrNA(line[3], line[4]),
-		ManufacturerProvCode: getFirstNonEmptyStringOrNA(line[5], "??"),
-		ManufacturingType: getFirstNonEmptyStringOrNA(line[6], line[7]),
-		WebSite: getFirstNonEmptyStringOrNA(line[8], line[9]),
-		FatContentPercent: float32(fatContentPercent),
-		MoisturePercent: float32(moisturePercent),
-		Particularities: getFirstNonEmptyStringOrNA(line[12], line[13]),
-		Flavour: getFirstNonEmptyStringOrNA(line[14], line[15]),
-		Characteristics: getFirstNonEmptyStringOrNA(line[16], line[17]),
-		Ripening: getFirstNonEmptyStringOrNA(line[18], line[19]),
-		Organic: organic,
-		CategoryType: getFirstNonEmptyStringOrNA(line[21], line[22]),
-		MilkType: getFirstNonEmptyStringOrNA(line[23], line[24]),
-		MilkTreatmentType: getFirstNonEmptyStringOrNA(line[25], line[26]),
-		RindType: getFirstNonEmptyStringOrNA(line[27], line[28]),
-		LastUpdateDate: line[29],
+// function to convert CSV line to Record object, columns are found by their header
+func lineToRecord(line []string, indexes map[string]int) Record {
+	var r Record
+
+	// bilingual fields keep the English value, or the French one when it is missing
+	for _, f := range recordFields {
+		parseField(&r, f, getFirstNonEmptyString(csvValue(line, indexes, f.HeaderEn), csvValue(line, indexes, f.HeaderFr)).String)
 	}
+
+	return r
 }
 
 // function load or reload data
@@ -251,46 +336,82 @@ func loadData(filePath string, numRecords int) []Record {
 	check(err)
 
 	// get rid of column names
+	indexes := csvHeaderIndexes(lines[0])
 	lines = lines[1:]
 
 	// convert lines to records slice
 	for i := 0; i < numRecords && i < len(lines); i++ {
-		records = append(records, lineToRecord(lines[i]))
+		records = append(records, lineToRecord(lines[i], indexes))
 	}
 	
 
 	return records
 }
 
+// menu option labels, in display order
+var menuOptions = []struct {
+	Option int
+	Label string
+} {
+	{OptionReload, "Reload the data"},
+	{OptionPersist, "Persist the records in database to file"},
+	{OptionDisplayAll, "Display all records from database (uses multithreading)"},
+	{OptionCreate, "Create a new record"},
+	{OptionDisplay, "Display a record from database"},
+	{OptionEdit, "Edit a record"},
+	{OptionDelete, "Delete a record"},
+	{OptionSearch, "Search a record"},
+	{OptionManageUsers, "Manage users"},
+	{OptionStatistics, "Display directory statistics"},
+	{OptionPivot, "Cross-tabulate two columns (pivot report)"},
+	{OptionQuality, "Data quality audit report"},
+	{OptionCheckCategories, "Check categories against computed firmness"},
+	{OptionManufacturers, "Manufacturers (list, rename, merge, view cheeses)"},
+	{OptionVocabularies, "Controlled vocabularies (terms, aliases, unmapped values)"},
+	{OptionRecentChanges, "Most recently changed cheeses"},
+	{OptionCustomAttributes, "Custom attributes (list, define, delete)"},
+	{OptionReviews, "Tasting reviews (list, rate a cheese, delete)"},
+	{OptionCollections, "Your collections and favourites (display, add, remove, export)"},
+	{OptionInventory, "Shop inventory (stock, receive, sell, waste, alerts, valuation)"},
+	{OptionSimilar, "Find cheeses similar to a cheese"},
+	{OptionBoard, "Build a cheese board"},
+	{OptionCompare, "Compare cheeses side by side"},
+	{OptionDiff, "Compare two releases of the data file, or a release against the database"},
+	{OptionMerge, "Merge a new release of the data file, keeping local edits"},
+	{OptionDuplicates, "Duplicate records (candidates, merge, merge history)"},
+	{OptionExit, "Exit"},
+}
+
 // function to show menu and return the user selection
-func showMenu() int {
+func showMenu(user User) int {
 
 	selection := 0
 
 	fmt.Println("\nLucas Estienne's Canadian Cheese Directory App")
+	fmt.Printf("Logged in as %s (%s)\n", user.Username, user.Role)
 	fmt.Println("Please choose from the following options:")
-	fmt.Printf(" %d. Reload the data\n", OptionReload)
-	fmt.Printf(" %d. Persist the records in database to file\n", OptionPersist)
-	fmt.Printf(" %d. Display all records from database (uses multithreading)\n", OptionDisplayAll)
-	fmt.Printf(" %d. Create a new record\n", OptionCreate)
-	fmt.Printf(" %d. Display a record from database\n", OptionDisplay)
-	fmt.Printf(" %d. Edit a record\n", OptionEdit)
-	fmt.Printf(" %d. Delete a record\n", OptionDelete)
-	fmt.Printf(" %d. Search a record\n", OptionSearch)
-	fmt.Printf(" %d. Exit\n", OptionExit)
+	// only show the options the user's role allows
+	for _, o := range menuOptions {
+		if canExecute(user, o.Option) {
+			fmt.Printf(" %d. %s\n", o.Option, o.Label)
+		}
+	}
 
 	// loop until selection is valid
 	for selection == 0 {
 		fmt.Printf("Please choose an option: ")
 
 		_, err := fmt.Scanf("%d", &selection)
+		if isEndOfInput(err) {
+			exitAtEndOfInput()
+		}
 
 		if err != nil {
 			selection = 0
 			fmt.Println("\nPlease enter a valid option.")
 		} else if selection < OptionReload || selection > OptionExit {
 			selection = 0
-			fmt.Println("\nPlease enter a valid integer between 1 and 8.")
+			fmt.Printf("\nPlease enter a valid integer between %d and %d.\n", OptionReload, OptionExit)
 		}
 	}
 
@@ -311,7 +432,7 @@ func displayAllRecords(database *sql.DB) {
 		go func(id int, r Record) {
 			// At the end of the goroutine, tell the waitgroup that the thread has completed
 			defer wg.Done()
-			fmt.Printf("Record ID: %d: %+v\n", id, r)
+			fmt.Printf("Record ID: %d: %s %s, %s\n", id, renderRecord(r), classificationSummary(r), describeRating(r))
 		}(i, rs[i])
 	}
 }
@@ -320,9 +441,20 @@ func displayAllRecords(database *sql.DB) {
 func searchRecords(database *sql.DB) {
 	fmt.Printf("\nSearch for a record...\n\n")
 
-	colOne, valOne := searchRecordHelper()
-	colTwo, valTwo := searchRecordHelper()
-	colThree, valThree := searchRecordHelper()
+	// custom attributes can be filtered on too
+	attrColumns := attributeColumns(loadCustomAttributes(database))
+	colOne, valOne := searchRecordHelper(attrColumns)
+	colTwo, valTwo := searchRecordHelper(attrColumns)
+	colThree, valThree := searchRecordHelper(attrColumns)
+
+	// sorted results are displayed in order instead of multithreaded
+	sortColumn := readColumnChoice("column to sort on", append(filterColumns(), SortRipening, SortRating), true)
+	if sortColumn != "" {
+		descending := strings.HasPrefix(strings.ToLower(readNewOrKeepDefaultString("descending order (y/n)", "n")), "y")
+		orderBy, _ := sortExpression(sortColumn, descending)
+		printRecordTable(queryRecords(database, []Filter{{colOne, valOne}, {colTwo, valTwo}, {colThree, valThree}}, orderBy))
+		return
+	}
 
 	fmt.Printf("\nDisplaying all records matching your filters, multithreaded...\n")
 
@@ -336,99 +468,146 @@ func searchRecords(database *sql.DB) {
 		go func(id int, r Record) {
 			// At the end of the goroutine, tell the waitgroup that the thread has completed
 			defer wg.Done()
-			fmt.Printf("Record ID: %d: %+v\n", id, r)
+			fmt.Printf("Record ID: %d: %s %s, %s\n", id, renderRecord(r), classificationSummary(r), describeRating(r))
 		}(i, rs[i])
 	}
 
 }
 
+// function to select the records matching three column filters
 func filterRecords(database *sql.DB, colOne string, valOne string, colTwo string, valTwo string, colThree string, valThree string) []Record {
-	var (
-		cheeseId int
-		cheeseName string
-		manufacturerName string
-		manufacturerProvCode string
-		manufacturingType string
-		website string
-		fatContentPercent float32
-		moisturePercent float32
-		particularities string
-		flavour string
-		characteristics string
-		ripening string
-		organic bool
-		categoryType string
-		milkType string
-		milkTreatmentType string
-		rindType string
-		lastUpdateDate string
-	)
+	return queryRecords(database, []Filter{{colOne, valOne}, {colTwo, valTwo}, {colThree, valThree}}, "")
+}
 
+// function to select the records matching all filters, in the given order (by record id when empty)
+func queryRecords(database *sql.DB, filters []Filter, orderBy string) []Record {
 	var rs []Record
 
+	if orderBy == "" {
+		orderBy = "id ASC"
+	}
+	where, args := buildWhereClause(filters)
+
 	// workaround for where clause
 	q := fmt.Sprintf(`
-		SELECT cheese_id, cheese_name, manufacturer_name, manufacturer_prov_code,
-		manufacturing_type, website, fat_content_percent, moisture_percent,
-		particularities, flavour, characteristics, ripening,
-		organic, category_type, milk_type, milk_treatment_type,
-		rind_type, last_update_date FROM cheeses WHERE %s = $1 AND %s = $2 AND %s = $3
-	`, colOne, colTwo, colThree)
+		SELECT id, %s FROM cheese_records%s ORDER BY %s
+	`, recordSelectColumns(), where, orderBy)
 
 	// prepare select
 	statement, _ := database.Prepare(q)
 	// query select
-	rows, _ := statement.Query(valOne, valTwo, valThree)
+	rows, _ := statement.Query(args...)
+
+	// custom attribute values of the records
+	attributes := loadRecordAttributes(database)
 
 	// loop through resultset
 	for rows.Next() {
-		err := rows.Scan(
-			&cheeseId, &cheeseName, &manufacturerName, &manufacturerProvCode,
-			&manufacturingType, &website, &fatContentPercent, &moisturePercent,
-			&particularities, &flavour, &characteristics, &ripening,
-			&organic, &categoryType, &milkType, &milkTreatmentType,
-			&rindType, &lastUpdateDate,
-		)
+		id, r, err := scanRecord(rows)
 		if err != nil {
 			log.Fatal(err)
 		}
+		r.Attributes = attributes[id]
 
 		// append to our resulting record slice
-		rs = append(rs, Record {
-			CheeseId: int(cheeseId),
-			CheeseName: cheeseName,
-			ManufacturerName: manufacturerName,
-			ManufacturerProvCode: manufacturerProvCode,
-			ManufacturingType: manufacturingType,
-			WebSite: website,
-			FatContentPercent: float32(fatContentPercent),
-			MoisturePercent: float32(moisturePercent),
-			Particularities: particularities,
-			Flavour: flavour,
-			Characteristics: characteristics,
-			Ripening: ripening,
-			Organic: organic,
-			CategoryType: categoryType,
-			MilkType: milkType,
-			MilkTreatmentType: milkTreatmentType,
-			RindType: rindType,
-			LastUpdateDate: lastUpdateDate,
-		})
+		rs = append(rs, r)
 
 	}	
 	return rs
 }
 
-// helper function for search
-func searchRecordHelper() (string, string) {
+// columns records can be filtered on, the searchable fields and the computed classes
+var searchColumns = append(fieldColumns(fieldsWhere(func(f Field) bool { return f.Searchable })), "firmness_class", "fat_class")
+
+// simple data structure containing a column filter
+type Filter struct {
+	Column string
+	Value string
+}
+
+// helper function to list the columns filters can be built on
+func filterColumns() []string {
+	return append(append([]string{}, searchColumns...), "organic")
+}
+
+// columns matching records on sets of values and ranges rather than on equal values
+var conditionColumns = []string {
+	MilkTypeContains, MilkTypeExactly, AgedAtLeast, AgedAtMost, UpdatedSince, UpdatedBefore, UpdatedBetween, StaleMonths,
+}
+
+// helper function to list the columns filters can be built on, with the ones matching on sets of values and ranges
+func matchColumns() []string {
+	return append(filterColumns(), conditionColumns...)
+}
+
+// helper function to build the condition of a range filter, checking its value
+func rangeCondition(f Filter) (string, []interface{}, error) {
+	if isRipeningColumn(f.Column) {
+		return ripeningCondition(f)
+	}
+	return dateCondition(f)
+}
+
+// helper function to build a WHERE clause matching all filters and extra conditions,
+// columns must come from matchColumns
+func buildWhereClause(filters []Filter, extra ...string) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	if len(filters) == 0 && len(extra) == 0 {
+		return "", nil
+	}
+
+	conditions = append(conditions, extra...)
+	for _, f := range filters {
+		if isVocabularyColumn(f.Column) {
+			// any spelling of a term (French label, code, alias) matches its English label
+			conditions = append(conditions, f.Column + " = " + vocabularyTermExpression(f.Column))
+			args = append(args, normalizeName(f.Value), f.Value)
+			continue
+		}
+		if isMilkTypeSetColumn(f.Column) {
+			c, a := milkTypeSetConditions(f)
+			conditions = append(conditions, c...)
+			args = append(args, a...)
+			continue
+		}
+		if isAttributeColumn(f.Column) {
+			c, a := attributeCondition(f)
+			conditions = append(conditions, c)
+			args = append(args, a...)
+			continue
+		}
+		if isRipeningColumn(f.Column) || isDateColumn(f.Column) {
+			c, a, err := rangeCondition(f)
+			if err != nil {
+				// invalid periods and dates match nothing
+				c, a = "0", nil
+			}
+			conditions = append(conditions, c)
+			args = append(args, a...)
+			continue
+		}
+		conditions = append(conditions, columnExpression(f.Column) + " = ?")
+		if f.Column == "organic" {
+			organic, err := strconv.ParseBool(f.Value)
+			if err != nil { organic = false }
+			args = append(args, organic)
+		} else {
+			args = append(args, f.Value)
+		}
+	}
+
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// helper function for search, extra columns are offered after the search columns
+func searchRecordHelper(extra []string) (string, string) {
 
 	c := ""
 	s := ""
-	columns := 	[]string { 
-		"cheese_name", "manufacturer_name",	"manufacturer_prov_code", "manufacturing_type",	"website",
-		"particularities", "flavour", "characteristics", "ripening", "category_type", "milk_type",
-		"milk_treatment_type", "rind_type", "last_update_date",
-	}
+	// milk types can also be matched as sets, e.g. milk_type_contains goat, ripening and dates as ranges, e.g. aged_at_least 6 months
+	columns := append(append(append([]string{}, searchColumns...), conditionColumns...), extra...)
 
 	for c == "" {
 		fmt.Printf("\n Please pick one of the following columns to filter records on:")
@@ -481,7 +660,9 @@ func displayRecord(database *sql.DB) {
 	r := getCheeseByRecordId(id, database)
 
 	// display record
-	fmt.Printf("\n Displaying Record #%d from database: \n%+v\n", id, r)
+	fmt.Printf("\n Displaying Record #%d from database: \n%s\n", id, renderRecord(r))
+	fmt.Printf(" %s\n", classificationSummary(r))
+	fmt.Printf(" %s\n", describeRating(r))
 }
 
 // helper function to delete an element from a Record slice and keep order
@@ -508,13 +689,13 @@ func deleteRecord(records []Record) []Record {
 	}
 
 	// display the record we are deleting
-	fmt.Printf("\n Deleting the following record: \n%+v\n", records[id])
+	fmt.Printf("\n Deleting the following record: \n%s\n", renderRecord(records[id]))
 
 	// return a slice with the element removed
 	return deleteRecordFromSlice(records, id)
 }
 
-// helper function to read a string from stdin
+// helper function to read a string from stdin, blank when nothing is entered
 func readString(toRead string) string {
 
 	fmt.Printf("Please enter the %s: ", toRead)
@@ -524,104 +705,116 @@ func readString(toRead string) string {
     scanner.Scan()
     s := scanner.Text()
 
-	if s == "" {
-		s = "N/A"
+	return strings.TrimSpace(s)
+}
+
+// helper function to read a string from stdin, asking again until it is not empty
+func readRequiredString(toRead string) string {
+
+	s := ""
+
+	for strings.TrimSpace(s) == "" {
+		fmt.Printf("Please enter the %s: ", toRead)
+
+		// read from scanner, there is nothing left to ask for once stdin is closed
+		scanner := bufio.NewScanner(os.Stdin)
+		if !scanner.Scan() {
+			exitAtEndOfInput()
+		}
+		s = scanner.Text()
 	}
 
-	return s
+	return strings.TrimSpace(s)
 }
 
-func createRecord(records []Record) []Record {
+// helper function to end the app when stdin is closed, e.g. piped input ran out, rather than prompting forever
+func exitAtEndOfInput() {
+	fmt.Println("\nNo more input, exiting.")
+	os.Exit(1)
+}
 
-	var recordSlice []string
+// helper function to check whether reading a number failed because stdin is closed
+func isEndOfInput(err error) bool {
+	return err == io.EOF || err == io.ErrUnexpectedEOF
+}
+
+func createRecord(records []Record, vocab Vocabulary, attrs []CustomAttribute) []Record {
+
+	var r Record
 
 	fmt.Printf("\n Creating record...\n\n")
 
-	// read values for our record
-	recordSlice = append(recordSlice, readString("Cheese ID (int)")) //0
-	recordSlice = append(recordSlice, readString("Cheese Name")) //1
-	recordSlice = append(recordSlice, readString("Manufacturer Name")) //2
-	recordSlice = append(recordSlice, readString("Manufacturer Prov Code")) //3
-	recordSlice = append(recordSlice, readString("Manufacturing Type")) //4
-	recordSlice = append(recordSlice, readString("Website")) //5
-	recordSlice = append(recordSlice, readString("Fat Content Percent (float32)")) //6
-	recordSlice = append(recordSlice, readString("Moisture Percent")) //7
-	recordSlice = append(recordSlice, readString("Particularities")) //8
-	recordSlice = append(recordSlice, readString("Flavour")) //9
-	recordSlice = append(recordSlice, readString("Characteristics")) //10
-	recordSlice = append(recordSlice, readString("Ripening")) //11
-	recordSlice = append(recordSlice, readString("Organic (bool)")) //12
-	recordSlice = append(recordSlice, readString("Category Type")) //13
-	recordSlice = append(recordSlice, readString("Milk Type")) //14
-	recordSlice = append(recordSlice, readString("Milk Treatment Type")) //15
-	recordSlice = append(recordSlice, readString("Rind Type")) //16
-	recordSlice = append(recordSlice, readString("Last Update Date")) //17
-
-	// parse some values from strings
-	cheeseId, err := strconv.ParseInt(recordSlice[0], 10, 64)
-	if err != nil { cheeseId = 0 }
-	fatContentPercent, err := strconv.ParseFloat(recordSlice[6], 32)
-	if err != nil { fatContentPercent = 0.0 }
-	moisturePercent, err := strconv.ParseFloat(recordSlice[7], 32)
-	if err != nil { moisturePercent = 0.0 }
-	organic, err := strconv.ParseBool(recordSlice[12])
-	if err != nil { organic = false }
-
-	// init record
-	r := Record {
-		CheeseId: int(cheeseId),
-		CheeseName: recordSlice[1],
-		ManufacturerName: recordSlice[2],
-		ManufacturerProvCode: recordSlice[3],
-		ManufacturingType: recordSlice[4],
-		WebSite: recordSlice[5],
-		FatContentPercent: float32(fatContentPercent),
-		MoisturePercent: float32(moisturePercent),
-		Particularities: recordSlice[8],
-		Flavour: recordSlice[9],
-		Characteristics: recordSlice[10],
-		Ripening: recordSlice[11],
-		Organic: organic,
-		CategoryType: recordSlice[13],
-		MilkType: recordSlice[14],
-		MilkTreatmentType: recordSlice[15],
-		RindType: recordSlice[16],
-		LastUpdateDate: recordSlice[17],
-	}
-
-	fmt.Printf("\n Creating the following record: \n%+v\n", r)
+	// read values for our record, categorical values are picked from their vocabulary
+	for _, f := range recordFields {
+		if f.Prompt == "" {
+			continue
+		}
+		if f.Vocabulary {
+			parseField(&r, f, readVocabularyValue(vocab, f.Column, f.Prompt, MissingValue))
+		} else {
+			parseField(&r, f, readString(f.Prompt))
+		}
+	}
+
+	// then the custom attributes
+	r = readAttributeValues(attrs, r, false)
+
+	// new records are stamped with today's date
+	r.LastUpdateDate = today()
+
+	fmt.Printf("\n Creating the following record: \n%s\n", renderRecord(r))
 
 	// return our records slice with the new record appended
 	return append(records, r)
 }
 
-// helper function to convert a Record object to a slice
+// column headers matching recordToSlice
+var recordHeaders = exportHeaders()
+
+// helper function to convert a Record object to a slice, missing values are empty
 func recordToSlice(record Record) []string {
 	var recordSlice []string
 
-	recordSlice = []string{
-		fmt.Sprintf("%d",record.CheeseId), record.CheeseName, record.ManufacturerName, record.ManufacturerProvCode,
-		record.ManufacturingType, record.WebSite, fmt.Sprintf("%.2f", record.FatContentPercent), 
-		fmt.Sprintf("%.2f", record.MoisturePercent), record.Particularities, record.Flavour, 
-		record.Characteristics, record.Ripening, fmt.Sprintf("%t", record.Organic),
-		record.CategoryType, record.MilkType, record.MilkTreatmentType, record.RindType, record.LastUpdateDate,
+	for _, f := range recordFields {
+		if f.Exportable {
+			recordSlice = append(recordSlice, exportField(record, f))
+		}
 	}
 
 	return recordSlice
 }
 
+// helper function to get the headers of exported records, the fields then the computed columns and the custom attributes
+func exportRowHeaders(attrs []CustomAttribute) []string {
+	return append(append(append([]string{}, recordHeaders...), classificationHeaders...), attributeHeaders(attrs)...)
+}
+
+// helper function to convert a record to an exported row matching exportRowHeaders
+func exportRow(r Record, attrs []CustomAttribute) []string {
+	return append(append(recordToSlice(r), classificationToSlice(r)...), attributesToSlice(r, attrs)...)
+}
+
+// helper function to create an output file, or use stdout when the path is empty
+func createOutput(filePath string) (io.Writer, func(), error) {
+	if filePath == "" {
+		return os.Stdout, func() {}, nil
+	}
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, func() { file.Close() }, nil
+}
+
 // function to write in-memory records to file
 func persistToFile(database *sql.DB, filePath string) {
 
 	fmt.Printf("\n Writing all database records to %s.\n", filePath)
 
 	rs := getAllCheeses(database)
-
-	headers := 	[]string { 
-		"CheeseId", "CheeseName", "ManufacturerName", "ManufacturerProvCode", "ManufacturingType",
-		"WebSite", "FatContentPercent", "MoisturePercent", "Particularities", "Flavour", "Characteristics",
-		"Ripening", "Organic", "CategoryType", "MilkType", "MilkTreatmentType", "RindType", "LastUpdateDate",
-	}
+	// custom attributes are exported after the computed columns
+	attrs := loadCustomAttributes(database)
 
 	// create file
 	file, err := os.Create(filePath)
@@ -633,12 +826,12 @@ func persistToFile(database *sql.DB, filePath string) {
 	defer writer.Flush()
 
 	// write headers
-	err = writer.Write(headers)
+	err = writer.Write(exportRowHeaders(attrs))
 	check(err)
 
 	// loop through records and write each one to the CSV
 	for i := 0; i < len(rs); i++ {
-		err = writer.Write(recordToSlice(rs[i]))
+		err = writer.Write(exportRow(rs[i], attrs))
 		check(err)
 	}
 
@@ -664,8 +857,7 @@ func readNewOrKeepDefaultString(toRead string, def string) string {
 }
 
 // function to edit record
-func editRecord(records []Record) []Record {
-	var recordSlice []string
+func editRecord(records []Record, vocab Vocabulary, attrs []CustomAttribute) []Record {
 	id := -1
 
 	// loop until ID is valid
@@ -685,223 +877,79 @@ func editRecord(records []Record) []Record {
 
 	r := records[id]
 	// edit record
-	fmt.Printf("\n Editing Record #%d: \n%+v\n", id, r)
-
-	fmt.Printf("\n Press Enter to keep the same value, otherwise input your value...\n")
-
-	// read values for our record
-	recordSlice = append(recordSlice, readNewOrKeepDefaultString("Cheese ID (int)", fmt.Sprintf("%d",r.CheeseId))) //0
-	recordSlice = append(recordSlice, readNewOrKeepDefaultString("Cheese Name", r.CheeseName)) //1
-	recordSlice = append(recordSlice, readNewOrKeepDefaultString("Manufacturer Name", r.ManufacturerName)) //2
-	recordSlice = append(recordSlice, readNewOrKeepDefaultString("Manufacturer Prov Code", r.ManufacturerProvCode)) //3
-	recordSlice = append(recordSlice, readNewOrKeepDefaultString("Manufacturing Type", r.ManufacturingType)) //4
-	recordSlice = append(recordSlice, readNewOrKeepDefaultString("Website", r.WebSite)) //5
-	recordSlice = append(recordSlice, readNewOrKeepDefaultString("Fat Content Percent (float32)", fmt.Sprintf("%.2f",r.FatContentPercent))) //6
-	recordSlice = append(recordSlice, readNewOrKeepDefaultString("Moisture Percent", fmt.Sprintf("%.2f",r.MoisturePercent))) //7
-	recordSlice = append(recordSlice, readNewOrKeepDefaultString("Particularities", r.Particularities)) //8
-	recordSlice = append(recordSlice, readNewOrKeepDefaultString("Flavour", r.Flavour)) //9
-	recordSlice = append(recordSlice, readNewOrKeepDefaultString("Characteristics", r.Characteristics)) //10
-	recordSlice = append(recordSlice, readNewOrKeepDefaultString("Ripening", r.Ripening)) //11
-	recordSlice = append(recordSlice, readNewOrKeepDefaultString("Organic (bool)", fmt.Sprintf("%t",r.Organic))) //12
-	recordSlice = append(recordSlice, readNewOrKeepDefaultString("Category Type", r.CategoryType)) //13
-	recordSlice = append(recordSlice, readNewOrKeepDefaultString("Milk Type", r.MilkType)) //14
-	recordSlice = append(recordSlice, readNewOrKeepDefaultString("Milk Treatment Type", r.MilkTreatmentType)) //15
-	recordSlice = append(recordSlice, readNewOrKeepDefaultString("Rind Type", r.RindType)) //16
-	recordSlice = append(recordSlice, readNewOrKeepDefaultString("Last Update Date", r.LastUpdateDate)) //17
-
-	// parse some values from strings
-	cheeseId, err := strconv.ParseInt(recordSlice[0], 10, 64)
-	if err != nil { cheeseId = 0 }
-	fatContentPercent, err := strconv.ParseFloat(recordSlice[6], 32)
-	if err != nil { fatContentPercent = 0.0 }
-	moisturePercent, err := strconv.ParseFloat(recordSlice[7], 32)
-	if err != nil { moisturePercent = 0.0 }
-	organic, err := strconv.ParseBool(recordSlice[12])
-	if err != nil { organic = false }
+	fmt.Printf("\n Editing Record #%d: \n%s\n", id, renderRecord(r))
 
 	// replace record
-	records[id] = Record {
-		CheeseId: int(cheeseId),
-		CheeseName: recordSlice[1],
-		ManufacturerName: recordSlice[2],
-		ManufacturerProvCode: recordSlice[3],
-		ManufacturingType: recordSlice[4],
-		WebSite: recordSlice[5],
-		FatContentPercent: float32(fatContentPercent),
-		MoisturePercent: float32(moisturePercent),
-		Particularities: recordSlice[8],
-		Flavour: recordSlice[9],
-		Characteristics: recordSlice[10],
-		Ripening: recordSlice[11],
-		Organic: organic,
-		CategoryType: recordSlice[13],
-		MilkType: recordSlice[14],
-		MilkTreatmentType: recordSlice[15],
-		RindType: recordSlice[16],
-		LastUpdateDate: recordSlice[17],
-	}
-
-	fmt.Printf("\n Changed the record to record: \n%+v\n", records[id])
+	records[id] = editRecordFields(r, vocab, attrs)
+
+	fmt.Printf("\n Changed the record to record: \n%s\n", renderRecord(records[id]))
 
 	// return our amended records slice
 	return records
 }
 
+// function to prompt for new values of each field of a record, keeping the current ones by default
+func editRecordFields(r Record, vocab Vocabulary, attrs []CustomAttribute) Record {
+
+	fmt.Printf("\n Press Enter to keep the same value, otherwise input your value...\n")
+
+	// read values for our record, categorical values are picked from their vocabulary
+	for _, f := range recordFields {
+		if f.Prompt == "" {
+			continue
+		}
+		if f.Vocabulary {
+			parseField(&r, f, readVocabularyValue(vocab, f.Column, f.Prompt, displayField(r, f)))
+		} else {
+			parseField(&r, f, readNewOrKeepDefaultString(f.Prompt, displayField(r, f)))
+		}
+	}
+
+	// then the custom attributes
+	r = readAttributeValues(attrs, r, true)
+
+	// edited records are stamped with today's date
+	r.LastUpdateDate = today()
+
+	// return the edited record
+	return r
+}
+
 // function to select cheese by record id from database
 func getCheeseByRecordId(id int, database *sql.DB) Record {
 
-	var (
-		cheeseId int
-		cheeseName string
-		manufacturerName string
-		manufacturerProvCode string
-		manufacturingType string
-		website string
-		fatContentPercent float32
-		moisturePercent float32
-		particularities string
-		flavour string
-		characteristics string
-		ripening string
-		organic bool
-		categoryType string
-		milkType string
-		milkTreatmentType string
-		rindType string
-		lastUpdateDate string
-	)
+	var r Record
 
 	// prepare select
-	statement, _ := database.Prepare(`
-		SELECT cheese_id, cheese_name, manufacturer_name, manufacturer_prov_code,
-		manufacturing_type, website, fat_content_percent, moisture_percent,
-		particularities, flavour, characteristics, ripening,
-		organic, category_type, milk_type, milk_treatment_type,
-		rind_type, last_update_date FROM cheeses WHERE id = $1
-	`)
+	statement, _ := database.Prepare(fmt.Sprintf(`
+		SELECT %s FROM cheese_records WHERE id = $1
+	`, recordSelectColumns()))
 	// query select
 	rows, _ := statement.Query(id)
 
 	// loop through resultset
 	for rows.Next() {
-		err := rows.Scan(
-			&cheeseId, &cheeseName, &manufacturerName, &manufacturerProvCode,
-			&manufacturingType, &website, &fatContentPercent, &moisturePercent,
-			&particularities, &flavour, &characteristics, &ripening,
-			&organic, &categoryType, &milkType, &milkTreatmentType,
-			&rindType, &lastUpdateDate,
-		)
+		err := rows.Scan(recordScanDestinations(&r)...)
 		if err != nil {
 			log.Fatal(err)
 		}
 	}	
-	r := Record {
-		CheeseId: int(cheeseId),
-		CheeseName: cheeseName,
-		ManufacturerName: manufacturerName,
-		ManufacturerProvCode: manufacturerProvCode,
-		ManufacturingType: manufacturingType,
-		WebSite: website,
-		FatContentPercent: float32(fatContentPercent),
-		MoisturePercent: float32(moisturePercent),
-		Particularities: particularities,
-		Flavour: flavour,
-		Characteristics: characteristics,
-		Ripening: ripening,
-		Organic: organic,
-		CategoryType: categoryType,
-		MilkType: milkType,
-		MilkTreatmentType: milkTreatmentType,
-		RindType: rindType,
-		LastUpdateDate: lastUpdateDate,
-	}
+	r.Attributes = loadRecordAttributes(database)[int64(id)]
 	return r
 }
 
 // function to select all cheeses from database
 func getAllCheeses(database *sql.DB) []Record {
-
-	var (
-		cheeseId int
-		cheeseName string
-		manufacturerName string
-		manufacturerProvCode string
-		manufacturingType string
-		website string
-		fatContentPercent float32
-		moisturePercent float32
-		particularities string
-		flavour string
-		characteristics string
-		ripening string
-		organic bool
-		categoryType string
-		milkType string
-		milkTreatmentType string
-		rindType string
-		lastUpdateDate string
-	)
-
-	var rs []Record
-
-	// prepare select
-	statement, _ := database.Prepare(`
-		SELECT cheese_id, cheese_name, manufacturer_name, manufacturer_prov_code,
-		manufacturing_type, website, fat_content_percent, moisture_percent,
-		particularities, flavour, characteristics, ripening,
-		organic, category_type, milk_type, milk_treatment_type,
-		rind_type, last_update_date FROM cheeses ORDER BY id ASC
-	`)
-	// query select
-	rows, _ := statement.Query()
-
-	//loop through resultset
-	for rows.Next() {
-		err := rows.Scan(
-			&cheeseId, &cheeseName, &manufacturerName, &manufacturerProvCode,
-			&manufacturingType, &website, &fatContentPercent, &moisturePercent,
-			&particularities, &flavour, &characteristics, &ripening,
-			&organic, &categoryType, &milkType, &milkTreatmentType,
-			&rindType, &lastUpdateDate,
-		)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		// append to our resulting record slice
-		rs = append(rs, Record {
-			CheeseId: int(cheeseId),
-			CheeseName: cheeseName,
-			ManufacturerName: manufacturerName,
-			ManufacturerProvCode: manufacturerProvCode,
-			ManufacturingType: manufacturingType,
-			WebSite: website,
-			FatContentPercent: float32(fatContentPercent),
-			MoisturePercent: float32(moisturePercent),
-			Particularities: particularities,
-			Flavour: flavour,
-			Characteristics: characteristics,
-			Ripening: ripening,
-			Organic: organic,
-			CategoryType: categoryType,
-			MilkType: milkType,
-			MilkTreatmentType: milkTreatmentType,
-			RindType: rindType,
-			LastUpdateDate: lastUpdateDate,
-		})
-	}	
-
-	return rs
+	return queryRecords(database, nil, "")
 }
 
-
 // function to select the count of cheeses from database
 func getCheeseCount(database *sql.DB) int {
 	var count int
 
 	// prepare select
 	statement, _ := database.Prepare(`
-		SELECT count(*) FROM cheeses
+		SELECT count(*) FROM cheese_records
 	`)
 	// query select
 	rows, _ := statement.Query()
@@ -917,4 +965,18 @@ func getCheeseCount(database *sql.DB) int {
 	}
 
 	return count
-}
\ No newline at end of file
+}
+
+// helper function to check that a CheeseId is in the directory
+func checkCheeseExists(q querier, cheeseId int) error {
+	var n int
+
+	err := q.QueryRow(`SELECT COUNT(*) FROM cheeses WHERE cheese_id = ?`, cheeseId).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("no cheese with CheeseId %d", cheeseId)
+	}
+	return nil
+}
@@ -2,8 +2,17 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"database/sql"
 	"testing"
 	"reflect"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // test to verify that our "loadData" function loads the first record from the dataset properly
@@ -12,23 +21,23 @@ func TestLoadData(t *testing.T) {
 	// create a record with the proper data
 	firstRecord := Record {
 		CheeseId: 228,
-		CheeseName: "Sieur de Duplessis (Le)",
-		ManufacturerName: "Fromages la faim de loup",
-		ManufacturerProvCode: "NB",
-		ManufacturingType: "Farmstead",
-		WebSite: "N/A",
-		FatContentPercent: 24.2,
-		MoisturePercent: 47,
-		Particularities: "N/A",
-		Flavour: "Sharp, lactic",
-		Characteristics: "Uncooked",
-		Ripening: "9 Months",
+		CheeseName: sql.NullString{String: "Sieur de Duplessis (Le)", Valid: true},
+		ManufacturerName: sql.NullString{String: "Fromages la faim de loup", Valid: true},
+		ManufacturerProvCode: sql.NullString{String: "NB", Valid: true},
+		ManufacturingType: sql.NullString{String: "Farmstead", Valid: true},
+		WebSite: sql.NullString{},
+		FatContentPercent: sql.NullFloat64{Float64: 24.2, Valid: true},
+		MoisturePercent: sql.NullFloat64{Float64: 47, Valid: true},
+		Particularities: sql.NullString{},
+		Flavour: sql.NullString{String: "Sharp, lactic", Valid: true},
+		Characteristics: sql.NullString{String: "Uncooked", Valid: true},
+		Ripening: sql.NullString{String: "9 Months", Valid: true},
 		Organic: false,
-		CategoryType: "Firm Cheese",
-		MilkType: "Ewe",
-		MilkTreatmentType: "Raw Milk",
-		RindType: "Washed Rind",
-		LastUpdateDate: "2016-02-03",
+		CategoryType: sql.NullString{String: "Firm Cheese", Valid: true},
+		MilkType: sql.NullString{String: "Ewe", Valid: true},
+		MilkTreatmentType: sql.NullString{String: "Raw Milk", Valid: true},
+		RindType: sql.NullString{String: "Washed Rind", Valid: true},
+		LastUpdateDate: Date{time.Date(2016, 2, 3, 0, 0, 0, 0, time.UTC)},
 	}
 
 	// load data and get the first record
@@ -47,23 +56,23 @@ func TestGetCheeseByRecordId(t *testing.T) {
 	// create a record with the proper data
 	firstRecord := Record {
 		CheeseId: 228,
-		CheeseName: "Sieur de Duplessis (Le)",
-		ManufacturerName: "Fromages la faim de loup",
-		ManufacturerProvCode: "NB",
-		ManufacturingType: "Farmstead",
-		WebSite: "N/A",
-		FatContentPercent: 24.2,
-		MoisturePercent: 47,
-		Particularities: "N/A",
-		Flavour: "Sharp, lactic",
-		Characteristics: "Uncooked",
-		Ripening: "9 Months",
+		CheeseName: sql.NullString{String: "Sieur de Duplessis (Le)", Valid: true},
+		ManufacturerName: sql.NullString{String: "Fromages la faim de loup", Valid: true},
+		ManufacturerProvCode: sql.NullString{String: "NB", Valid: true},
+		ManufacturingType: sql.NullString{String: "Farmstead", Valid: true},
+		WebSite: sql.NullString{},
+		FatContentPercent: sql.NullFloat64{Float64: 24.2, Valid: true},
+		MoisturePercent: sql.NullFloat64{Float64: 47, Valid: true},
+		Particularities: sql.NullString{},
+		Flavour: sql.NullString{String: "Sharp, lactic", Valid: true},
+		Characteristics: sql.NullString{String: "Uncooked", Valid: true},
+		Ripening: sql.NullString{String: "9 Months", Valid: true},
 		Organic: false,
-		CategoryType: "Firm Cheese",
-		MilkType: "Ewe",
-		MilkTreatmentType: "Raw Milk",
-		RindType: "Washed Rind",
-		LastUpdateDate: "2016-02-03",
+		CategoryType: sql.NullString{String: "Firm Cheese", Valid: true},
+		MilkType: sql.NullString{String: "Ewe", Valid: true},
+		MilkTreatmentType: sql.NullString{String: "Raw Milk", Valid: true},
+		RindType: sql.NullString{String: "Washed Rind", Valid: true},
+		LastUpdateDate: Date{time.Date(2016, 2, 3, 0, 0, 0, 0, time.UTC)},
 	}
 
 	// load data and get the first record
@@ -88,23 +97,23 @@ func TestFilterRecords(t *testing.T) {
 		// create a record with the proper data
 		firstRecord := Record {
 			CheeseId: 228,
-			CheeseName: "Sieur de Duplessis (Le)",
-			ManufacturerName: "Fromages la faim de loup",
-			ManufacturerProvCode: "NB",
-			ManufacturingType: "Farmstead",
-			WebSite: "N/A",
-			FatContentPercent: 24.2,
-			MoisturePercent: 47,
-			Particularities: "N/A",
-			Flavour: "Sharp, lactic",
-			Characteristics: "Uncooked",
-			Ripening: "9 Months",
+			CheeseName: sql.NullString{String: "Sieur de Duplessis (Le)", Valid: true},
+			ManufacturerName: sql.NullString{String: "Fromages la faim de loup", Valid: true},
+			ManufacturerProvCode: sql.NullString{String: "NB", Valid: true},
+			ManufacturingType: sql.NullString{String: "Farmstead", Valid: true},
+			WebSite: sql.NullString{},
+			FatContentPercent: sql.NullFloat64{Float64: 24.2, Valid: true},
+			MoisturePercent: sql.NullFloat64{Float64: 47, Valid: true},
+			Particularities: sql.NullString{},
+			Flavour: sql.NullString{String: "Sharp, lactic", Valid: true},
+			Characteristics: sql.NullString{String: "Uncooked", Valid: true},
+			Ripening: sql.NullString{String: "9 Months", Valid: true},
 			Organic: false,
-			CategoryType: "Firm Cheese",
-			MilkType: "Ewe",
-			MilkTreatmentType: "Raw Milk",
-			RindType: "Washed Rind",
-			LastUpdateDate: "2016-02-03",
+			CategoryType: sql.NullString{String: "Firm Cheese", Valid: true},
+			MilkType: sql.NullString{String: "Ewe", Valid: true},
+			MilkTreatmentType: sql.NullString{String: "Raw Milk", Valid: true},
+			RindType: sql.NullString{String: "Washed Rind", Valid: true},
+			LastUpdateDate: Date{time.Date(2016, 2, 3, 0, 0, 0, 0, time.UTC)},
 		}
 	
 		// load data and get the first record
@@ -119,4 +128,1047 @@ func TestFilterRecords(t *testing.T) {
 		if !reflect.DeepEqual(firstRecord, rs[0]) {
 			t.Errorf("Filtered Record was incorrect, \n got: \n%+v\n, want: \n%+v\n", firstRecord, rs[0])
 		}
-}
\ No newline at end of file
+}
+// test for password and API key authentication and role checks
+func TestUserAuthentication(t *testing.T) {
+	// init db
+	database := initCheesesDatabase("./cheesedir-test.db")
+	// start from a clean account
+	deleteUser(database, "test-viewer")
+
+	err := createUser(database, "test-viewer", "secret", RoleViewer)
+	if err != nil {
+		t.Fatalf("Could not create user: %v", err)
+	}
+
+	u, ok := authenticatePassword(database, "test-viewer", "secret")
+	if !ok || u.Role != RoleViewer {
+		t.Errorf("Authentication with the right password failed, got: %+v", u)
+	}
+	if _, ok = authenticatePassword(database, "test-viewer", "wrong"); ok {
+		t.Errorf("Authentication with the wrong password succeeded")
+	}
+
+	key, err := generateApiKey(database, "test-viewer")
+	if err != nil {
+		t.Fatalf("Could not generate API key: %v", err)
+	}
+	if u, ok = authenticateApiKey(database, key); !ok || u.Username != "test-viewer" {
+		t.Errorf("Authentication with the API key failed, got: %+v", u)
+	}
+
+	// viewers can search but not delete, editors can delete
+	if !canExecute(u, OptionSearch) || canExecute(u, OptionDelete) {
+		t.Errorf("Viewer permissions were incorrect")
+	}
+	if !canExecute(User{Role: RoleEditor}, OptionDelete) || canExecute(User{Role: RoleEditor}, OptionManageUsers) {
+		t.Errorf("Editor permissions were incorrect")
+	}
+}
+
+// test for the statistics report on the first records of the dataset
+func TestGetStatistics(t *testing.T) {
+	// load data and insert the first records into DB
+	records := loadData("data/canadianCheeseDirectory.csv", 5)
+	database := initCheesesDatabase("./cheesedir-test.db")
+	syncDb(records, database)
+
+	stats := getStatistics(database, []Filter{{Column: "manufacturer_prov_code", Value: "NB"}})
+	if stats.Total != 4 {
+		t.Errorf("Filtered total was incorrect, got: %d, want: %d", stats.Total, 4)
+	}
+
+	// counts by milk type are the third group
+	want := []CountRow{{"Cow", 3}, {"Ewe", 1}}
+	if !reflect.DeepEqual(want, stats.Counts[2].Rows) {
+		t.Errorf("Milk type counts were incorrect, got: %+v, want: %+v", stats.Counts[2].Rows, want)
+	}
+
+	fat := stats.Numeric[0]
+	if fat.Count != 4 || math.Abs(fat.Median - 24.4) > 0.001 || math.Abs(fat.Max - 29) > 0.001 {
+		t.Errorf("Fat content summary was incorrect, got: %+v", fat)
+	}
+}
+
+// test for cross-tabulating province and milk type on the first records of the dataset
+func TestBuildPivotTable(t *testing.T) {
+	// load data and insert the first records into DB
+	records := loadData("data/canadianCheeseDirectory.csv", 5)
+	database := initCheesesDatabase("./cheesedir-test.db")
+	syncDb(records, database)
+
+	pt := buildPivotTable(database, "manufacturer_prov_code", "milk_type", "moisture_percent", nil)
+	rows := pivotTableToRows(pt)
+
+	want := [][]string {
+		{"manufacturer_prov_code \\ milk_type (avg moisture_percent)", "Cow", "Ewe", "Total"},
+		{"NB", "3 (48.10)", "1 (47.00)", "4 (47.83)"},
+		{"ON", "1 (54.00)", "", "1 (54.00)"},
+		{"Total", "4 (49.58)", "1 (47.00)", "5 (49.06)"},
+	}
+	if !reflect.DeepEqual(want, rows) {
+		t.Errorf("Pivot table was incorrect, \n got: \n%v\n, want: \n%v\n", rows, want)
+	}
+	// exported files start with their header
+	filePath := t.TempDir() + "/pivot.csv"
+	if err := outputPivotTable(pt, FormatCSV, filePath); err != nil {
+		t.Fatalf("Pivot table export failed, got: %v", err)
+	}
+	data, _ := os.ReadFile(filePath)
+	if !strings.HasPrefix(string(data), "manufacturer_prov_code") {
+		t.Errorf("Pivot table export did not start with its header, got: %q", data)
+	}
+}
+
+// test for the data quality checks
+func TestBuildQualityReport(t *testing.T) {
+	lines := [][]string {
+		{"CheeseId", "CheeseNameEn", "CheeseNameFr", "FatContentPercent", "MoisturePercent"},
+		{"1", "Brie", "Brie", "25", "50"},
+		{"2", "", "Tomme", "0", "50"},
+		{"2", "Cheddar ", "N/A", "60", "45"},
+	}
+
+	report := buildQualityReport("test", lines)
+
+	want := []QualityIssue {
+		{"2", IssueMissingTranslation, "CheeseNameEn", "only CheeseNameFr is filled"},
+		{"2", IssueImplausibleValue, "FatContentPercent", "0%"},
+		{"2", IssueDuplicateId, "CheeseId", "CheeseId appears more than once"},
+		{"2", IssueMissingTranslation, "CheeseNameFr", "only CheeseNameEn is filled"},
+		{"2", IssueWhitespace, "CheeseNameEn", `"Cheddar "`},
+		{"2", IssueImplausibleValue, "FatContentPercent+MoisturePercent", "60% + 45% is over 100%"},
+	}
+	if !reflect.DeepEqual(want, report.Issues) {
+		t.Errorf("Quality issues were incorrect, \n got: \n%+v\n, want: \n%+v\n", report.Issues, want)
+	}
+
+	// fat content is filled for 2 of 3 records, zero counting as missing
+	if report.Completeness[3].Filled != 2 {
+		t.Errorf("Fat content completeness was incorrect, got: %+v", report.Completeness[3])
+	}
+}
+
+// test for the MFFB and FDM classifications, in Go and in SQL searches
+func TestClassifications(t *testing.T) {
+	r := Record{FatContentPercent: sql.NullFloat64{Float64: 24.2, Valid: true}, MoisturePercent: sql.NullFloat64{Float64: 47, Valid: true}}
+	mffb, _ := r.MFFB()
+	fdm, _ := r.FDM()
+	if math.Abs(mffb - 62.005) > 0.01 || r.FirmnessClass() != "semi-soft" {
+		t.Errorf("MFFB classification was incorrect, got: %.3f (%s)", mffb, r.FirmnessClass())
+	}
+	if math.Abs(fdm - 45.660) > 0.01 || r.FatClass() != "full-fat" {
+		t.Errorf("FDM classification was incorrect, got: %.3f (%s)", fdm, r.FatClass())
+	}
+	if (Record{MoisturePercent: sql.NullFloat64{Float64: 47, Valid: true}}).FirmnessClass() != UnknownClass {
+		t.Errorf("Record without fat content should have an unknown firmness")
+	}
+
+	// load data and insert the first records into DB
+	records := loadData("data/canadianCheeseDirectory.csv", 5)
+	database := initCheesesDatabase("./cheesedir-test.db")
+	syncDb(records, database)
+
+	// Provolone Sette Fette (24% fat, 54% moisture) is the only soft cheese, with 52% fat in dry matter
+	rs := filterRecords(database, "firmness_class", "soft", "fat_class", "full-fat", "manufacturer_prov_code", "ON")
+	if len(rs) != 1 || rs[0].CheeseId != 301 {
+		t.Errorf("Filtering on computed columns was incorrect, got: %+v", rs)
+	}
+}
+
+// test for finding categories that contradict the computed firmness
+func TestCheckCategoryConsistency(t *testing.T) {
+	// load the first records
+	records := loadData("data/canadianCheeseDirectory.csv", 5)
+
+	// Sieur de Duplessis is declared firm but 47% moisture and 24.2% fat give 62% MFFB (semi-soft),
+	// Provolone Sette Fette is declared firm but 71% MFFB is soft, Geai Bleu is veined so not checked
+	mismatches := checkCategoryConsistency(records)
+	if len(mismatches) != 2 {
+		t.Fatalf("Number of mismatches was incorrect, got: %+v", mismatches)
+	}
+	if mismatches[0].Record.CheeseId != 228 || mismatches[0].Computed != "semi-soft" {
+		t.Errorf("First mismatch was incorrect, got: %+v", mismatches[0])
+	}
+	if mismatches[1].Index != 2 || mismatches[1].Declared != "firm" || mismatches[1].Computed != "soft" {
+		t.Errorf("Second mismatch was incorrect, got: %+v", mismatches[1])
+	}
+}
+
+// test for deduplicating, renaming and merging manufacturers
+func TestManufacturers(t *testing.T) {
+	// load data and insert the first records into DB
+	records := loadData("data/canadianCheeseDirectory.csv", 5)
+	database := initCheesesDatabase("./cheesedir-test.db")
+	syncDb(records, database)
+
+	// spelling differences resolve to the same manufacturer
+	id, _ := manufacturerIdForRecord(database, records[0])
+	sameId, _ := manufacturerIdForRecord(database, Record{ManufacturerName: nullString(" FROMAGES la Faim-de-Loup"), ManufacturerProvCode: nullString("NB")})
+	if id == 0 || id != sameId {
+		t.Errorf("Manufacturer ids were incorrect, got: %d and %d", id, sameId)
+	}
+	if n := len(getCheesesByManufacturer(database, id)); n != 4 {
+		t.Errorf("Number of cheeses by manufacturer was incorrect, got: %d, want: %d", n, 4)
+	}
+
+	// renaming shows on every cheese, and syncing records with the old name keeps the manufacturer
+	defer updateManufacturer(database, Manufacturer{Id: id, NameFr: "Fromages la faim de loup", ProvCode: "NB"})
+	err := updateManufacturer(database, Manufacturer{Id: id, NameEn: "Hungry Wolf Cheeses", NameFr: "Fromages la faim de loup", ProvCode: "NB"})
+	if err != nil {
+		t.Fatalf("Could not rename manufacturer: %v", err)
+	}
+	syncDb(records, database)
+	if r := getCheeseByRecordId(4, database); r.ManufacturerName.String != "Hungry Wolf Cheeses" {
+		t.Errorf("Renamed manufacturer name was incorrect, got: %s", r.ManufacturerName.String)
+	}
+
+	// merging moves the names of the merged manufacturer
+	name := "Merge Test Dairy " + newRandomToken(4)
+	dropId, _ := resolveManufacturer(database, Manufacturer{NameEn: name, ProvCode: "NB"})
+	if err = mergeManufacturers(database, id, dropId); err != nil {
+		t.Fatalf("Could not merge manufacturers: %v", err)
+	}
+	if mergedId, _ := findManufacturerId(database, []string{manufacturerKey(name, "NB")}); mergedId != id {
+		t.Errorf("Merged manufacturer id was incorrect, got: %d, want: %d", mergedId, id)
+	}
+}
+
+// test for mapping categorical values onto the controlled vocabularies
+func TestVocabularies(t *testing.T) {
+	// load data and insert the first records into DB
+	records := loadData("data/canadianCheeseDirectory.csv", 5)
+	database := initCheesesDatabase("./cheesedir-test.db")
+	seedVocabularies(database, "data/canadianCheeseDirectory.csv")
+	syncDb(records, database)
+	vocab := loadVocabulary(database)
+
+	// French labels and other spellings map onto the English label, unknown values are reported
+	rs, unmapped := canonicalizeRecords(vocab, []Record{
+		{MilkType: nullString("vache"), CategoryType: nullString("PÂTE FERME")},
+		{MilkType: nullString("Yak")},
+	})
+	if rs[0].MilkType.String != "Cow" || rs[0].CategoryType.String != "Firm Cheese" || rs[0].RindType.Valid {
+		t.Errorf("Canonical values were incorrect, got: %+v", rs[0])
+	}
+	want := []UnmappedValue{{Field: "milk_type", Value: "Yak", Count: 1}}
+	if !reflect.DeepEqual(unmapped, want) {
+		t.Errorf("Unmapped values were incorrect, got: %+v, want: %+v", unmapped, want)
+	}
+
+	// searching by a French label finds the records stored with the English one
+	if rs := queryRecords(database, []Filter{{"milk_type", "Vache"}}, ""); len(rs) != 4 {
+		t.Errorf("Number of records found by alias was incorrect, got: %d, want: %d", len(rs), 4)
+	}
+	if rs := queryRecords(database, []Filter{{"milk_type", "brebis"}}, ""); len(rs) != 1 || rs[0].CheeseId != 228 {
+		t.Errorf("Record found by alias was incorrect, got: %+v", rs)
+	}
+
+	// cheeses store the code of the term and show its current label, even a mixed milk cheese
+	records[1].MilkType = nullString("Ewe and Cow")
+	syncDb(records, database)
+	var stored string
+	check(database.QueryRow(`SELECT milk_type FROM cheeses WHERE cheese_id = 228`).Scan(&stored))
+	if stored != "ewe" {
+		t.Errorf("Stored milk type was incorrect, got: %s, want: %s", stored, "ewe")
+	}
+	_, err := database.Exec(`UPDATE vocabulary_terms SET label_en = 'Sheep' WHERE field = 'milk_type' AND code = 'ewe'`)
+	check(err)
+	if rs := queryRecords(database, []Filter{{"milk_type", "brebis"}}, ""); len(rs) != 1 || rs[0].MilkType.String != "Sheep" {
+		t.Errorf("Relabelled record was incorrect, got: %+v", rs)
+	}
+	if r := getAllCheeses(database)[1]; r.MilkType.String != "Sheep and Cow" {
+		t.Errorf("Relabelled milk types were incorrect, got: %s", r.MilkType.String)
+	}
+	_, err = database.Exec(`UPDATE vocabulary_terms SET label_en = 'Ewe' WHERE field = 'milk_type' AND code = 'ewe'`)
+	check(err)
+
+	// aliases must point to an existing term
+	if err := addVocabularyAlias(database, "milk_type", "Yak", "no_such_code"); err == nil {
+		t.Errorf("Alias to a missing term was accepted")
+	}
+}
+
+// test for parsing and matching sets of milk types
+func TestMilkTypes(t *testing.T) {
+	// load data and insert the first records into DB, one of them with mixed milk
+	records := loadData("data/canadianCheeseDirectory.csv", 5)
+	database := initCheesesDatabase("./cheesedir-test.db")
+	seedVocabularies(database, "data/canadianCheeseDirectory.csv")
+	records[1].MilkType = nullString("Vache et chèvre")
+	records, _ = canonicalizeRecords(loadVocabulary(database), records)
+	syncDb(records, database)
+
+	// combined English and French values parse into the same set, rendered the directory's way
+	types, unknown := parseMilkTypes(loadVocabulary(database), "brebis, Goat & vache")
+	if !reflect.DeepEqual(types, []string{"Ewe", "Goat", "Cow"}) || len(unknown) != 0 {
+		t.Errorf("Parsed milk types were incorrect, got: %v and %v", types, unknown)
+	}
+	if s := renderMilkTypes(types); s.String != "Ewe, Goat and Cow" {
+		t.Errorf("Rendered milk types were incorrect, got: %s", s.String)
+	}
+	if records[1].MilkType.String != "Cow and Goat" {
+		t.Errorf("Canonical milk type was incorrect, got: %s", records[1].MilkType.String)
+	}
+
+	// "contains" matches mixed milk cheeses, "exactly" only the same set
+	tests := []struct {
+		filter Filter
+		want int
+	}{
+		{Filter{MilkTypeContains, "goat"}, 1},
+		{Filter{MilkTypeContains, "Vache"}, 4},
+		{Filter{MilkTypeExactly, "cow"}, 3},
+		{Filter{MilkTypeExactly, "chèvre+cow"}, 1},
+		{Filter{MilkTypeExactly, "cow+ewe"}, 0},
+	}
+	for _, test := range tests {
+		if rs := queryRecords(database, []Filter{test.filter}, ""); len(rs) != test.want {
+			t.Errorf("Number of records matching %+v was incorrect, got: %d, want: %d", test.filter, len(rs), test.want)
+		}
+	}
+}
+
+// test for parsing, matching and sorting ripening periods
+func TestRipening(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"9 Months", "270 days"},
+		{"9 mois", "270 days"},
+		{"2 to 3 weeks", "14-21 days"},
+		{"2-4 mois", "60-120 days"},
+		{"30 à45 jours", "30-45 days"},
+		{"Less than 1 Month", "0-30 days"},
+		{"plus de 5 ans", "1825+ days"},
+		{"10 day minimum", "10+ days"},
+		{"Non-affiné", "0 days"},
+		{"Naturally brine-ripened", "unknown"},
+		{"N/A", "unknown"},
+	}
+	for _, test := range tests {
+		if got := describeRipeningDays(parseRipening(test.text)); got != test.want {
+			t.Errorf("Ripening period of %q was incorrect, got: %s, want: %s", test.text, got, test.want)
+		}
+	}
+
+	// load data and insert the first records into DB
+	records := loadData("data/canadianCheeseDirectory.csv", 5)
+	database := initCheesesDatabase("./cheesedir-test.db")
+	syncDb(records, database)
+
+	// "aged at least 3 months" sorted by ripening period, longest first
+	orderBy, _ := sortExpression(SortRipening, true)
+	rs := queryRecords(database, []Filter{{AgedAtLeast, "3 mois"}}, orderBy)
+	if len(rs) != 2 || rs[0].CheeseId != 228 || rs[1].CheeseId != 303 {
+		t.Errorf("Records aged at least 3 months were incorrect, got: %+v", rs)
+	}
+	if rs := queryRecords(database, []Filter{{AgedAtMost, "2 months"}}, ""); len(rs) != 1 || rs[0].CheeseId != 319 {
+		t.Errorf("Records aged at most 2 months were incorrect, got: %+v", rs)
+	}
+	if _, err := parseFilterArgs([]string{"aged_at_least=a while"}); err == nil {
+		t.Errorf("Invalid ripening period was accepted")
+	}
+}
+
+// test for last update dates and the filters on them
+func TestUpdateDates(t *testing.T) {
+	if d, err := parseDate("2016/02/03"); err != nil || d.String() != "2016-02-03" {
+		t.Errorf("Parsed date was incorrect, got: %v and %v", d, err)
+	}
+	if _, err := parseDate("February 3rd"); err == nil {
+		t.Errorf("Invalid date was accepted")
+	}
+
+	// load data and insert the first records into DB, stamping one of them today
+	records := loadData("data/canadianCheeseDirectory.csv", 5)
+	database := initCheesesDatabase("./cheesedir-test.db")
+	records[2].LastUpdateDate = today()
+	records[3].LastUpdateDate = Date{}
+	syncDb(records, database)
+
+	tests := []struct {
+		filter Filter
+		want int
+	}{
+		{Filter{UpdatedSince, "2016-02-04"}, 1},
+		{Filter{UpdatedBefore, "2016-02-04"}, 3},
+		{Filter{UpdatedBetween, "2016-01-01..2016-12-31"}, 3},
+		{Filter{StaleMonths, "12"}, 4},
+	}
+	for _, test := range tests {
+		if rs := queryRecords(database, []Filter{test.filter}, ""); len(rs) != test.want {
+			t.Errorf("Number of records matching %+v was incorrect, got: %d, want: %d", test.filter, len(rs), test.want)
+		}
+	}
+
+	// the most recently changed record comes first and records never dated last
+	rs := recentChanges(database, nil, 0)
+	if len(rs) != 5 || rs[0].CheeseId != 301 || rs[0].LastUpdateDate != today() || rs[4].CheeseId != 303 {
+		t.Errorf("Recent changes were incorrect, got: %+v", rs)
+	}
+	if _, err := parseFilterArgs([]string{"updated_between=2016-12-31..2016-01-01"}); err == nil {
+		t.Errorf("Invalid date range was accepted")
+	}
+}
+
+// test for missing values stored as NULL, exported empty and displayed the same way
+func TestMissingValues(t *testing.T) {
+	// load data and insert the first records into DB, one of them with a real 0% fat content and one without
+	records := loadData("data/canadianCheeseDirectory.csv", 5)
+	database := initCheesesDatabase("./cheesedir-test.db")
+	records[1].FatContentPercent = sql.NullFloat64{Float64: 0, Valid: true}
+	records[3].FatContentPercent = sql.NullFloat64{}
+	syncDb(records, database)
+
+	var websites, fats int
+	err := database.QueryRow(`SELECT COUNT(website), COUNT(fat_content_percent) FROM cheeses`).Scan(&websites, &fats)
+	if err != nil || websites != 1 || fats != 4 {
+		t.Errorf("Number of stored values was incorrect, got: %d websites and %d fat contents (%v)", websites, fats, err)
+	}
+
+	// 0% fat is real data, a blank fat content is missing
+	r := getCheeseByRecordId(2, database)
+	if !r.FatContentPercent.Valid || r.FatContentPercent.Float64 != 0 || r.WebSite.Valid {
+		t.Errorf("Missing values were incorrect, got: %+v", r)
+	}
+	if s := recordToSlice(r); s[5] != "" || s[6] != "0.00" {
+		t.Errorf("Exported values were incorrect, got: %q and %q", s[5], s[6])
+	}
+	if s := renderRecord(r); !strings.Contains(s, " WebSite:N/A ") || !strings.Contains(s, " FatContentPercent:0.00 ") {
+		t.Errorf("Rendered record was incorrect, got: %s", s)
+	}
+}
+
+// test for the field registry, data file columns are found by header and records round-trip through the database
+func TestFieldRegistry(t *testing.T) {
+	if len(recordHeaders) != len(fieldsWhere(func(f Field) bool { return f.Exportable })) || len(recordToSlice(Record{})) != len(recordHeaders) {
+		t.Errorf("Exported columns were incorrect, got: %v", recordHeaders)
+	}
+
+	// columns in another order, with a byte order mark and a missing English name
+	indexes := csvHeaderIndexes([]string{"\ufeffCheeseId", "FatContentPercent", "CheeseNameFr", "CheeseNameEn", "Organic"})
+	r := lineToRecord([]string{"42", "24.5", "Oka", "", "1"}, indexes)
+	if r.CheeseId != 42 || r.CheeseName.String != "Oka" || r.FatContentPercent.Float64 != 24.5 || !r.Organic || r.RindType.Valid {
+		t.Errorf("Record read by header was incorrect, got: %+v", r)
+	}
+
+	// insert records and read them back
+	records := loadData("data/canadianCheeseDirectory.csv", 5)
+	database := initCheesesDatabase("./cheesedir-test.db")
+	syncDb(records, database)
+
+	for i, r := range getAllCheeses(database) {
+		if !reflect.DeepEqual(recordToSlice(r), recordToSlice(records[i])) {
+			t.Errorf("Record read back was incorrect, got: %v, want: %v", recordToSlice(r), recordToSlice(records[i]))
+		}
+	}
+}
+
+// test for custom attributes, validated, stored in their own table, filtered on and exported
+func TestCustomAttributes(t *testing.T) {
+	database := initCheesesDatabase("./cheesedir-test.db")
+	if err := defineCustomAttribute(database, CustomAttribute{"price_per_kg", AttributeNumber, "0..500"}); err != nil {
+		t.Errorf("Defining an attribute failed: %v", err)
+	}
+	if err := defineCustomAttribute(database, CustomAttribute{"shelf", AttributeChoice, "A1|A2|B1"}); err != nil {
+		t.Errorf("Defining an attribute failed: %v", err)
+	}
+	if err := defineCustomAttribute(database, CustomAttribute{"Bad Name", AttributeText, ""}); err == nil {
+		t.Errorf("Invalid attribute name was accepted")
+	}
+
+	tests := []struct {
+		attr CustomAttribute
+		value string
+		want string
+		valid bool
+	}{
+		{CustomAttribute{"price_per_kg", AttributeNumber, "0..500"}, "42.50", "42.5", true},
+		{CustomAttribute{"price_per_kg", AttributeNumber, "0..500"}, "600", "", false},
+		{CustomAttribute{"shelf", AttributeChoice, "A1|A2|B1"}, "a2", "A2", true},
+		{CustomAttribute{"shelf", AttributeChoice, "A1|A2|B1"}, "C3", "", false},
+		{CustomAttribute{"supplier", AttributeText, "^[A-Z]"}, "fromagerie", "", false},
+		{CustomAttribute{"received", AttributeDate, ""}, "2020/05/01", "2020-05-01", true},
+	}
+	for _, test := range tests {
+		v, err := test.attr.validate(test.value)
+		if (err == nil) != test.valid || v.String != test.want {
+			t.Errorf("Validated %q was incorrect, got: %q and %v, want: %q", test.value, v.String, err, test.want)
+		}
+	}
+
+	// load data and insert the first records into DB, two of them with attributes
+	records := loadData("data/canadianCheeseDirectory.csv", 5)
+	records[0].Attributes = map[string]string{"price_per_kg": "42.5", "shelf": "A2"}
+	records[3].Attributes = map[string]string{"price_per_kg": "30"}
+	syncDb(records, database)
+
+	if rs := queryRecords(database, []Filter{{"attr.price_per_kg", "42.50"}}, ""); len(rs) != 1 || rs[0].CheeseId != records[0].CheeseId {
+		t.Errorf("Records matching the attribute were incorrect, got: %+v", rs)
+	}
+	if rs := queryRecords(database, []Filter{{"attr.shelf", "a2"}}, ""); len(rs) != 1 {
+		t.Errorf("Number of records matching the attribute was incorrect, got: %d, want: 1", len(rs))
+	}
+	r := getCheeseByRecordId(4, database)
+	attrs := loadCustomAttributes(database)
+	if s := attributesToSlice(r, attrs); !reflect.DeepEqual(s, []string{"30", ""}) {
+		t.Errorf("Exported attributes were incorrect, got: %v", s)
+	}
+
+	// deleting an attribute deletes its values
+	if err := deleteCustomAttribute(database, "shelf"); err != nil {
+		t.Errorf("Deleting an attribute failed: %v", err)
+	}
+	if r := getCheeseByRecordId(1, database); !reflect.DeepEqual(r.Attributes, map[string]string{"price_per_kg": "42.5"}) {
+		t.Errorf("Attributes after deletion were incorrect, got: %v", r.Attributes)
+	}
+	// records still holding the deleted attribute in memory do not bring it back
+	syncDb(records, database)
+	if r := getCheeseByRecordId(1, database); !reflect.DeepEqual(r.Attributes, map[string]string{"price_per_kg": "42.5"}) {
+		t.Errorf("Attributes after syncing stale records were incorrect, got: %v", r.Attributes)
+	}
+	if r := readAttributeValues(nil, records[0], true); r.Attributes != nil {
+		t.Errorf("Values of undefined attributes were kept, got: %v", r.Attributes)
+	}
+	deleteCustomAttribute(database, "price_per_kg")
+}
+
+// test for tasting reviews, their average rating on records and sorting by rating
+func TestReviews(t *testing.T) {
+	// load data and insert the first records into DB
+	records := loadData("data/canadianCheeseDirectory.csv", 5)
+	database := initCheesesDatabase("./cheesedir-test.db")
+	syncDb(records, database)
+	database.Exec(`DELETE FROM reviews`)
+
+	alice, bob := User{Username: "alice", Role: RoleEditor}, User{Username: "bob", Role: RoleEditor}
+	for _, r := range []Review{
+		{CheeseId: records[1].CheeseId, Username: "alice", Rating: 4},
+		{CheeseId: records[1].CheeseId, Username: "bob", Rating: 5, Notes: nullString("nutty")},
+		{CheeseId: records[3].CheeseId, Username: "bob", Rating: 2},
+	} {
+		if err := addReview(database, r); err != nil {
+			t.Errorf("Adding a review failed: %v", err)
+		}
+	}
+	if err := addReview(database, Review{CheeseId: records[1].CheeseId, Username: "alice", Rating: 6}); err == nil {
+		t.Errorf("Invalid rating was accepted")
+	}
+	if err := addReview(database, Review{CheeseId: -1, Username: "alice", Rating: 3}); err == nil {
+		t.Errorf("Review of an unknown cheese was accepted")
+	}
+
+	// ratings survive syncing the records
+	syncDb(records, database)
+	r := getCheeseByRecordId(2, database)
+	if r.ReviewCount != 2 || r.AverageRating.Float64 != 4.5 || describeRating(r) != "rated 4.5/5 from 2 reviews" {
+		t.Errorf("Rating was incorrect, got: %v from %d reviews", r.AverageRating, r.ReviewCount)
+	}
+
+	orderBy, _ := sortExpression(SortRating, true)
+	rs := queryRecords(database, nil, orderBy)
+	if rs[0].CheeseId != records[1].CheeseId || rs[1].CheeseId != records[3].CheeseId || rs[4].ReviewCount != 0 {
+		t.Errorf("Records sorted by rating were incorrect, got: %v, %v", rs[0].CheeseId, rs[1].CheeseId)
+	}
+
+	// only the author or an admin may delete a review
+	reviews := getReviews(database, records[3].CheeseId)
+	if len(reviews) != 1 {
+		t.Fatalf("Number of reviews was incorrect, got: %d, want: 1", len(reviews))
+	}
+	if err := deleteReview(database, alice, reviews[0].Id); err == nil {
+		t.Errorf("Review of another user was deleted")
+	}
+	if err := deleteReview(database, bob, reviews[0].Id); err != nil {
+		t.Errorf("Deleting a review failed: %v", err)
+	}
+	if n := len(getReviews(database, 0)); n != 2 {
+		t.Errorf("Number of reviews was incorrect, got: %d, want: 2", n)
+	}
+}
+
+// test for personal collections, filled by CheeseId and from search results and exported
+func TestCollections(t *testing.T) {
+	// load data and insert the first records into DB
+	records := loadData("data/canadianCheeseDirectory.csv", 5)
+	database := initCheesesDatabase("./cheesedir-test.db")
+	syncDb(records, database)
+	deleteCollection(database, "alice", "Holiday board")
+
+	n, err := addToCollection(database, "alice", "Holiday board", []int{records[3].CheeseId, records[1].CheeseId, records[3].CheeseId})
+	if err != nil || n != 2 {
+		t.Errorf("Adding cheeses was incorrect, got: %d and %v, want: 2", n, err)
+	}
+	if _, err = addToCollection(database, "alice", "Holiday board", []int{-1}); err == nil {
+		t.Errorf("Unknown cheese was added")
+	}
+
+	// search results are added by their CheeseIds
+	ids, err := parseCheeseIdsAndFilters(database, []string{"cheese_name=" + records[0].CheeseName.String})
+	if err != nil || !reflect.DeepEqual(ids, []int{records[0].CheeseId}) {
+		t.Errorf("CheeseIds of search results were incorrect, got: %v and %v", ids, err)
+	}
+	addToCollection(database, "alice", "holiday BOARD", ids)
+
+	// collections are personal and keep the order cheeses were added in
+	if _, err = getCollectionRecords(database, "bob", "Holiday board"); err == nil {
+		t.Errorf("Collection of another user was found")
+	}
+	rs, err := getCollectionRecords(database, "alice", "Holiday board")
+	if err != nil || len(rs) != 3 || rs[0].CheeseId != records[3].CheeseId || rs[2].CheeseId != records[0].CheeseId {
+		t.Errorf("Collection records were incorrect, got: %d records and %v", len(rs), err)
+	}
+
+	if n, _ := removeFromCollection(database, "alice", "Holiday board", []int{records[1].CheeseId}); n != 1 {
+		t.Errorf("Number of removed cheeses was incorrect, got: %d, want: 1", n)
+	}
+	cs := getCollections(database, "alice")
+	if len(cs) != 1 || cs[0].NumCheeses != 2 {
+		t.Errorf("Collections were incorrect, got: %+v", cs)
+	}
+
+	if err := exportCollection(database, "alice", "Holiday board", "xml", ""); err == nil {
+		t.Errorf("Unknown format was accepted")
+	}
+}
+
+// test for receiving, moving and reporting stock lots
+func TestInventory(t *testing.T) {
+	// load data and insert the first records into DB
+	records := loadData("data/canadianCheeseDirectory.csv", 5)
+	database := initCheesesDatabase("./cheesedir-test.db")
+	syncDb(records, database)
+	cheeseId := records[2].CheeseId
+	database.Exec(`DELETE FROM stock_movements WHERE lot_id IN (SELECT id FROM inventory_lots WHERE cheese_id = ?)`, cheeseId)
+	database.Exec(`DELETE FROM inventory_lots WHERE cheese_id = ?`, cheeseId)
+	database.Exec(`DELETE FROM stock_thresholds WHERE cheese_id = ?`, cheeseId)
+
+	soon, _ := parseDate(today().AddDate(0, 0, 3).Format(DateLayout))
+	later, _ := parseDate(today().AddDate(0, 1, 0).Format(DateLayout))
+	lots := []StockLot{
+		{CheeseId: cheeseId, LotNumber: "B-2", Unit: UnitKg, Quantity: 4, UnitCost: parseNullFloat("20"), BestBeforeDate: later},
+		{CheeseId: cheeseId, LotNumber: "A-1", Unit: UnitKg, Quantity: 2, UnitCost: parseNullFloat("18.5"), BestBeforeDate: soon},
+		{CheeseId: cheeseId, LotNumber: "A-1", Unit: UnitKg, Quantity: 1},
+	}
+	for _, l := range lots {
+		if err := receiveStock(database, "alice", l); err != nil {
+			t.Errorf("Receiving stock was incorrect, got: %v", err)
+		}
+	}
+	if err := receiveStock(database, "alice", StockLot{CheeseId: cheeseId, LotNumber: "A-1", Unit: UnitWheel, Quantity: 1}); err == nil {
+		t.Errorf("Lot was received in another unit")
+	}
+	if err := receiveStock(database, "alice", StockLot{CheeseId: -1, LotNumber: "X", Unit: UnitKg, Quantity: 1}); err == nil {
+		t.Errorf("Stock of an unknown cheese was received")
+	}
+
+	// stock is taken from the lots closest to their best-before date first
+	if err := takeStock(database, "alice", MovementSell, cheeseId, "", UnitKg, 4, ""); err != nil {
+		t.Errorf("Selling stock was incorrect, got: %v", err)
+	}
+	if err := takeStock(database, "alice", MovementWaste, cheeseId, "", UnitKg, 10, "mould"); err == nil {
+		t.Errorf("More stock than available was wasted")
+	}
+	got := getStockLots(database, cheeseId)
+	if len(got) != 1 || got[0].LotNumber != "B-2" || got[0].Quantity != 3 {
+		t.Errorf("Lots in stock were incorrect, got: %+v", got)
+	}
+	if ms := getStockMovements(database, cheeseId, 10); len(ms) != 5 || ms[0].Kind != MovementSell || ms[0].LotNumber != "B-2" {
+		t.Errorf("Stock movements were incorrect, got: %+v", ms)
+	}
+
+	setStockThreshold(database, cheeseId, UnitKg, 5)
+	found := false
+	for _, a := range getLowStockAlerts(database) {
+		found = found || (a.CheeseId == cheeseId && a.Quantity == 3)
+	}
+	if !found {
+		t.Errorf("Low-stock alert was missing")
+	}
+
+	rows := stockValuationRows(database)
+	for _, row := range rows {
+		if row[0] == strconv.Itoa(cheeseId) && row[4] != "60.00" {
+			t.Errorf("Stock value was incorrect, got: %s, want: 60.00", row[4])
+		}
+	}
+	// kilograms are kept to the gram, so fractions sell out exactly, and wheels are whole
+	receiveStock(database, "alice", StockLot{CheeseId: cheeseId, LotNumber: "C-3", Unit: UnitKg, Quantity: 0.3})
+	database.Exec(`UPDATE inventory_lots SET quantity = 0 WHERE cheese_id = ? AND lot_number != 'C-3'`, cheeseId)
+	for _, quantity := range []float64{0.1, 0.2} {
+		if err := takeStock(database, "alice", MovementSell, cheeseId, "", UnitKg, quantity, ""); err != nil {
+			t.Errorf("Selling %g kg was incorrect, got: %v", quantity, err)
+		}
+	}
+	if got := getStockLots(database, cheeseId); len(got) != 0 {
+		t.Errorf("Lots in stock after selling out were incorrect, got: %+v", got)
+	}
+	if err := receiveStock(database, "alice", StockLot{CheeseId: cheeseId, LotNumber: "D-4", Unit: UnitWheel, Quantity: 0.5}); err == nil {
+		t.Errorf("Half a wheel was received")
+	}
+}
+
+// test for ranking the cheeses most similar to a cheese
+func TestFindSimilar(t *testing.T) {
+	rs := []Record{
+		{CheeseId: 1, CheeseName: nullString("Brie A"), MilkType: nullString("Cow"), RindType: nullString("Bloomy"),
+			CategoryType: nullString("Soft"), FatContentPercent: parseNullFloat("27"), Flavour: nullString("Buttery, mushroom notes")},
+		{CheeseId: 2, CheeseName: nullString("Brie B"), MilkType: nullString("Cow"), RindType: nullString("Bloomy"),
+			CategoryType: nullString("Soft"), FatContentPercent: parseNullFloat("28"), Flavour: nullString("Buttery and mushroom")},
+		{CheeseId: 3, CheeseName: nullString("Goat Log"), MilkType: nullString("Goat"), RindType: nullString("Bloomy"),
+			CategoryType: nullString("Fresh"), Flavour: nullString("Tangy, lemony")},
+		{CheeseId: 4, CheeseName: nullString("Gouda"), MilkType: nullString("Cow"), CategoryType: nullString("Firm"),
+			FatContentPercent: parseNullFloat("45"), Flavour: nullString("Nutty caramel")},
+	}
+
+	target, err := findCheese(rs, "brie a")
+	if err != nil || target.CheeseId != 1 {
+		t.Errorf("Cheese found by name was incorrect, got: %d and %v, want: 1", target.CheeseId, err)
+	}
+	if _, err = findCheese(rs, "brie"); err == nil {
+		t.Errorf("Ambiguous name was accepted")
+	}
+
+	similar := findSimilar(rs, target, 2)
+	if len(similar) != 2 || similar[0].Record.CheeseId != 2 {
+		t.Errorf("Most similar cheese was incorrect, got: %+v", similar)
+	}
+	if !stringInSlice("tastes buttery, mushroom", similar[0].Reasons) || !stringInSlice("rind: bloomy", similar[0].Reasons) {
+		t.Errorf("Similarity reasons were incorrect, got: %v", similar[0].Reasons)
+	}
+	if similar[0].Score <= similar[1].Score || similar[0].Score > 1 {
+		t.Errorf("Similarity scores were incorrect, got: %f and %f", similar[0].Score, similar[1].Score)
+	}
+}
+
+// test for building a cheese board satisfying its constraints
+func TestBuildBoard(t *testing.T) {
+	// firm, soft, firm, hard, semi-soft and soft cheeses by their moisture and fat, veined says nothing of firmness
+	rs := []Record{
+		{CheeseId: 1, CheeseName: nullString("Cheese 1"), MilkType: nullString("Cow"), MoisturePercent: parseNullFloat("40"),
+			FatContentPercent: parseNullFloat("30"), CategoryType: nullString("Veined Cheeses"), ManufacturerProvCode: nullString("QC")},
+		{CheeseId: 2, CheeseName: nullString("Cheese 2"), MilkType: nullString("Cow"), MoisturePercent: parseNullFloat("55"),
+			FatContentPercent: parseNullFloat("20"), CategoryType: nullString("Veined Cheeses"), Organic: true, ManufacturerProvCode: nullString("QC")},
+		{CheeseId: 3, CheeseName: nullString("Cheese 3"), MilkType: nullString("Goat"), MoisturePercent: parseNullFloat("40"),
+			FatContentPercent: parseNullFloat("30"), CategoryType: nullString("Veined Cheeses"), ManufacturerProvCode: nullString("QC")},
+		{CheeseId: 4, CheeseName: nullString("Cheese 4"), MilkType: nullString("Ewe"), MoisturePercent: parseNullFloat("32"),
+			FatContentPercent: parseNullFloat("35"), CategoryType: nullString("Veined Cheeses"), ManufacturerProvCode: nullString("ON")},
+		{CheeseId: 5, CheeseName: nullString("Cheese 5"), MilkType: nullString("Goat"), MoisturePercent: parseNullFloat("50"),
+			FatContentPercent: parseNullFloat("22"), CategoryType: nullString("Veined Cheeses"), Organic: true, ManufacturerProvCode: nullString("QC")},
+		// shares its milk types with the cow and the goat milk cheeses
+		{CheeseId: 6, CheeseName: nullString("Cheese 6"), MilkType: nullString("Cow and Goat"), MoisturePercent: parseNullFloat("55"),
+			FatContentPercent: parseNullFloat("20"), CategoryType: nullString("Veined Cheeses"), Organic: true, ManufacturerProvCode: nullString("QC")},
+	}
+	rs[3].AverageRating, rs[3].ReviewCount = parseNullFloat("5"), 1
+	rs[5].AverageRating, rs[5].ReviewCount = parseNullFloat("4"), 1
+	stock := map[int]string{1: "2 kg", 2: "1 wheel", 3: "3 kg", 4: "1 kg", 5: "1 kg", 6: "1 kg"}
+
+	picks, err := buildBoard(rs, stock, BoardConstraints{3, "", true, true, true, true})
+	if err != nil || !reflect.DeepEqual(boardCheeseIds(picks), []int{4, 1, 5}) {
+		t.Errorf("Board was incorrect, got: %v and %v, want: [4 1 5]", boardCheeseIds(picks), err)
+	}
+	if err == nil && !stringInSlice("the only hard cheese", picks[0].Reasons) {
+		t.Errorf("Board reasons were incorrect, got: %v", picks[0].Reasons)
+	}
+
+	// only cheeses of the province and in stock are chosen
+	delete(stock, 5)
+	picks, err = buildBoard(rs, stock, BoardConstraints{2, "qc", true, true, true, true})
+	if err != nil || !reflect.DeepEqual(boardCheeseIds(picks), []int{2, 3}) {
+		t.Errorf("Board was incorrect, got: %v and %v, want: [2 3]", boardCheeseIds(picks), err)
+	}
+	if _, err = buildBoard(rs, stock, BoardConstraints{3, "QC", true, true, true, true}); err == nil {
+		t.Errorf("Impossible board was built")
+	}
+	// there are fewer milk types than cheeses
+	if _, err = buildBoard(rs, stock, BoardConstraints{4, "", true, false, false, true}); err == nil || !strings.Contains(err.Error(), "3 milk types") {
+		t.Errorf("Board of too many distinct milk types was incorrect, got: %v", err)
+	}
+}
+
+// test for comparing cheeses side by side
+func TestCompareRecords(t *testing.T) {
+	rs := []Record{
+		{CheeseId: 1, CheeseName: nullString("Brie A"), MilkType: nullString("Cow"), FatContentPercent: parseNullFloat("27")},
+		{CheeseId: 2, CheeseName: nullString("Brie B"), MilkType: nullString("Cow"), FatContentPercent: parseNullFloat("29.5")},
+		{CheeseId: 3, CheeseName: nullString("Goat Log"), MilkType: nullString("Goat")},
+	}
+
+	if _, err := comparedRecords(rs, []int{1}); err == nil {
+		t.Errorf("Comparing a single cheese was accepted")
+	}
+	if _, err := comparedRecords(rs, []int{1, 1}); err == nil {
+		t.Errorf("Comparing a cheese with itself was accepted")
+	}
+	compared, err := comparedRecords(rs, []int{2, 1})
+	if err != nil || compared[0].CheeseId != 2 {
+		t.Errorf("Compared records were incorrect, got: %v", err)
+	}
+
+	c := compareRecords(rs[:2], nil)
+	if !reflect.DeepEqual(c.Rows[0], []string{"Field", "Brie A", "Brie B"}) {
+		t.Errorf("Comparison header was incorrect, got: %v", c.Rows[0])
+	}
+	for i, row := range c.Rows {
+		switch row[0] {
+			case "FatContentPercent":
+				if !c.Differs[i] || row[2] != "29.50 (+2.50)" {
+					t.Errorf("Fat comparison was incorrect, got: %v", row)
+				}
+			case "MilkType":
+				if c.Differs[i] {
+					t.Errorf("Equal milk types were marked as different")
+				}
+		}
+	}
+
+	var b strings.Builder
+	writeComparison(&b, c, FormatHTML)
+	if !strings.Contains(b.String(), `<tr class="differs"><td>FatContentPercent</td>`) {
+		t.Errorf("HTML comparison was incorrect, got: %s", b.String())
+	}
+}
+
+// test for the differences between two releases of the data file
+func TestDiffDataset(t *testing.T) {
+	dir := t.TempDir()
+	oldPath, newPath := dir + "/old.csv", dir + "/new.csv"
+	os.WriteFile(oldPath, []byte("CheeseId,CheeseNameEn,FlavourEn\n1,Brie,Buttery\n2,Gouda,Nutty\n3,Feta,Salty\n3,Feta,Salty\n"), 0644)
+	os.WriteFile(newPath, []byte(byteOrderMark + "CheeseId,CheeseNameEn,FlavourEn\n1,Brie,Buttery\n2,Gouda,Caramel\n4,Oka,Fruity\n"), 0644)
+
+	d, err := diffDataset(nil, oldPath, newPath)
+	if err != nil {
+		t.Fatalf("Diff was incorrect, got: %v", err)
+	}
+	if !reflect.DeepEqual(d.Added, []DiffRecord{{4, "Oka"}}) || !reflect.DeepEqual(d.Removed, []DiffRecord{{3, "Feta"}}) {
+		t.Errorf("Added and removed records were incorrect, got: %v and %v", d.Added, d.Removed)
+	}
+	if len(d.Changed) != 1 || !reflect.DeepEqual(d.Changed[0].Changes, []FieldChange{{"Flavour", "Nutty", "Caramel"}}) {
+		t.Errorf("Changed records were incorrect, got: %+v", d.Changed)
+	}
+	if d.Unchanged != 1 || !reflect.DeepEqual(d.Duplicates, []int{3}) {
+		t.Errorf("Unchanged and duplicate records were incorrect, got: %d and %v", d.Unchanged, d.Duplicates)
+	}
+
+	var b strings.Builder
+	if err = writeDiffJSON(&b, d); err != nil || !strings.Contains(b.String(), `"field": "Flavour"`) {
+		t.Errorf("JSON diff was incorrect, got: %s", b.String())
+	}
+	if _, err = diffDataset(nil, dir + "/missing.csv", newPath); err == nil {
+		t.Errorf("Missing data file was accepted")
+	}
+}
+
+// test for merging a new release with the local edits since the last import
+func TestMergeImport(t *testing.T) {
+	base := []Record{
+		{CheeseId: 1, CheeseName: nullString("Brie"), Flavour: nullString("Buttery")},
+		{CheeseId: 2, CheeseName: nullString("Gouda"), Flavour: nullString("Nutty")},
+		{CheeseId: 3, CheeseName: nullString("Feta"), Flavour: nullString("Salty")},
+		{CheeseId: 4, CheeseName: nullString("Oka"), Flavour: nullString("Fruity")},
+		{CheeseId: 5, CheeseName: nullString("Bleu"), Flavour: nullString("Sharp")},
+	}
+	// upstream edits 1 and 2, removes 3 and 5, edits 4 and adds 6
+	upstream := []Record{
+		{CheeseId: 1, CheeseName: nullString("Brie"), Flavour: nullString("Creamy")},
+		{CheeseId: 2, CheeseName: nullString("Gouda"), Flavour: nullString("Caramel")},
+		{CheeseId: 4, CheeseName: nullString("Oka"), Flavour: nullString("Pungent")},
+		{CheeseId: 6, CheeseName: nullString("Cheddar"), Flavour: nullString("Tangy")},
+	}
+	// locally 2 was edited differently, 4 was deleted, 5 was edited and 7 was created
+	local := []Record{
+		{CheeseId: 1, CheeseName: nullString("Brie"), Flavour: nullString("Buttery")},
+		{CheeseId: 2, CheeseName: nullString("Gouda"), Flavour: nullString("Sweet")},
+		{CheeseId: 3, CheeseName: nullString("Feta"), Flavour: nullString("Salty")},
+		{CheeseId: 5, CheeseName: nullString("Bleu"), Flavour: nullString("Strong")},
+		{CheeseId: 7, CheeseName: nullString("Mine"), Flavour: nullString("Local")},
+	}
+
+	changes := planMerge(base, upstream, local)
+	var conflicts []string
+	for _, c := range changes {
+		if c.Conflict {
+			conflicts = append(conflicts, strconv.Itoa(c.CheeseId) + " " + c.Kind)
+		}
+	}
+	if !reflect.DeepEqual(conflicts, []string{"2 update", "4 restore", "5 delete"}) {
+		t.Errorf("Merge conflicts were incorrect, got: %v", conflicts)
+	}
+
+	// conflicts keep the local side unless resolved in favour of upstream
+	merged := applyMerge(local, upstream, changes)
+	var got []string
+	for _, r := range merged {
+		got = append(got, strconv.Itoa(r.CheeseId) + " " + r.Flavour.String)
+	}
+	if !reflect.DeepEqual(got, []string{"1 Creamy", "2 Sweet", "5 Strong", "7 Local", "6 Tangy"}) {
+		t.Errorf("Merged records were incorrect, got: %v", got)
+	}
+	if local[0].Flavour.String != "Buttery" {
+		t.Errorf("Local records were modified by the merge")
+	}
+
+	resolveConflicts(changes, ResolveUpstream)
+	merged = applyMerge(local, upstream, changes)
+	got = nil
+	for _, r := range merged {
+		got = append(got, strconv.Itoa(r.CheeseId) + " " + r.Flavour.String)
+	}
+	if !reflect.DeepEqual(got, []string{"1 Creamy", "2 Caramel", "7 Local", "4 Pungent", "6 Tangy"}) {
+		t.Errorf("Records merged in favour of upstream were incorrect, got: %v", got)
+	}
+
+	// the snapshot of an import is the base of the next merge
+	database := initCheesesDatabase("./cheesedir-test.db")
+	if err := saveUpstreamSnapshot(database, "old.csv", base); err != nil {
+		t.Errorf("Saving the snapshot was incorrect, got: %v", err)
+	}
+	snapshot, _, ok := loadUpstreamSnapshot(database)
+	if !ok || len(snapshot) != len(base) || len(compareFields(base[1], snapshot[1])) != 0 {
+		t.Errorf("Snapshot was incorrect, got: %d records", len(snapshot))
+	}
+}
+
+// test for finding and merging duplicate cheeses
+func TestDuplicates(t *testing.T) {
+	rs := []Record{
+		{CheeseId: 1, CheeseName: nullString("Le Migneron de Charlevoix"), ManufacturerName: nullString("Maison d'affinage Maurice Dufour"),
+			ManufacturerProvCode: nullString("QC"), MilkType: nullString("Cow")},
+		{CheeseId: 2, CheeseName: nullString("Gouda"), ManufacturerName: nullString("Fromagerie X"),
+			ManufacturerProvCode: nullString("QC"), MilkType: nullString("Cow")},
+		{CheeseId: 3, CheeseName: nullString("Migneron de Charlevoix (Le)"), ManufacturerName: nullString("Maison d'Affinage Maurice-Dufour"),
+			ManufacturerProvCode: nullString("QC"), MilkType: nullString("Cow"), Flavour: nullString("Buttery")},
+		{CheeseId: 4, CheeseName: nullString("Gouda au fenugrec"), ManufacturerName: nullString("Fromagerie Y"),
+			ManufacturerProvCode: nullString("QC"), MilkType: nullString("Goat")},
+		// a local addition without a manufacturer
+		{CheeseId: 5, CheeseName: nullString("Gouda au fenugrec"), ManufacturerProvCode: nullString("QC"), MilkType: nullString("Goat")},
+	}
+
+	if levenshtein("gouda", "gooda") != 1 || textSimilarity("fromagerie x", "fromagerie x") != 1 {
+		t.Errorf("Text similarity was incorrect")
+	}
+
+	pairs := findDuplicates(rs, DefaultDuplicateScore)
+	if len(pairs) != 2 || pairs[0].A != 0 || pairs[0].B != 2 || pairs[1].A != 3 || pairs[1].B != 4 || !stringInSlice("same name", pairs[0].Reasons) {
+		t.Errorf("Duplicate pairs were incorrect, got: %+v", pairs)
+	}
+
+	fromDrop := []string{"flavour"}
+	merged, err := mergeDuplicateRecords(rs, 0, 2, fromDrop)
+	if fromDrop[0] != "flavour" {
+		t.Errorf("Fields given to the merge were modified, got: %v", fromDrop)
+	}
+	if err != nil || len(merged) != 4 || merged[0].Flavour.String != "Buttery" || merged[0].CheeseName.String != "Le Migneron de Charlevoix" {
+		t.Errorf("Merged records were incorrect, got: %d records and %v", len(merged), err)
+	}
+	if len(rs) != 5 || rs[0].Flavour.Valid {
+		t.Errorf("Records were modified by the merge")
+	}
+	if _, err = mergeDuplicateRecords(rs, 0, 2, []string{"AverageRating"}); err == nil {
+		t.Errorf("Computed field was taken from the dropped record")
+	}
+}
+
+// test to verify that data files are read plain, gzip-compressed, from zip archives and from stdin
+func TestDataFileFormats(t *testing.T) {
+	dir := t.TempDir()
+	data := []byte("CheeseId,CheeseNameEn\n1,Brie\n2,Gouda\n")
+	want := [][]string{{"CheeseId", "CheeseNameEn"}, {"1", "Brie"}, {"2", "Gouda"}}
+
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(data)
+	gw.Close()
+
+	writeZip := func(filePath string, names ...string) {
+		var b bytes.Buffer
+		zw := zip.NewWriter(&b)
+		for _, name := range names {
+			f, _ := zw.Create(name)
+			f.Write(data)
+		}
+		zw.Close()
+		os.WriteFile(filePath, b.Bytes(), 0644)
+	}
+
+	os.WriteFile(dir + "/plain.csv", data, 0644)
+	os.WriteFile(dir + "/release.csv.gz", gz.Bytes(), 0644)
+	// compressed without the extension, found by its first bytes
+	os.WriteFile(dir + "/renamed.csv", gz.Bytes(), 0644)
+	writeZip(dir + "/release.zip", "README.txt", "data/cheeses.csv")
+	writeZip(dir + "/several.zip", "old.csv", "new.csv")
+
+	for _, filePath := range []string{"plain.csv", "release.csv.gz", "renamed.csv", "release.zip", "several.zip#new.csv"} {
+		lines, err := getLinesFromCSV(dir + "/" + filePath)
+		if err != nil || !reflect.DeepEqual(lines, want) {
+			t.Errorf("Reading %s was incorrect, got: %v, %v", filePath, lines, err)
+		}
+	}
+	for _, filePath := range []string{"several.zip", "release.zip#missing.csv"} {
+		if _, err := getLinesFromCSV(dir + "/" + filePath); err == nil {
+			t.Errorf("Reading %s was accepted", filePath)
+		}
+	}
+
+	// stdin is read once and kept for the next reads of the same data file
+	stdin := os.Stdin
+	defer func() { os.Stdin = stdin }()
+	os.Stdin, _ = os.Open(dir + "/release.csv.gz")
+	for i := 0; i < 2; i++ {
+		lines, err := getLinesFromCSV(StdinPath)
+		if err != nil || !reflect.DeepEqual(lines, want) {
+			t.Errorf("Reading stdin was incorrect, got: %v, %v", lines, err)
+		}
+	}
+
+	if findDataFile(dir + "/release.csv") != dir + "/release.csv.gz" || findDataFile(dir + "/plain.csv") != dir + "/plain.csv" {
+		t.Errorf("Compressed data file was not found")
+	}
+}
+
+// test to verify that data files are transcoded to UTF-8, detecting their encoding or as chosen
+func TestDecodeText(t *testing.T) {
+	tests := []struct {
+		data string
+		encoding string
+		want string
+		detected string
+		invalid int
+	}{
+		{byteOrderMark + "CheeseId\n1,Fermière\n", EncodingAuto, "CheeseId\n1,Fermière\n", EncodingUTF8BOM, 0},
+		{"1,Fermière\n", EncodingAuto, "1,Fermière\n", EncodingUTF8, 0},
+		{"1,Fermi\xe8re \x80\n", EncodingAuto, "1,Fermière €\n", EncodingWindows1252, 0},
+		// UTF-8 text with a stray invalid byte keeps its accents and reports the byte
+		{"1,Fermi\xc3\xa8re \xff\n", EncodingAuto, "1,Fermière \uFFFD\n", EncodingUTF8, 1},
+		{"1,Fermi\xe8re \x80\n", EncodingISO88591, "1,Fermière \u0080\n", EncodingISO88591, 0},
+		{"1,Brie\n2,Fermi\xe8re\n", "UTF8", "1,Brie\n2,Fermi�re\n", EncodingUTF8, 1},
+		{"1,\x81\x8d\n", "cp1252", "1,��\n", EncodingWindows1252, 2},
+	}
+
+	for _, test := range tests {
+		got, d, err := decodeText([]byte(test.data), test.encoding)
+		if err != nil || got != test.want || d.Encoding != test.detected || d.Invalid != test.invalid {
+			t.Errorf("Decoding %q as %s was incorrect, got: %q, %+v, %v", test.data, test.encoding, got, d, err)
+		}
+	}
+	if _, d, _ := decodeText([]byte("1,Brie\n2,Fermi\xe8re\n"), EncodingUTF8); d.FirstInvalidLine != 2 {
+		t.Errorf("Line of the first invalid byte sequence was incorrect, got: %d", d.FirstInvalidLine)
+	}
+	if _, _, err := decodeText([]byte("1,Brie\n"), "ebcdic"); err == nil {
+		t.Errorf("Unknown encoding was accepted")
+	}
+
+	// the CSV loader transcodes with the encoding chosen
+	filePath := t.TempDir() + "/old.csv"
+	os.WriteFile(filePath, []byte("CheeseId,ManufacturingTypeFr\n1,Ferme Fermi\xe8re\n"), 0644)
+	defer setDataFileEncoding(dataFileEncoding)
+	for _, encoding := range []string{EncodingAuto, EncodingWindows1252} {
+		setDataFileEncoding(encoding)
+		lines, err := getLinesFromCSV(filePath)
+		if err != nil || lines[1][1] != "Ferme Fermière" {
+			t.Errorf("Reading a %s data file was incorrect, got: %v, %v", encoding, lines, err)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// CST8333 Cheese Directory App - Firmness and Fat Classifications - Lucas Estienne
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const UnknownClass = "unknown"
+
+// simple data structure containing the lower bound of a classification
+type ClassBound struct {
+	Label string
+	Bound float64
+	Inclusive bool
+}
+
+// firmness by moisture on fat-free basis (Canadian compositional standards), checked in order
+var firmnessClasses = []ClassBound {
+	{"soft", 67, false},
+	{"semi-soft", 62, true},
+	{"firm", 50, true},
+	{"hard", 0, true},
+}
+
+// fat class by fat in dry matter (Codex general standard for cheese), checked in order
+var fatClasses = []ClassBound {
+	{"high-fat", 60, true},
+	{"full-fat", 45, true},
+	{"medium-fat", 25, true},
+	{"low-fat", 10, true},
+	{"skim", 0, true},
+}
+
+// SQL expressions for the computed columns, usable in searches and reports
+const mffbExpression = `(CASE WHEN fat_content_percent >= 0 AND fat_content_percent < 100 AND moisture_percent > 0
+	THEN moisture_percent * 100.0 / (100 - fat_content_percent) END)`
+const fdmExpression = `(CASE WHEN fat_content_percent >= 0 AND moisture_percent > 0 AND moisture_percent < 100
+	THEN fat_content_percent * 100.0 / (100 - moisture_percent) END)`
+
+var computedColumns = map[string]string {
+	"mffb_percent": mffbExpression,
+	"firmness_class": classExpression(mffbExpression, firmnessClasses),
+	"fdm_percent": fdmExpression,
+	"fat_class": classExpression(fdmExpression, fatClasses),
+}
+
+// headers of the computed columns added to exports
+var classificationHeaders = []string { "MFFBPercent", "FirmnessClass", "FDMPercent", "FatClass" }
+
+// helper function to build the SQL CASE expression classifying a value
+func classExpression(expression string, classes []ClassBound) string {
+	var whens []string
+	for _, c := range classes {
+		op := ">"
+		if c.Inclusive {
+			op = ">="
+		}
+		whens = append(whens, fmt.Sprintf("WHEN %s %s %g THEN '%s'", expression, op, c.Bound, c.Label))
+	}
+	return fmt.Sprintf("(CASE WHEN %s IS NULL THEN '%s' %s ELSE '%s' END)", expression, UnknownClass, strings.Join(whens, " "), UnknownClass)
+}
+
+// helper function to get the SQL expression of a column, computed columns are expanded
+func columnExpression(column string) string {
+	if expression, ok := computedColumns[column]; ok {
+		return expression
+	}
+	return column
+}
+
+// helper function to classify a value
+func classify(value float64, classes []ClassBound) string {
+	for _, c := range classes {
+		if value > c.Bound || (c.Inclusive && value == c.Bound) {
+			return c.Label
+		}
+	}
+	return UnknownClass
+}
+
+// function to compute the moisture on fat-free basis, ok is false when fat or moisture is unknown
+func (r Record) MFFB() (float64, bool) {
+	fat, moisture := r.FatContentPercent.Float64, r.MoisturePercent.Float64
+	if !r.FatContentPercent.Valid || !r.MoisturePercent.Valid || fat < 0 || fat >= 100 || moisture <= 0 {
+		return 0, false
+	}
+	return moisture * 100 / (100 - fat), true
+}
+
+// function to compute the fat in dry matter, ok is false when fat or moisture is unknown
+func (r Record) FDM() (float64, bool) {
+	fat, moisture := r.FatContentPercent.Float64, r.MoisturePercent.Float64
+	if !r.FatContentPercent.Valid || !r.MoisturePercent.Valid || fat < 0 || moisture <= 0 || moisture >= 100 {
+		return 0, false
+	}
+	return fat * 100 / (100 - moisture), true
+}
+
+// function to get the firmness class (soft, semi-soft, firm or hard) of a record
+func (r Record) FirmnessClass() string {
+	mffb, ok := r.MFFB()
+	if !ok {
+		return UnknownClass
+	}
+	return classify(mffb, firmnessClasses)
+}
+
+// function to get the fat class (high-fat, full-fat, medium-fat, low-fat or skim) of a record
+func (r Record) FatClass() string {
+	fdm, ok := r.FDM()
+	if !ok {
+		return UnknownClass
+	}
+	return classify(fdm, fatClasses)
+}
+
+// helper function to convert the computed columns of a record to a slice matching classificationHeaders
+func classificationToSlice(r Record) []string {
+	mffb, fdm := "", ""
+	if v, ok := r.MFFB(); ok {
+		mffb = fmt.Sprintf("%.2f", v)
+	}
+	if v, ok := r.FDM(); ok {
+		fdm = fmt.Sprintf("%.2f", v)
+	}
+	return []string{mffb, r.FirmnessClass(), fdm, r.FatClass()}
+}
+
+// helper function to describe the computed columns of a record
+func classificationSummary(r Record) string {
+	mffb, fdm := "?", "?"
+	if v, ok := r.MFFB(); ok {
+		mffb = fmt.Sprintf("%.1f%%", v)
+	}
+	if v, ok := r.FDM(); ok {
+		fdm = fmt.Sprintf("%.1f%%", v)
+	}
+	return fmt.Sprintf("MFFB: %s (%s), FDM: %s (%s)", mffb, r.FirmnessClass(), fdm, r.FatClass())
+}
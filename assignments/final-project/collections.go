@@ -0,0 +1,466 @@
+// CST8333 Cheese Directory App - Personal Collections - Lucas Estienne
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"database/sql"
+	"errors"
+	"flag"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// collection the "favourite" command adds cheeses to
+const FavouritesCollection = "Favourites"
+
+// simple data structure containing a named list of cheeses belonging to a user
+type Collection struct {
+	Name string
+	Owner string
+	NumCheeses int
+	CreatedAt string
+}
+
+// function to create the collection tables
+func migrateCollections(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE collections (
+			id INTEGER PRIMARY KEY,
+			owner TEXT NOT NULL,
+			name TEXT NOT NULL COLLATE NOCASE,
+			created_at TEXT NOT NULL,
+			UNIQUE (owner, name)
+		)`,
+		`CREATE TABLE collection_cheeses (
+			collection_id INTEGER NOT NULL REFERENCES collections(id),
+			cheese_id INTEGER NOT NULL,
+			position INTEGER NOT NULL,
+			PRIMARY KEY (collection_id, cheese_id)
+		)`,
+	)
+}
+
+// helper function to find the id of a collection of a user, creating it when asked to
+func collectionId(q querier, owner string, name string, create bool) (int, error) {
+	var id int
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return 0, errors.New("a collection needs a name")
+	}
+
+	err := q.QueryRow(`SELECT id FROM collections WHERE owner = ? AND name = ?`, owner, name).Scan(&id)
+	if err == sql.ErrNoRows && create {
+		result, err := q.Exec(`
+			INSERT INTO collections (owner, name, created_at) VALUES (?, ?, ?)
+		`, owner, name, time.Now().UTC().Format(time.RFC3339))
+		if err != nil {
+			return 0, err
+		}
+		n, err := result.LastInsertId()
+		return int(n), err
+	}
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("you have no collection named %q", name)
+	}
+	return id, err
+}
+
+// function to add cheeses to a collection of a user, creating it if needed, returns the number of cheeses added
+func addToCollection(database *sql.DB, owner string, name string, cheeseIds []int) (int, error) {
+	added := 0
+
+	tx, err := database.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	id, err := collectionId(tx, owner, name, true)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, cheeseId := range cheeseIds {
+		if err = checkCheeseExists(tx, cheeseId); err != nil {
+			return 0, err
+		}
+
+		// cheeses keep the order they were added in
+		result, err := tx.Exec(`
+			INSERT OR IGNORE INTO collection_cheeses (collection_id, cheese_id, position)
+			SELECT ?, ?, COALESCE(MAX(position), 0) + 1 FROM collection_cheeses WHERE collection_id = ?
+		`, id, cheeseId, id)
+		if err != nil {
+			return 0, err
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			added++
+		}
+	}
+
+	return added, tx.Commit()
+}
+
+// function to remove cheeses from a collection of a user, returns the number of cheeses removed
+func removeFromCollection(database *sql.DB, owner string, name string, cheeseIds []int) (int, error) {
+	removed := 0
+
+	id, err := collectionId(database, owner, name, false)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, cheeseId := range cheeseIds {
+		result, err := database.Exec(`
+			DELETE FROM collection_cheeses WHERE collection_id = ? AND cheese_id = ?
+		`, id, cheeseId)
+		if err != nil {
+			return removed, err
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// function to delete a collection of a user
+func deleteCollection(database *sql.DB, owner string, name string) error {
+	id, err := collectionId(database, owner, name, false)
+	if err != nil {
+		return err
+	}
+
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM collection_cheeses WHERE collection_id = ?`, id)
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM collections WHERE id = ?`, id)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// function to select the collections of a user with their number of cheeses
+func getCollections(database *sql.DB, owner string) []Collection {
+	var cs []Collection
+
+	rows, err := database.Query(`
+		SELECT c.name, c.owner, COUNT(cc.cheese_id), c.created_at
+		FROM collections c LEFT JOIN collection_cheeses cc ON cc.collection_id = c.id
+		WHERE c.owner = ? GROUP BY c.id ORDER BY c.name
+	`, owner)
+	check(err)
+	defer rows.Close()
+
+	for rows.Next() {
+		var c Collection
+		if err = rows.Scan(&c.Name, &c.Owner, &c.NumCheeses, &c.CreatedAt); err != nil {
+			log.Fatal(err)
+		}
+		cs = append(cs, c)
+	}
+
+	return cs
+}
+
+// function to select the records of a collection of a user, in the order they were added
+func getCollectionRecords(database *sql.DB, owner string, name string) ([]Record, error) {
+	var rs []Record
+
+	id, err := collectionId(database, owner, name, false)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := database.Query(`
+		SELECT cheese_id FROM collection_cheeses WHERE collection_id = ? ORDER BY position
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	var cheeseIds []int
+	for rows.Next() {
+		var cheeseId int
+		if err = rows.Scan(&cheeseId); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		cheeseIds = append(cheeseIds, cheeseId)
+	}
+	rows.Close()
+
+	// records are read the normal way so they have their manufacturer, attributes and rating
+	byCheeseId := map[int][]Record{}
+	for _, r := range getAllCheeses(database) {
+		byCheeseId[r.CheeseId] = append(byCheeseId[r.CheeseId], r)
+	}
+	for _, cheeseId := range cheeseIds {
+		rs = append(rs, byCheeseId[cheeseId]...)
+	}
+	return rs, nil
+}
+
+// helper function to get the CheeseIds of records, without repeats
+func recordCheeseIds(rs []Record) []int {
+	var ids []int
+	seen := map[int]bool{}
+	for _, r := range rs {
+		if !seen[r.CheeseId] {
+			seen[r.CheeseId] = true
+			ids = append(ids, r.CheeseId)
+		}
+	}
+	return ids
+}
+
+// helper function to split arguments into CheeseIds and "column=value" filters selecting search results
+func parseCheeseIdsAndFilters(database *sql.DB, args []string) ([]int, error) {
+	var ids, filterArgs []string
+
+	for _, a := range args {
+		if strings.Contains(a, "=") {
+			filterArgs = append(filterArgs, a)
+		} else {
+			ids = append(ids, a)
+		}
+	}
+
+	var cheeseIds []int
+	for _, s := range ids {
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CheeseId %q", s)
+		}
+		cheeseIds = append(cheeseIds, id)
+	}
+
+	if len(filterArgs) > 0 {
+		filters, err := parseFilterArgs(filterArgs)
+		if err != nil {
+			return nil, err
+		}
+		cheeseIds = append(cheeseIds, recordCheeseIds(queryRecords(database, filters, ""))...)
+	}
+
+	if len(cheeseIds) == 0 {
+		return nil, errors.New("expected CheeseIds or column=value filters selecting search results")
+	}
+	return cheeseIds, nil
+}
+
+// function to export the records of a collection in one of the report formats, to the screen when the path is empty
+func exportCollection(database *sql.DB, owner string, name string, format string, filePath string) error {
+	if !stringInSlice(format, reportFormats) {
+		return fmt.Errorf("unknown format %q, expected one of %v", format, reportFormats)
+	}
+	rs, err := getCollectionRecords(database, owner, name)
+	if err != nil {
+		return err
+	}
+
+	attrs := loadCustomAttributes(database)
+	rows := [][]string{exportRowHeaders(attrs)}
+	for _, r := range rs {
+		rows = append(rows, exportRow(r, attrs))
+	}
+
+	w, closeOutput, err := createOutput(filePath)
+	if err != nil {
+		return err
+	}
+	defer closeOutput()
+
+	err = writeReportRows(w, rows, format)
+	if err == nil && filePath != "" {
+		fmt.Printf("\n Wrote %d cheeses to %s.\n", len(rs), filePath)
+	}
+	return err
+}
+
+// function to print collections as a table
+func printCollections(cs []Collection) {
+	rows := [][]string{{"Collection", "Cheeses", "Created"}}
+	for _, c := range cs {
+		rows = append(rows, []string{c.Name, strconv.Itoa(c.NumCheeses), strings.SplitN(c.CreatedAt, "T", 2)[0]})
+	}
+
+	writeAlignedTable(os.Stdout, rows)
+}
+
+// helper function to read CheeseIds separated by spaces or commas
+func readCheeseIds(toRead string) []int {
+	var ids []int
+	for _, s := range strings.FieldsFunc(readRequiredString(toRead), func(r rune) bool { return r == ' ' || r == ',' }) {
+		if id, err := strconv.Atoi(s); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// function to manage the collections of the user from the menu
+func manageCollections(database *sql.DB, user User) {
+	selection := 0
+
+	fmt.Printf("\nYour collections...\n\n")
+	printCollections(getCollections(database, user.Username))
+	fmt.Println()
+	fmt.Println(" 1. Display a collection")
+	fmt.Println(" 2. Add cheeses by CheeseId")
+	fmt.Println(" 3. Add the results of a search")
+	fmt.Println(" 4. Remove cheeses")
+	fmt.Println(" 5. Export a collection")
+	fmt.Println(" 6. Delete a collection")
+
+	// loop until selection is valid
+	for selection == 0 {
+		fmt.Printf("Please choose an option: ")
+
+		_, err := fmt.Scanf("%d", &selection)
+
+		if err != nil || selection < 1 || selection > 6 {
+			selection = 0
+			fmt.Println("\nPlease enter a valid option.")
+		}
+	}
+
+	var err error
+	var n int
+
+	name := readNewOrKeepDefaultString("collection name", FavouritesCollection)
+	switch selection {
+		case 1:
+			var rs []Record
+			if rs, err = getCollectionRecords(database, user.Username, name); err == nil {
+				printRecordTable(rs)
+			}
+		case 2:
+			n, err = addToCollection(database, user.Username, name, readCheeseIds("CheeseIds"))
+			if err == nil {
+				fmt.Printf("\nAdded %d cheeses to %s.\n", n, name)
+			}
+		case 3:
+			c, v := searchRecordHelper(attributeColumns(loadCustomAttributes(database)))
+			rs := queryRecords(database, []Filter{{c, v}}, "")
+			printRecordTable(rs)
+			if len(rs) > 0 && strings.HasPrefix(strings.ToLower(readNewOrKeepDefaultString("add these cheeses (y/n)", "y")), "y") {
+				n, err = addToCollection(database, user.Username, name, recordCheeseIds(rs))
+				if err == nil {
+					fmt.Printf("\nAdded %d cheeses to %s.\n", n, name)
+				}
+			}
+		case 4:
+			n, err = removeFromCollection(database, user.Username, name, readCheeseIds("CheeseIds"))
+			if err == nil {
+				fmt.Printf("\nRemoved %d cheeses from %s.\n", n, name)
+			}
+		case 5:
+			format := ""
+			for !stringInSlice(format, reportFormats) {
+				format = readNewOrKeepDefaultString(fmt.Sprintf("format %v", reportFormats), FormatCSV)
+			}
+			err = exportCollection(database, user.Username, name, format, readNewOrKeepDefaultString("file (Enter for the screen)", ""))
+		case 6:
+			err = deleteCollection(database, user.Username, name)
+			if err == nil {
+				fmt.Printf("\nDeleted %s.\n", name)
+			}
+	}
+
+	if err != nil {
+		fmt.Printf("\nError: %v\n", err)
+	}
+}
+
+// function to run the "collections" command
+func collectionsCommand(database *sql.DB, user User, args []string) error {
+	printCollections(getCollections(database, user.Username))
+	return nil
+}
+
+// function to run the "collection" command
+func collectionCommand(database *sql.DB, user User, args []string) error {
+	if len(args) != 1 {
+		return errors.New("expected the name of the collection")
+	}
+
+	rs, err := getCollectionRecords(database, user.Username, args[0])
+	if err != nil {
+		return err
+	}
+	printRecordTable(rs)
+	return nil
+}
+
+// function to run the "collection-add" command
+func collectionAddCommand(database *sql.DB, user User, args []string) error {
+	if len(args) < 2 {
+		return errors.New("expected the name of the collection and CheeseIds or column=value filters")
+	}
+
+	cheeseIds, err := parseCheeseIdsAndFilters(database, args[1:])
+	if err != nil {
+		return err
+	}
+	n, err := addToCollection(database, user.Username, args[0], cheeseIds)
+	if err == nil {
+		fmt.Printf("Added %d cheeses to %s.\n", n, args[0])
+	}
+	return err
+}
+
+// function to run the "collection-remove" command
+func collectionRemoveCommand(database *sql.DB, user User, args []string) error {
+	if len(args) < 2 {
+		return errors.New("expected the name of the collection and CheeseIds or column=value filters")
+	}
+
+	cheeseIds, err := parseCheeseIdsAndFilters(database, args[1:])
+	if err != nil {
+		return err
+	}
+	n, err := removeFromCollection(database, user.Username, args[0], cheeseIds)
+	if err == nil {
+		fmt.Printf("Removed %d cheeses from %s.\n", n, args[0])
+	}
+	return err
+}
+
+// function to run the "collection-delete" command
+func collectionDeleteCommand(database *sql.DB, user User, args []string) error {
+	if len(args) != 1 {
+		return errors.New("expected the name of the collection")
+	}
+	return deleteCollection(database, user.Username, args[0])
+}
+
+// function to run the "collection-export" command
+func collectionExportCommand(database *sql.DB, user User, args []string) error {
+	flags := flag.NewFlagSet("collection-export", flag.ContinueOnError)
+	format := flags.String("format", FormatCSV, fmt.Sprintf("output format %v", reportFormats))
+	filePath := flags.String("out", "", "output file, the screen when empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("expected the name of the collection")
+	}
+
+	return exportCollection(database, user.Username, flags.Arg(0), *format, *filePath)
+}
+
+// function to run the "favourite" command
+func favouriteCommand(database *sql.DB, user User, args []string) error {
+	return collectionAddCommand(database, user, append([]string{FavouritesCollection}, args...))
+}
@@ -0,0 +1,398 @@
+// CST8333 Cheese Directory App - Command Line Commands - Lucas Estienne
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"database/sql"
+	"strings"
+)
+
+// simple data structure describing a command line command
+type Command struct {
+	Name string
+	Usage string
+	Description string
+	Role string
+	Run func(database *sql.DB, user User, args []string) error
+}
+
+// commands available from the command line, e.g. "cheesedir stats"
+var commands = []Command {
+	{
+		Name: "stats",
+		Usage: "stats [column=value ...]",
+		Description: "Display counts and fat/moisture statistics, optionally filtered",
+		Role: RoleViewer,
+		Run: statsCommand,
+	},
+	{
+		Name: "pivot",
+		Usage: "pivot [-avg column] [-format table|csv|markdown] [-out file] row col [column=value ...]",
+		Description: "Cross-tabulate two columns with row/column totals",
+		Role: RoleViewer,
+		Run: pivotCommand,
+	},
+	{
+		Name: "quality",
+		Usage: "quality [-db] [-file csv] [-encoding name] [-limit n] [-export file]",
+		Description: "Audit completeness, translations, implausible values, duplicate ids and whitespace",
+		Role: RoleViewer,
+		Run: qualityCommand,
+	},
+	{
+		Name: "check-categories",
+		Usage: "check-categories [-fix]",
+		Description: "List categories contradicting the MFFB firmness, -fix to correct them interactively (editors)",
+		Role: RoleViewer,
+		Run: checkCategoriesCommand,
+	},
+	{
+		Name: "manufacturers",
+		Usage: "manufacturers [-search text]",
+		Description: "List manufacturers with their number of cheeses",
+		Role: RoleViewer,
+		Run: manufacturersCommand,
+	},
+	{
+		Name: "manufacturer-cheeses",
+		Usage: "manufacturer-cheeses manufacturer#",
+		Description: "Display all cheeses of one manufacturer",
+		Role: RoleViewer,
+		Run: manufacturerCheesesCommand,
+	},
+	{
+		Name: "manufacturer-rename",
+		Usage: "manufacturer-rename [-en name] [-fr name] [-prov code] [-website url] manufacturer#",
+		Description: "Rename a manufacturer, renaming it on all of its cheeses",
+		Role: RoleEditor,
+		Run: manufacturerRenameCommand,
+	},
+	{
+		Name: "manufacturer-merge",
+		Usage: "manufacturer-merge keep# merge# [merge# ...]",
+		Description: "Merge manufacturers into one, moving their cheeses",
+		Role: RoleEditor,
+		Run: manufacturerMergeCommand,
+	},
+	{
+		Name: "diff",
+		Usage: "diff [-json] [-out file] [-encoding name] old.csv [new.csv]",
+		Description: "List the cheeses added, removed and changed between two data files, or from the database to a data file",
+		Role: RoleViewer,
+		Run: diffCommand,
+	},
+	{
+		Name: "merge-import",
+		Usage: "merge-import [-base old.csv] [-encoding name] [-resolve ask|local|upstream] [-dry-run] new.csv",
+		Description: "Three-way merge a new release into the database against the last imported one, keeping local edits",
+		Role: RoleEditor,
+		Run: mergeImportCommand,
+	},
+	{
+		Name: "duplicates",
+		Usage: "duplicates [-min score] [-limit n]",
+		Description: "List pairs of records that may be the same cheese, by name, manufacturer and attribute similarity",
+		Role: RoleViewer,
+		Run: duplicatesCommand,
+	},
+	{
+		Name: "duplicate-merge",
+		Usage: "duplicate-merge [-take Field,Field] keep# drop#",
+		Description: "Merge a duplicate record into another, taking the given fields from the dropped record",
+		Role: RoleEditor,
+		Run: duplicateMergeCommand,
+	},
+	{
+		Name: "merge-history",
+		Usage: "merge-history [-limit n]",
+		Description: "List the merges of duplicate records",
+		Role: RoleViewer,
+		Run: mergeHistoryCommand,
+	},
+	{
+		Name: "search",
+		Usage: "search [-sort column] [-desc] column=value [column=value ...]",
+		Description: "Search records, e.g. aged_at_least=\"6 months\" -sort " + SortRipening + ", or -sort " + SortRating + " -desc",
+		Role: RoleViewer,
+		Run: searchCommand,
+	},
+	{
+		Name: "recent",
+		Usage: "recent [-limit n] [column=value ...]",
+		Description: "List the most recently changed cheeses, e.g. recent updated_since=2016-01-01",
+		Role: RoleViewer,
+		Run: recentCommand,
+	},
+	{
+		Name: "vocab",
+		Usage: "vocab [field]",
+		Description: "List the controlled vocabulary terms and aliases, of one field or all",
+		Role: RoleViewer,
+		Run: vocabCommand,
+	},
+	{
+		Name: "vocab-unmapped",
+		Usage: "vocab-unmapped [-file path] [-encoding name]",
+		Description: "Report data file values that do not map onto a vocabulary term",
+		Role: RoleViewer,
+		Run: vocabUnmappedCommand,
+	},
+	{
+		Name: "vocab-add",
+		Usage: "vocab-add [-code code] field english french",
+		Description: "Add a term to the vocabulary of a categorical field",
+		Role: RoleEditor,
+		Run: vocabAddCommand,
+	},
+	{
+		Name: "vocab-alias",
+		Usage: "vocab-alias field alias code",
+		Description: "Map another spelling onto a vocabulary term",
+		Role: RoleEditor,
+		Run: vocabAliasCommand,
+	},
+	{
+		Name: "attributes",
+		Usage: "attributes",
+		Description: "List the custom attributes, filter on them in search with attr.<name>=value",
+		Role: RoleViewer,
+		Run: attributesCommand,
+	},
+	{
+		Name: "attribute-define",
+		Usage: "attribute-define [-validation rule] name text|number|bool|date|choice",
+		Description: "Define a custom attribute or change its type and validation",
+		Role: RoleAdmin,
+		Run: attributeDefineCommand,
+	},
+	{
+		Name: "attribute-delete",
+		Usage: "attribute-delete name",
+		Description: "Delete a custom attribute and its values on every cheese",
+		Role: RoleAdmin,
+		Run: attributeDeleteCommand,
+	},
+	{
+		Name: "similar",
+		Usage: "similar [-n count] CheeseId|name",
+		Description: "List the cheeses most similar to a cheese with the attributes they share",
+		Role: RoleViewer,
+		Run: similarCommand,
+	},
+	{
+		Name: "board",
+		Usage: "board [-n count] [-province code] [-distinct-milk=false] [-mix-firmness=false] [-organic=false] [-in-stock=false] [-save collection]",
+		Description: "Assemble a cheese board satisfying the constraints and explain each choice, optionally saving it as a collection",
+		Role: RoleViewer,
+		Run: boardCommand,
+	},
+	{
+		Name: "compare",
+		Usage: "compare [-format table|markdown|html] [-out file] CheeseId CheeseId [CheeseId ...]",
+		Description: "Compare 2 to 5 cheeses field by field, marking the fields that differ",
+		Role: RoleViewer,
+		Run: compareCommand,
+	},
+	{
+		Name: "reviews",
+		Usage: "reviews [CheeseId]",
+		Description: "List the tasting reviews of a cheese, or of all cheeses",
+		Role: RoleViewer,
+		Run: reviewsCommand,
+	},
+	{
+		Name: "review-add",
+		Usage: "review-add [-notes text] CheeseId rating",
+		Description: "Rate a cheese from 1 to 5 with optional tasting notes",
+		Role: RoleEditor,
+		Run: reviewAddCommand,
+	},
+	{
+		Name: "review-delete",
+		Usage: "review-delete review#",
+		Description: "Delete one of your reviews, admins may delete any review",
+		Role: RoleEditor,
+		Run: reviewDeleteCommand,
+	},
+	{
+		Name: "collections",
+		Usage: "collections",
+		Description: "List your collections with their number of cheeses",
+		Role: RoleViewer,
+		Run: collectionsCommand,
+	},
+	{
+		Name: "collection",
+		Usage: "collection name",
+		Description: "Display the cheeses of one of your collections",
+		Role: RoleViewer,
+		Run: collectionCommand,
+	},
+	{
+		Name: "collection-add",
+		Usage: "collection-add name [CheeseId ...] [column=value ...]",
+		Description: "Add cheeses by CheeseId or the results of a search to a collection, creating it if needed",
+		Role: RoleViewer,
+		Run: collectionAddCommand,
+	},
+	{
+		Name: "collection-remove",
+		Usage: "collection-remove name [CheeseId ...] [column=value ...]",
+		Description: "Remove cheeses by CheeseId or the results of a search from a collection",
+		Role: RoleViewer,
+		Run: collectionRemoveCommand,
+	},
+	{
+		Name: "collection-delete",
+		Usage: "collection-delete name",
+		Description: "Delete one of your collections",
+		Role: RoleViewer,
+		Run: collectionDeleteCommand,
+	},
+	{
+		Name: "collection-export",
+		Usage: "collection-export [-format table|csv|markdown] [-out file] name",
+		Description: "Export the cheeses of a collection",
+		Role: RoleViewer,
+		Run: collectionExportCommand,
+	},
+	{
+		Name: "favourite",
+		Usage: "favourite CheeseId [CheeseId ...]",
+		Description: "Add cheeses to your " + FavouritesCollection + " collection",
+		Role: RoleViewer,
+		Run: favouriteCommand,
+	},
+	{
+		Name: "stock",
+		Usage: "stock [CheeseId]",
+		Description: "List the lots in stock of a cheese, or of all cheeses",
+		Role: RoleViewer,
+		Run: stockCommand,
+	},
+	{
+		Name: "stock-receive",
+		Usage: "stock-receive -lot number [-unit kg|wheel] [-cost price] [-received date] [-best-before date] CheeseId quantity",
+		Description: "Receive stock into a lot, creating the lot if needed",
+		Role: RoleEditor,
+		Run: stockReceiveCommand,
+	},
+	{
+		Name: "stock-sell",
+		Usage: "stock-sell [-lot number] [-unit kg|wheel] [-note text] CheeseId quantity",
+		Description: "Sell stock of a cheese, from the lots closest to their best-before date unless a lot is given",
+		Role: RoleEditor,
+		Run: stockTakeCommand(MovementSell),
+	},
+	{
+		Name: "stock-waste",
+		Usage: "stock-waste [-lot number] [-unit kg|wheel] [-note reason] CheeseId quantity",
+		Description: "Write off wasted stock of a cheese, from the lots closest to their best-before date unless a lot is given",
+		Role: RoleEditor,
+		Run: stockTakeCommand(MovementWaste),
+	},
+	{
+		Name: "stock-movements",
+		Usage: "stock-movements [-limit n] [CheeseId]",
+		Description: "List the most recent stock movements of a cheese, or of all cheeses",
+		Role: RoleViewer,
+		Run: stockMovementsCommand,
+	},
+	{
+		Name: "stock-threshold",
+		Usage: "stock-threshold [-unit kg|wheel] CheeseId minimum",
+		Description: "Set the quantity below which a cheese is low on stock, 0 removes the threshold",
+		Role: RoleEditor,
+		Run: stockThresholdCommand,
+	},
+	{
+		Name: "stock-alerts",
+		Usage: "stock-alerts [-days n]",
+		Description: "List the cheeses low on stock and the lots near or past their best-before date",
+		Role: RoleViewer,
+		Run: stockAlertsCommand,
+	},
+	{
+		Name: "stock-valuation",
+		Usage: "stock-valuation [-format table|csv|markdown] [-out file]",
+		Description: "Report the quantity and value in stock of each cheese",
+		Role: RoleViewer,
+		Run: stockValuationCommand,
+	},
+}
+
+// function to run a command line command and return the process exit code
+func runCommand(database *sql.DB, user User, args []string) int {
+	name := args[0]
+
+	if name == "help" || name == "-h" || name == "--help" {
+		printCommandUsage(user)
+		return 0
+	}
+
+	for _, c := range commands {
+		if c.Name != name {
+			continue
+		}
+
+		// same role enforcement and auditing as the menu
+		if !hasRole(user, c.Role) {
+			writeAuditLog(database, user.Username, c.Name, AuditDenied, "role "+user.Role)
+			fmt.Fprintf(os.Stderr, "Your role (%s) does not allow the %s command.\n", user.Role, c.Name)
+			return 1
+		}
+		if c.Role != RoleViewer {
+			writeAuditLog(database, user.Username, c.Name, AuditAllowed, strings.Join(args[1:], " "))
+		}
+
+		err := c.Run(database, user, args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "Unknown command %q.\n", name)
+	printCommandUsage(user)
+	return 2
+}
+
+// function to print the commands the user's role allows
+func printCommandUsage(user User) {
+	fmt.Println("Usage: cheesedir [command] [arguments]")
+	fmt.Println("Without a command the interactive menu is shown. Commands:")
+	for _, c := range commands {
+		if hasRole(user, c.Role) {
+			fmt.Printf("  %s\n      %s\n", c.Usage, c.Description)
+		}
+	}
+	fmt.Printf("Data files may be plain CSV, gzip-compressed (.csv.gz), a zip archive (.zip, or .zip%s<name> to choose the CSV file) or %s for stdin.\n", zipMemberSeparator, StdinPath)
+	fmt.Printf("Their encoding is detected, or chosen with -encoding or %s, one of %v.\n", EncodingEnvVar, encodingChoices[1:])
+}
+
+// helper function to parse "column=value" arguments into filters
+func parseFilterArgs(args []string) ([]Filter, error) {
+	var filters []Filter
+
+	for _, a := range args {
+		parts := strings.SplitN(a, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid filter %q, expected column=value", a)
+		}
+		// custom attributes are matched on attr.<name>
+		if !stringInSlice(parts[0], matchColumns()) && !isAttributeColumn(parts[0]) {
+			return nil, fmt.Errorf("unknown column %q, expected %s<name> or one of %v", parts[0], AttributeFilterPrefix, matchColumns())
+		}
+		if isRipeningColumn(parts[0]) || isDateColumn(parts[0]) {
+			if _, _, err := rangeCondition(Filter{parts[0], parts[1]}); err != nil {
+				return nil, err
+			}
+		}
+		filters = append(filters, Filter{Column: parts[0], Value: parts[1]})
+	}
+
+	return filters, nil
+}
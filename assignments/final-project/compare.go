@@ -0,0 +1,218 @@
+// CST8333 Cheese Directory App - Cheese Comparison - Lucas Estienne
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"database/sql"
+	"flag"
+	"strconv"
+)
+
+// fewest and most cheeses compared at once
+const (
+	MinCompared = 2
+	MaxCompared = 5
+)
+
+// output formats of comparisons
+var compareFormats = []string { FormatTable, FormatMarkdown, FormatHTML }
+
+// marker of the fields that differ in table comparisons
+const differsMarker = "* "
+
+// simple data structure containing a comparison, one row per field and one column per cheese
+type Comparison struct {
+	Rows [][]string
+	Differs map[int]bool
+}
+
+// function to find the records of the cheeses to compare, in the order given
+func comparedRecords(rs []Record, cheeseIds []int) ([]Record, error) {
+	if len(cheeseIds) < MinCompared || len(cheeseIds) > MaxCompared {
+		return nil, fmt.Errorf("expected %d to %d CheeseIds, got %d", MinCompared, MaxCompared, len(cheeseIds))
+	}
+
+	var compared []Record
+	seen := map[int]bool{}
+	for _, id := range cheeseIds {
+		if seen[id] {
+			return nil, fmt.Errorf("CheeseId %d is given more than once", id)
+		}
+		seen[id] = true
+
+		r, err := findCheese(rs, strconv.Itoa(id))
+		if err != nil {
+			return nil, err
+		}
+		compared = append(compared, r)
+	}
+	return compared, nil
+}
+
+// helper function to add the difference of each value from the first one, e.g. "30.00 (+2.00)"
+func withDifferences(values []string, numbers []sql.NullFloat64) []string {
+	for i := 1; i < len(values); i++ {
+		if numbers[0].Valid && numbers[i].Valid {
+			values[i] += fmt.Sprintf(" (%+.2f)", numbers[i].Float64 - numbers[0].Float64)
+		}
+	}
+	return values
+}
+
+// function to compare records field by field, marking the fields whose values differ
+func compareRecords(rs []Record, attrs []CustomAttribute) Comparison {
+	c := Comparison{Differs: map[int]bool{}}
+
+	header := []string{"Field"}
+	for _, r := range rs {
+		header = append(header, displayString(r.CheeseName))
+	}
+	c.Rows = append(c.Rows, header)
+
+	addRow := func(name string, value func(r Record) string, numbers func(r Record) sql.NullFloat64) {
+		var values []string
+		var nums []sql.NullFloat64
+		for _, r := range rs {
+			values = append(values, value(r))
+			if numbers != nil {
+				nums = append(nums, numbers(r))
+			}
+		}
+
+		for _, v := range values[1:] {
+			if v != values[0] {
+				c.Differs[len(c.Rows)] = true
+			}
+		}
+		if numbers != nil {
+			values = withDifferences(values, nums)
+		}
+		c.Rows = append(c.Rows, append([]string{name}, values...))
+	}
+
+	for _, f := range fieldsWhere(func(f Field) bool { return f.Exportable && f.Name != "CheeseName" }) {
+		f := f
+		var numbers func(r Record) sql.NullFloat64
+		if f.Name == "FatContentPercent" || f.Name == "MoisturePercent" {
+			numbers = func(r Record) sql.NullFloat64 { return *f.Value(&r).(*sql.NullFloat64) }
+		}
+		addRow(f.Name, func(r Record) string { return displayField(r, f) }, numbers)
+	}
+	for i, h := range classificationHeaders {
+		i := i
+		addRow(h, func(r Record) string { return classificationToSlice(r)[i] }, nil)
+	}
+	addRow("Rating", func(r Record) string { return describeRating(r) }, nil)
+	for _, a := range attrs {
+		name := a.Name
+		addRow(name, func(r Record) string { return r.Attributes[name] }, nil)
+	}
+
+	return c
+}
+
+// function to write a comparison as an aligned table, a Markdown table or an HTML table,
+// differing fields are marked, bold or highlighted
+func writeComparison(w io.Writer, c Comparison, format string) error {
+	var rows [][]string
+	for i, row := range c.Rows {
+		row = append([]string{}, row...)
+		if c.Differs[i] {
+			switch format {
+				case FormatTable:
+					row[0] = differsMarker + row[0]
+				case FormatMarkdown:
+					for j, v := range row {
+						if v != "" {
+							row[j] = "**" + v + "**"
+						}
+					}
+			}
+		} else if i > 0 && format == FormatTable {
+			row[0] = "  " + row[0]
+		}
+		rows = append(rows, row)
+	}
+
+	switch format {
+		case FormatTable:
+			writeAlignedTable(w, rows)
+			fmt.Fprintf(w, "\n%sfields that differ, fat and moisture differences are from the first cheese\n", differsMarker)
+		case FormatMarkdown:
+			writeMarkdownTable(w, rows)
+		case FormatHTML:
+			fmt.Fprintln(w, "<style>table { border-collapse: collapse; } th, td { border: 1px solid #ccc; padding: 4px 8px; } tr.differs { background: #fff3c4; }</style>")
+			writeHTMLTable(w, rows, c.Differs)
+		default:
+			return fmt.Errorf("unknown format %q, expected one of %v", format, compareFormats)
+	}
+	return nil
+}
+
+// function to compare cheeses and write the comparison to a file, or to the screen when the path is empty
+func outputComparison(database *sql.DB, cheeseIds []int, format string, filePath string) error {
+	if !stringInSlice(format, compareFormats) {
+		return fmt.Errorf("unknown format %q, expected one of %v", format, compareFormats)
+	}
+	rs, err := comparedRecords(getAllCheeses(database), cheeseIds)
+	if err != nil {
+		return err
+	}
+
+	w, closeOutput, err := createOutput(filePath)
+	if err != nil {
+		return err
+	}
+	defer closeOutput()
+
+	// exported files start with their table
+	if filePath == "" {
+		fmt.Fprintln(w)
+	}
+	err = writeComparison(w, compareRecords(rs, loadCustomAttributes(database)), format)
+	if err == nil && filePath != "" {
+		fmt.Printf("\n Done writing to %s.\n", filePath)
+	}
+	return err
+}
+
+// function to compare cheeses from the menu
+func displayComparison(database *sql.DB) {
+	cheeseIds := readCheeseIds(fmt.Sprintf("%d to %d CheeseIds to compare", MinCompared, MaxCompared))
+
+	format := ""
+	for !stringInSlice(format, compareFormats) {
+		format = readNewOrKeepDefaultString(fmt.Sprintf("format %v", compareFormats), FormatTable)
+	}
+	filePath := ""
+	if format != FormatTable {
+		filePath = readString("file to write to (Enter for the screen)")
+	}
+
+	if err := outputComparison(database, cheeseIds, format, filePath); err != nil {
+		fmt.Printf("\nError: %v\n", err)
+	}
+}
+
+// function to run the "compare" command
+func compareCommand(database *sql.DB, user User, args []string) error {
+	flags := flag.NewFlagSet("compare", flag.ContinueOnError)
+	format := flags.String("format", FormatTable, fmt.Sprintf("output format %v", compareFormats))
+	filePath := flags.String("out", "", "output file, the screen when empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var cheeseIds []int
+	for _, arg := range flags.Args() {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			return fmt.Errorf("invalid CheeseId %q", arg)
+		}
+		cheeseIds = append(cheeseIds, id)
+	}
+
+	return outputComparison(database, cheeseIds, *format, *filePath)
+}
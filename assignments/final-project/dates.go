@@ -0,0 +1,211 @@
+// CST8333 Cheese Directory App - Update Dates - Lucas Estienne
+
+package main
+
+import (
+	"fmt"
+	"database/sql"
+	"database/sql/driver"
+	"flag"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// layout dates are stored and displayed with
+const DateLayout = "2006-01-02"
+
+// filter columns matching records on their last update date, e.g. updated_since=2016-01-01 or stale_months=24
+const (
+	UpdatedSince = "updated_since"
+	UpdatedBefore = "updated_before"
+	UpdatedBetween = "updated_between"
+	StaleMonths = "stale_months"
+)
+
+// layouts accepted when parsing dates, the first one is the stored layout
+var dateLayouts = []string{DateLayout, "2006/01/02", "2006-01-02 15:04:05", time.RFC3339}
+
+// simple data structure containing a calendar date, the zero value is an unknown date
+type Date struct {
+	time.Time
+}
+
+// helper function to get today's date
+func today() Date {
+	now := time.Now()
+	return Date{time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)}
+}
+
+// function to parse a date, blank and N/A values are an unknown date
+func parseDate(s string) (Date, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == MissingValue {
+		return Date{}, nil
+	}
+
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return Date{time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)}, nil
+		}
+	}
+	return Date{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", s)
+}
+
+// function to display a date, unknown dates are N/A like other missing values
+func (d Date) String() string {
+	if d.IsZero() {
+		return MissingValue
+	}
+	return d.Format(DateLayout)
+}
+
+// helper function to export a date, unknown dates are empty like other missing values
+func exportDate(d Date) string {
+	if d.IsZero() {
+		return ""
+	}
+	return d.Format(DateLayout)
+}
+
+// function to store a date, unknown dates are null
+func (d Date) Value() (driver.Value, error) {
+	if d.IsZero() {
+		return nil, nil
+	}
+	return d.Format(DateLayout), nil
+}
+
+// function to read a stored date
+func (d *Date) Scan(value interface{}) error {
+	var err error
+
+	switch v := value.(type) {
+		case nil:
+			*d = Date{}
+		case time.Time:
+			*d = Date{time.Date(v.Year(), v.Month(), v.Day(), 0, 0, 0, 0, time.UTC)}
+		case string:
+			*d, err = parseDate(v)
+		case []byte:
+			*d, err = parseDate(string(v))
+		default:
+			err = fmt.Errorf("cannot read a date from %T", value)
+	}
+	return err
+}
+
+// function to store the last update dates in the same layout, dates that cannot be parsed become unknown
+func migrateUpdateDates(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT DISTINCT last_update_date FROM cheeses WHERE last_update_date IS NOT NULL`)
+	if err != nil {
+		return err
+	}
+
+	var texts []string
+	for rows.Next() {
+		var text string
+		if err = rows.Scan(&text); err != nil {
+			rows.Close()
+			return err
+		}
+		texts = append(texts, text)
+	}
+	rows.Close()
+
+	for _, text := range texts {
+		d, _ := parseDate(text)
+		_, err = tx.Exec(`UPDATE cheeses SET last_update_date = ? WHERE last_update_date = ?`, d, text)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// helper function to check if a filter column matches on the last update date
+func isDateColumn(column string) bool {
+	return column == UpdatedSince || column == UpdatedBefore || column == UpdatedBetween || column == StaleMonths
+}
+
+// helper function to build the condition matching the last update date,
+// ranges are written "from..to" and records never dated count as stale
+func dateCondition(f Filter) (string, []interface{}, error) {
+	if f.Column == StaleMonths {
+		months, err := strconv.Atoi(strings.TrimSpace(f.Value))
+		if err != nil || months < 0 {
+			return "", nil, fmt.Errorf("invalid number of months %q", f.Value)
+		}
+		cutoff := Date{today().AddDate(0, -months, 0)}
+		return "(last_update_date IS NULL OR last_update_date < ?)", []interface{}{cutoff}, nil
+	}
+
+	if f.Column == UpdatedBetween {
+		parts := strings.SplitN(f.Value, "..", 2)
+		if len(parts) != 2 {
+			return "", nil, fmt.Errorf("invalid date range %q, expected YYYY-MM-DD..YYYY-MM-DD", f.Value)
+		}
+		from, err := parseDate(parts[0])
+		if err != nil {
+			return "", nil, err
+		}
+		to, err := parseDate(parts[1])
+		if err != nil {
+			return "", nil, err
+		}
+		if from.IsZero() || to.IsZero() || to.Before(from.Time) {
+			return "", nil, fmt.Errorf("invalid date range %q, expected YYYY-MM-DD..YYYY-MM-DD", f.Value)
+		}
+		return "last_update_date BETWEEN ? AND ?", []interface{}{from, to}, nil
+	}
+
+	d, err := parseDate(f.Value)
+	if err == nil && d.IsZero() {
+		err = fmt.Errorf("expected a date for %s", f.Column)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if f.Column == UpdatedSince {
+		return "last_update_date >= ?", []interface{}{d}, nil
+	}
+	return "last_update_date < ?", []interface{}{d}, nil
+}
+
+// helper function to get the most recently changed records first
+func recentChanges(database *sql.DB, filters []Filter, limit int) []Record {
+	rs := queryRecords(database, filters, "last_update_date DESC NULLS LAST, id DESC")
+	if limit > 0 && len(rs) > limit {
+		rs = rs[:limit]
+	}
+	return rs
+}
+
+// function to display the most recently changed records from the menu
+func displayRecentChanges(database *sql.DB) {
+	fmt.Printf("\nMost recently changed cheeses...\n\n")
+
+	limit, err := strconv.Atoi(readNewOrKeepDefaultString("number of cheeses", "20"))
+	if err != nil || limit < 1 {
+		limit = 20
+	}
+
+	printRecordTable(recentChanges(database, nil, limit))
+}
+
+// function to run the "recent" command
+func recentCommand(database *sql.DB, user User, args []string) error {
+	flags := flag.NewFlagSet("recent", flag.ContinueOnError)
+	limit := flags.Int("limit", 20, "number of cheeses to list, 0 for all")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	filters, err := parseFilterArgs(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	printRecordTable(recentChanges(database, filters, *limit))
+	return nil
+}
@@ -0,0 +1,244 @@
+// CST8333 Cheese Directory App - Dataset Release Diff - Lucas Estienne
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"sort"
+)
+
+// simple data structure containing a cheese added to or removed from the directory
+type DiffRecord struct {
+	CheeseId int `json:"cheese_id"`
+	CheeseName string `json:"cheese_name"`
+}
+
+// simple data structure containing the old and new values of a field of a changed cheese
+type FieldChange struct {
+	Field string `json:"field"`
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// simple data structure containing a changed cheese and its field changes, in registry order
+type DiffChange struct {
+	CheeseId int `json:"cheese_id"`
+	CheeseName string `json:"cheese_name"`
+	Changes []FieldChange `json:"changes"`
+}
+
+// simple data structure containing the differences between two versions of the directory, by CheeseId
+type DatasetDiff struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+	Added []DiffRecord `json:"added"`
+	Removed []DiffRecord `json:"removed"`
+	Changed []DiffChange `json:"changed"`
+	Unchanged int `json:"unchanged"`
+	// CheeseIds found more than once in a version, only their first record is compared
+	Duplicates []int `json:"duplicates"`
+}
+
+// label of the database side of a diff
+const diffDatabaseLabel = "database"
+
+// function to read every record of a data file, returning errors rather than exiting
+func loadRecordsFile(filePath string) ([]Record, error) {
+	lines, err := getLinesFromCSV(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("%s has no header row", filePath)
+	}
+
+	indexes := csvHeaderIndexes(lines[0])
+	if _, ok := indexes["CheeseId"]; !ok {
+		return nil, fmt.Errorf("%s has no CheeseId column", filePath)
+	}
+
+	var records []Record
+	for _, line := range lines[1:] {
+		records = append(records, lineToRecord(line, indexes))
+	}
+	return records, nil
+}
+
+// helper function to index records by CheeseId, keeping the first record of each and listing the duplicates
+func recordsByCheeseId(rs []Record) (map[int]Record, []int, []int) {
+	byId := map[int]Record{}
+	var ids, duplicates []int
+	for _, r := range rs {
+		if _, ok := byId[r.CheeseId]; ok {
+			duplicates = append(duplicates, r.CheeseId)
+			continue
+		}
+		byId[r.CheeseId] = r
+		ids = append(ids, r.CheeseId)
+	}
+	return byId, ids, duplicates
+}
+
+// function to compare two exported records field by field
+func compareFields(before Record, after Record) []FieldChange {
+	var changes []FieldChange
+	for _, f := range fieldsWhere(func(f Field) bool { return f.Exportable }) {
+		if o, n := exportField(before, f), exportField(after, f); o != n {
+			changes = append(changes, FieldChange{f.Name, o, n})
+		}
+	}
+	return changes
+}
+
+// function to compare two versions of the directory by CheeseId
+func diffRecords(oldLabel string, oldRecords []Record, newLabel string, newRecords []Record) DatasetDiff {
+	d := DatasetDiff{Old: oldLabel, New: newLabel, Added: []DiffRecord{}, Removed: []DiffRecord{}, Changed: []DiffChange{}, Duplicates: []int{}}
+
+	oldById, oldIds, oldDuplicates := recordsByCheeseId(oldRecords)
+	newById, newIds, newDuplicates := recordsByCheeseId(newRecords)
+	d.Duplicates = append(d.Duplicates, oldDuplicates...)
+	d.Duplicates = append(d.Duplicates, newDuplicates...)
+
+	sort.Ints(oldIds)
+	sort.Ints(newIds)
+	for _, id := range oldIds {
+		before := oldById[id]
+		after, ok := newById[id]
+		if !ok {
+			d.Removed = append(d.Removed, DiffRecord{id, before.CheeseName.String})
+			continue
+		}
+		if changes := compareFields(before, after); len(changes) > 0 {
+			d.Changed = append(d.Changed, DiffChange{id, after.CheeseName.String, changes})
+		} else {
+			d.Unchanged++
+		}
+	}
+	for _, id := range newIds {
+		if _, ok := oldById[id]; !ok {
+			d.Added = append(d.Added, DiffRecord{id, newById[id].CheeseName.String})
+		}
+	}
+
+	return d
+}
+
+// function to compare two data files, or a data file against the database when newPath is empty,
+// the data file is then the new version with its values canonicalized the way an import would store them
+func diffDataset(database *sql.DB, oldPath string, newPath string) (DatasetDiff, error) {
+	oldRecords, err := loadRecordsFile(oldPath)
+	if err != nil {
+		return DatasetDiff{}, err
+	}
+
+	if newPath != "" {
+		newRecords, err := loadRecordsFile(newPath)
+		if err != nil {
+			return DatasetDiff{}, err
+		}
+		return diffRecords(oldPath, oldRecords, newPath, newRecords), nil
+	}
+
+	fileRecords, _ := canonicalizeRecords(loadVocabulary(database), oldRecords)
+	return diffRecords(diffDatabaseLabel, getAllCheeses(database), oldPath, fileRecords), nil
+}
+
+// function to write a diff for people to read
+func writeDiff(w io.Writer, d DatasetDiff) {
+	fmt.Fprintf(w, "\nChanges from %s to %s\n", d.Old, d.New)
+
+	fmt.Fprintf(w, "\n Added: %d\n", len(d.Added))
+	for _, r := range d.Added {
+		fmt.Fprintf(w, "   + %-6d %s\n", r.CheeseId, r.CheeseName)
+	}
+
+	fmt.Fprintf(w, "\n Removed: %d\n", len(d.Removed))
+	for _, r := range d.Removed {
+		fmt.Fprintf(w, "   - %-6d %s\n", r.CheeseId, r.CheeseName)
+	}
+
+	fmt.Fprintf(w, "\n Changed: %d\n", len(d.Changed))
+	for _, c := range d.Changed {
+		fmt.Fprintf(w, "   ~ %-6d %s\n", c.CheeseId, c.CheeseName)
+		for _, f := range c.Changes {
+			fmt.Fprintf(w, "       %s: %q -> %q\n", f.Field, f.Old, f.New)
+		}
+	}
+
+	if len(d.Duplicates) > 0 {
+		fmt.Fprintf(w, "\n Duplicate CheeseIds, only their first record was compared: %v\n", d.Duplicates)
+	}
+	fmt.Fprintf(w, "\n%d added, %d removed, %d changed, %d unchanged\n", len(d.Added), len(d.Removed), len(d.Changed), d.Unchanged)
+}
+
+// function to write a diff as JSON for other programs
+func writeDiffJSON(w io.Writer, d DatasetDiff) error {
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+// function to compare two versions of the directory and write the diff to a file, or to the screen when the path is empty
+func outputDiff(database *sql.DB, oldPath string, newPath string, asJSON bool, filePath string) error {
+	d, err := diffDataset(database, oldPath, newPath)
+	if err != nil {
+		return err
+	}
+
+	w, closeOutput, err := createOutput(filePath)
+	if err != nil {
+		return err
+	}
+	defer closeOutput()
+
+	if asJSON {
+		err = writeDiffJSON(w, d)
+	} else {
+		writeDiff(w, d)
+	}
+	if err == nil && filePath != "" {
+		fmt.Printf("\n Done writing to %s.\n", filePath)
+	}
+	return err
+}
+
+// function to compare data files from the menu
+func displayDiff(database *sql.DB) {
+	oldPath := readRequiredString("data file")
+	newPath := readString("newer data file (Enter to compare the file against the database)")
+	readEncoding()
+
+	if err := outputDiff(database, oldPath, newPath, false, ""); err != nil {
+		fmt.Printf("\nError: %v\n", err)
+	}
+}
+
+// function to run the "diff" command
+func diffCommand(database *sql.DB, user User, args []string) error {
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "write the diff as JSON")
+	filePath := flags.String("out", "", "output file, the screen when empty")
+	encoding := encodingFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := setDataFileEncoding(*encoding); err != nil {
+		return err
+	}
+
+	switch flags.NArg() {
+		case 1:
+			return outputDiff(database, flags.Arg(0), "", *asJSON, *filePath)
+		case 2:
+			return outputDiff(database, flags.Arg(0), flags.Arg(1), *asJSON, *filePath)
+	}
+	return errors.New("expected an old and a new data file, or one data file to compare against the database")
+}
@@ -0,0 +1,639 @@
+// CST8333 Cheese Directory App - Duplicate Detection and Record Merging - Lucas Estienne
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"database/sql"
+	"errors"
+	"flag"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lowest score of the candidate pairs listed by default, and lowest name and manufacturer similarity of a pair
+const (
+	DefaultDuplicateScore = 0.85
+	minDuplicateNameSimilarity = 0.85
+	minDuplicateManufacturerSimilarity = 0.5
+)
+
+// lowest spelling similarity of two words taken for the same word, e.g. "charlevoix" and "charlevoi"
+const minWordSimilarity = 0.75
+
+// weights of the name, the manufacturer and the matching attributes in the duplicate score
+var duplicateWeights = struct {
+	Name, Manufacturer, Attributes float64
+}{0.6, 0.2, 0.2}
+
+// articles left out when comparing cheese names, e.g. "Le Brie" and "Brie"
+var nameArticles = map[string]bool {
+	"le": true, "la": true, "les": true, "l": true, "the": true, "de": true, "du": true, "des": true, "d": true,
+}
+
+// words common to many manufacturer names, left out when comparing manufacturers
+var manufacturerWords = map[string]bool {
+	"fromagerie": true, "fromageries": true, "fromages": true, "fromage": true, "laiterie": true, "ferme": true, "inc": true,
+	"cheese": true, "company": true, "co": true, "ltd": true, "dairy": true, "farm": true, "farms": true, "cooperative": true,
+}
+
+// parenthesized parts of cheese names, e.g. "Cheddar fort (Fromagerie Lemaire)" or "Migneron (Le)"
+var nameParentheses = regexp.MustCompile(`\([^)]*\)`)
+
+// simple data structure containing two records that may be the same cheese, by record #, with their score
+type DuplicatePair struct {
+	A int
+	B int
+	Score float64
+	Reasons []string
+}
+
+// simple data structure containing a merge of two records in the merge history
+type RecordMerge struct {
+	Id int
+	KeptCheeseId int
+	KeptName string
+	DroppedCheeseId int
+	DroppedName string
+	Fields string
+	Username string
+	CreatedAt string
+}
+
+// function to create the merge history table
+func migrateRecordMerges(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE record_merges (
+			id INTEGER PRIMARY KEY,
+			kept_cheese_id INTEGER NOT NULL,
+			kept_name TEXT,
+			dropped_cheese_id INTEGER NOT NULL,
+			dropped_name TEXT,
+			fields TEXT NOT NULL,
+			username TEXT NOT NULL,
+			created_at TEXT NOT NULL
+		)`,
+	)
+}
+
+// helper function to normalize the name of a record for comparisons, leaving out articles and the manufacturer
+// name in parentheses, brand names in parentheses are kept
+func normalizeCheeseName(r Record) string {
+	manufacturer := normalizeName(r.ManufacturerName.String)
+	name := nameParentheses.ReplaceAllStringFunc(r.CheeseName.String, func(part string) string {
+		if inner := normalizeName(part); manufacturer != "" && (strings.Contains(manufacturer, inner) || textSimilarity(inner, manufacturer) >= minDuplicateNameSimilarity) {
+			return " "
+		}
+		return part
+	})
+
+	var words []string
+	for _, w := range strings.Fields(normalizeName(name)) {
+		if !nameArticles[w] {
+			words = append(words, w)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// helper function to normalize a manufacturer name for comparisons, leaving out articles and common words
+func normalizeManufacturerName(name string) string {
+	var words []string
+	for _, w := range strings.Fields(normalizeName(name)) {
+		if !nameArticles[w] && !manufacturerWords[w] {
+			words = append(words, w)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// helper function to get the edit distance between two texts, in letters
+func levenshtein(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb) + 1)
+	current := make([]int, len(rb) + 1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i - 1] == rb[j - 1] {
+				cost = 0
+			}
+			current[j] = minInt(minInt(previous[j] + 1, current[j - 1] + 1), previous[j - 1] + cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
+
+// helper function to get the smaller of two numbers
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// helper function to get the similarity of two normalized texts from 0 to 1, the best of their spelling
+// and of their shared words, so that reordered words still match
+func textSimilarity(a string, b string) float64 {
+	if a == "" || b == "" {
+		return 0
+	}
+	if a == b {
+		return 1
+	}
+
+	longest := math.Max(float64(len([]rune(a))), float64(len([]rune(b))))
+	spelling := 1 - float64(levenshtein(a, b)) / longest
+
+	wordsA, wordsB := strings.Fields(a), strings.Fields(b)
+	shared := 0
+	for _, w := range wordsA {
+		if stringInSlice(w, wordsB) {
+			shared++
+		}
+	}
+	words := float64(shared) / float64(len(wordsA) + len(wordsB) - shared)
+
+	return math.Max(spelling, words)
+}
+
+// helper function to get the similarity of two names from 0 to 1 word by word, each word counting as its closest
+// spelling in the other name, so that a misspelled word still matches but a different word, e.g. "doux" and "fort", does not
+func wordSimilarity(a string, b string) float64 {
+	wordsA, wordsB := strings.Fields(a), strings.Fields(b)
+	if len(wordsA) == 0 || len(wordsB) == 0 {
+		return 0
+	}
+
+	total := 0.0
+	used := make([]bool, len(wordsB))
+	for _, wa := range wordsA {
+		best, bestIndex := 0.0, -1
+		for j, wb := range wordsB {
+			longest := math.Max(float64(len([]rune(wa))), float64(len([]rune(wb))))
+			if sim := 1 - float64(levenshtein(wa, wb)) / longest; !used[j] && sim > best {
+				best, bestIndex = sim, j
+			}
+		}
+		if best >= minWordSimilarity {
+			used[bestIndex] = true
+			total += best
+		}
+	}
+	return 2 * total / float64(len(wordsA) + len(wordsB))
+}
+
+// function to score how likely two records are the same cheese, explaining what matches
+func scoreDuplicate(a Record, b Record) (float64, []string) {
+	var reasons []string
+	w := duplicateWeights
+
+	name := wordSimilarity(normalizeCheeseName(a), normalizeCheeseName(b))
+	if name < minDuplicateNameSimilarity {
+		return 0, nil
+	}
+	if name == 1 {
+		reasons = append(reasons, "same name")
+	} else {
+		reasons = append(reasons, fmt.Sprintf("names %.0f%% alike", name * 100))
+	}
+
+	// the manufacturer only counts when both are known, local additions often have none
+	knownManufacturers := a.ManufacturerName.Valid && b.ManufacturerName.Valid
+	manufacturer := textSimilarity(normalizeManufacturerName(a.ManufacturerName.String), normalizeManufacturerName(b.ManufacturerName.String))
+	switch {
+		case !knownManufacturers:
+			w.Manufacturer = 0
+		case manufacturer <= minDuplicateManufacturerSimilarity:
+			// the same generic name from two different makers, e.g. two Goudas
+			return 0, nil
+		case manufacturer == 1:
+			reasons = append(reasons, "same manufacturer")
+		default:
+			reasons = append(reasons, fmt.Sprintf("manufacturers %.0f%% alike", manufacturer * 100))
+	}
+
+	// attributes known on both sides count, matching ones are listed
+	var matched []string
+	compared := 0
+	compare := func(label string, known bool, same bool) {
+		if known {
+			compared++
+			if same {
+				matched = append(matched, label)
+			}
+		}
+	}
+	compare("province", a.ManufacturerProvCode.Valid && b.ManufacturerProvCode.Valid, sameValue(a.ManufacturerProvCode, b.ManufacturerProvCode))
+	compare("milk", a.MilkType.Valid && b.MilkType.Valid, boardMilk(a) == boardMilk(b))
+	compare("treatment", a.MilkTreatmentType.Valid && b.MilkTreatmentType.Valid, sameValue(a.MilkTreatmentType, b.MilkTreatmentType))
+	compare("category", a.CategoryType.Valid && b.CategoryType.Valid, sameValue(a.CategoryType, b.CategoryType))
+	compare("rind", a.RindType.Valid && b.RindType.Valid, sameValue(a.RindType, b.RindType))
+	compare("fat", a.FatContentPercent.Valid && b.FatContentPercent.Valid,
+		math.Abs(a.FatContentPercent.Float64 - b.FatContentPercent.Float64) <= 1)
+	compare("moisture", a.MoisturePercent.Valid && b.MoisturePercent.Valid,
+		math.Abs(a.MoisturePercent.Float64 - b.MoisturePercent.Float64) <= 1)
+
+	attributes := 0.0
+	if compared > 0 {
+		attributes = float64(len(matched)) / float64(compared)
+	}
+	if len(matched) > 0 {
+		reasons = append(reasons, "same " + strings.Join(matched, ", "))
+	}
+
+	score := w.Name * name + w.Manufacturer * manufacturer + w.Attributes * attributes
+	return score / (w.Name + w.Manufacturer + w.Attributes), reasons
+}
+
+// function to find pairs of records that may be the same cheese, best score first, records are compared
+// only with records sharing a word of their name
+func findDuplicates(rs []Record, minScore float64) []DuplicatePair {
+	byWord := map[string][]int{}
+	for i, r := range rs {
+		seen := map[string]bool{}
+		for _, w := range strings.Fields(normalizeCheeseName(r)) {
+			if !seen[w] {
+				seen[w] = true
+				byWord[w] = append(byWord[w], i)
+			}
+		}
+	}
+
+	var pairs []DuplicatePair
+	compared := map[[2]int]bool{}
+	for _, indexes := range byWord {
+		for x, i := range indexes {
+			for _, j := range indexes[x + 1:] {
+				if compared[[2]int{i, j}] {
+					continue
+				}
+				compared[[2]int{i, j}] = true
+
+				score, reasons := scoreDuplicate(rs[i], rs[j])
+				if rs[i].CheeseId == rs[j].CheeseId {
+					score, reasons = math.Max(score, minScore), append(reasons, "same CheeseId")
+				}
+				if score >= minScore {
+					pairs = append(pairs, DuplicatePair{i, j, score, reasons})
+				}
+			}
+		}
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].Score != pairs[j].Score {
+			return pairs[i].Score > pairs[j].Score
+		}
+		if pairs[i].A != pairs[j].A {
+			return pairs[i].A < pairs[j].A
+		}
+		return pairs[i].B < pairs[j].B
+	})
+	return pairs
+}
+
+// function to print candidate duplicate pairs as a table, records are given by their #
+func printDuplicates(rs []Record, pairs []DuplicatePair) {
+	rows := [][]string{{"Pair", "#", "CheeseId", "CheeseName", "ManufacturerName", "Score", "Why"}}
+	for n, p := range pairs {
+		a, b := rs[p.A], rs[p.B]
+		rows = append(rows,
+			[]string{strconv.Itoa(n + 1), strconv.Itoa(p.A), strconv.Itoa(a.CheeseId), displayString(a.CheeseName),
+				displayString(a.ManufacturerName), fmt.Sprintf("%.0f%%", p.Score * 100), strings.Join(p.Reasons, "; ")},
+			[]string{"", strconv.Itoa(p.B), strconv.Itoa(b.CheeseId), displayString(b.CheeseName), displayString(b.ManufacturerName), "", ""},
+		)
+	}
+
+	writeAlignedTable(os.Stdout, rows)
+	fmt.Printf("\n%d candidate pairs\n", len(pairs))
+}
+
+// helper function to list the fields a merge may take from either record, those whose values differ
+func mergeableFields(a Record, b Record) []Field {
+	return fieldsWhere(func(f Field) bool {
+		return f.Exportable && f.Name != "LastUpdateDate" && exportField(a, f) != exportField(b, f)
+	})
+}
+
+// function to merge the dropped record into the kept one, taking the named fields from the dropped record
+// and the custom attributes the kept record lacks, the dropped record is removed
+func mergeDuplicateRecords(rs []Record, keep int, drop int, fromDrop []string) ([]Record, error) {
+	if keep == drop {
+		return nil, errors.New("cannot merge a record with itself")
+	}
+	if keep < 0 || keep >= len(rs) || drop < 0 || drop >= len(rs) {
+		return nil, fmt.Errorf("invalid record #, expected 0 to %d", len(rs) - 1)
+	}
+
+	fields, err := mergeFieldNames(fromDrop)
+	if err != nil {
+		return nil, err
+	}
+	kept, dropped := rs[keep], rs[drop]
+	for _, name := range fields {
+		f, _ := fieldByName(name)
+		parseField(&kept, f, exportField(dropped, f))
+	}
+
+	attributes := map[string]string{}
+	for name, value := range dropped.Attributes {
+		attributes[name] = value
+	}
+	for name, value := range kept.Attributes {
+		attributes[name] = value
+	}
+	if len(attributes) > 0 {
+		kept.Attributes = attributes
+	}
+	kept.LastUpdateDate = today()
+
+	merged := append([]Record{}, rs...)
+	merged[keep] = kept
+	return deleteRecordFromSlice(merged, drop), nil
+}
+
+// helper function to get the registry names of the fields taken from a dropped record, e.g. "Flavour" for "flavour"
+func mergeFieldNames(names []string) ([]string, error) {
+	var fields []string
+	for _, name := range names {
+		f, ok := fieldByName(name)
+		if !ok || !f.Exportable || f.Name == "LastUpdateDate" {
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+		fields = append(fields, f.Name)
+	}
+	return fields, nil
+}
+
+// helper function to find a field by its name
+func fieldByName(name string) (Field, bool) {
+	for _, f := range recordFields {
+		if strings.EqualFold(f.Name, name) {
+			return f, true
+		}
+	}
+	return Field{}, false
+}
+
+// function to move the reviews, collections and stock of a dropped CheeseId to the kept one and record the merge in the
+// merge history, stock lots numbered alike for both cheeses are refused rather than mixed
+func recordMerge(database *sql.DB, username string, kept Record, dropped Record, fromDrop []string, remaining []Record) error {
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// the dropped CheeseId keeps its data while another record still has it
+	stillUsed := false
+	for _, r := range remaining {
+		stillUsed = stillUsed || r.CheeseId == dropped.CheeseId
+	}
+	if kept.CheeseId != dropped.CheeseId && !stillUsed {
+		var lot string
+		err = tx.QueryRow(`
+			SELECT lot_number FROM inventory_lots WHERE cheese_id = ?
+			AND lot_number IN (SELECT lot_number FROM inventory_lots WHERE cheese_id = ?) LIMIT 1
+		`, dropped.CheeseId, kept.CheeseId).Scan(&lot)
+		if err == nil {
+			return fmt.Errorf("lot %s is in stock for both CheeseIds, renumber it before merging", lot)
+		}
+		if err != sql.ErrNoRows {
+			return err
+		}
+
+		for _, statement := range []string{
+			`UPDATE reviews SET cheese_id = ? WHERE cheese_id = ?`,
+			`UPDATE OR IGNORE collection_cheeses SET cheese_id = ? WHERE cheese_id = ?`,
+			`UPDATE inventory_lots SET cheese_id = ? WHERE cheese_id = ?`,
+			`UPDATE OR IGNORE stock_thresholds SET cheese_id = ? WHERE cheese_id = ?`,
+		} {
+			if _, err = tx.Exec(statement, kept.CheeseId, dropped.CheeseId); err != nil {
+				return err
+			}
+		}
+		// entries already there for the kept CheeseId win
+		for _, statement := range []string{
+			`DELETE FROM collection_cheeses WHERE cheese_id = ?`,
+			`DELETE FROM stock_thresholds WHERE cheese_id = ?`,
+		} {
+			if _, err = tx.Exec(statement, dropped.CheeseId); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO record_merges (kept_cheese_id, kept_name, dropped_cheese_id, dropped_name, fields, username, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, kept.CheeseId, kept.CheeseName, dropped.CheeseId, dropped.CheeseName, strings.Join(fromDrop, ","), username,
+		time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// function to merge two records of the database, keeping one and taking the named fields from the other
+func mergeDuplicates(database *sql.DB, user User, records []Record, keep int, drop int, fromDrop []string) ([]Record, error) {
+	merged, err := mergeDuplicateRecords(records, keep, drop, fromDrop)
+	if err != nil {
+		return records, err
+	}
+	// the merge history names fields like the registry
+	fromDrop, _ = mergeFieldNames(fromDrop)
+	if err = recordMerge(database, user.Username, records[keep], records[drop], fromDrop, merged); err != nil {
+		return records, err
+	}
+
+	syncDb(merged, database)
+	writeAuditLog(database, user.Username, "merge duplicates", AuditAllowed,
+		fmt.Sprintf("kept #%d CheeseId %d, dropped #%d CheeseId %d, fields from dropped: %s",
+			keep, records[keep].CheeseId, drop, records[drop].CheeseId, strings.Join(fromDrop, ",")))
+	return merged, nil
+}
+
+// function to select the merge history, newest first
+func getRecordMerges(database *sql.DB, limit int) []RecordMerge {
+	var merges []RecordMerge
+
+	rows, err := database.Query(`
+		SELECT id, kept_cheese_id, COALESCE(kept_name, ''), dropped_cheese_id, COALESCE(dropped_name, ''), fields, username, created_at
+		FROM record_merges ORDER BY id DESC LIMIT ?
+	`, limit)
+	check(err)
+	defer rows.Close()
+
+	for rows.Next() {
+		var m RecordMerge
+		err = rows.Scan(&m.Id, &m.KeptCheeseId, &m.KeptName, &m.DroppedCheeseId, &m.DroppedName, &m.Fields, &m.Username, &m.CreatedAt)
+		if err != nil {
+			log.Fatal(err)
+		}
+		merges = append(merges, m)
+	}
+
+	return merges
+}
+
+// function to print the merge history as a table
+func printRecordMerges(merges []RecordMerge) {
+	rows := [][]string{{"Merge", "Kept", "Dropped", "Fields from dropped", "By", "Date"}}
+	for _, m := range merges {
+		rows = append(rows, []string{
+			strconv.Itoa(m.Id), fmt.Sprintf("%s (%d)", m.KeptName, m.KeptCheeseId), fmt.Sprintf("%s (%d)", m.DroppedName, m.DroppedCheeseId),
+			m.Fields, m.Username, strings.SplitN(m.CreatedAt, "T", 2)[0],
+		})
+	}
+
+	writeAlignedTable(os.Stdout, rows)
+}
+
+// function to merge a candidate pair from the menu, showing both records side by side and asking which value to keep
+// for each field that differs
+func mergePairFromMenu(database *sql.DB, user User, records []Record, pairs []DuplicatePair) ([]Record, error) {
+	n, err := strconv.Atoi(readRequiredString("pair to merge"))
+	if err != nil || n < 1 || n > len(pairs) {
+		return records, fmt.Errorf("invalid pair, expected 1 to %d", len(pairs))
+	}
+	p := pairs[n - 1]
+
+	fmt.Println()
+	writeComparison(os.Stdout, compareRecords([]Record{records[p.A], records[p.B]}, nil), FormatTable)
+
+	keep, drop := p.A, p.B
+	if readNewOrKeepDefaultString(fmt.Sprintf("record # to keep (%d or %d)", p.A, p.B), strconv.Itoa(p.A)) == strconv.Itoa(p.B) {
+		keep, drop = p.B, p.A
+	}
+
+	var fromDrop []string
+	for _, f := range mergeableFields(records[keep], records[drop]) {
+		fmt.Printf("\n %s: (k)ept %q, (d)ropped %q\n", f.Name, exportField(records[keep], f), exportField(records[drop], f))
+		if strings.HasPrefix(strings.ToLower(readNewOrKeepDefaultString("value to keep", "k")), "d") {
+			fromDrop = append(fromDrop, f.Name)
+		}
+	}
+
+	return mergeDuplicates(database, user, records, keep, drop, fromDrop)
+}
+
+// function to find and merge duplicate records from the menu, returning the records after any merge
+func manageDuplicates(database *sql.DB, user User, records []Record) []Record {
+	selection := 0
+
+	fmt.Printf("\nDuplicate records...\n\n")
+	fmt.Println(" 1. List candidate duplicates")
+	fmt.Println(" 2. Merge history")
+	if hasRole(user, RoleEditor) {
+		fmt.Println(" 3. Merge a candidate pair")
+	}
+
+	// loop until selection is valid
+	for selection == 0 {
+		fmt.Printf("Please choose an option: ")
+
+		_, err := fmt.Scanf("%d", &selection)
+
+		if err != nil || selection < 1 || selection > 3 {
+			selection = 0
+			fmt.Println("\nPlease enter a valid option.")
+		} else if selection > 2 && !hasRole(user, RoleEditor) {
+			writeAuditLog(database, user.Username, "duplicates", AuditDenied, "role "+user.Role)
+			fmt.Printf("\nYour role (%s) does not allow this option.\n", user.Role)
+			return records
+		}
+	}
+
+	var err error
+
+	switch selection {
+		case 1, 3:
+			rs := getAllCheeses(database)
+			pairs := findDuplicates(rs, DefaultDuplicateScore)
+			printDuplicates(rs, pairs)
+			if selection == 3 && len(pairs) > 0 {
+				records, err = mergePairFromMenu(database, user, rs, pairs)
+			}
+		case 2:
+			printRecordMerges(getRecordMerges(database, 50))
+	}
+
+	if err != nil {
+		fmt.Printf("\nError: %v\n", err)
+	}
+	return records
+}
+
+// function to run the "duplicates" command
+func duplicatesCommand(database *sql.DB, user User, args []string) error {
+	flags := flag.NewFlagSet("duplicates", flag.ContinueOnError)
+	minScore := flags.Float64("min", DefaultDuplicateScore, "lowest score of the pairs listed, from 0 to 1")
+	limit := flags.Int("limit", 50, "number of pairs to list")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	rs := getAllCheeses(database)
+	pairs := findDuplicates(rs, *minScore)
+	if *limit > 0 && len(pairs) > *limit {
+		pairs = pairs[:*limit]
+	}
+	printDuplicates(rs, pairs)
+	return nil
+}
+
+// function to run the "duplicate-merge" command
+func duplicateMergeCommand(database *sql.DB, user User, args []string) error {
+	flags := flag.NewFlagSet("duplicate-merge", flag.ContinueOnError)
+	take := flags.String("take", "", "comma separated fields to take from the dropped record")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return errors.New("expected the # of the record to keep and of the record to drop")
+	}
+
+	keep, err := strconv.Atoi(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("invalid record # %q", flags.Arg(0))
+	}
+	drop, err := strconv.Atoi(flags.Arg(1))
+	if err != nil {
+		return fmt.Errorf("invalid record # %q", flags.Arg(1))
+	}
+
+	var fromDrop []string
+	for _, name := range strings.Split(*take, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			fromDrop = append(fromDrop, name)
+		}
+	}
+
+	_, err = mergeDuplicates(database, user, getAllCheeses(database), keep, drop, fromDrop)
+	return err
+}
+
+// function to run the "merge-history" command
+func mergeHistoryCommand(database *sql.DB, user User, args []string) error {
+	flags := flag.NewFlagSet("merge-history", flag.ContinueOnError)
+	limit := flags.Int("limit", 50, "number of merges to list")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	printRecordMerges(getRecordMerges(database, *limit))
+	return nil
+}
@@ -0,0 +1,195 @@
+// CST8333 Cheese Directory App - Data File Encodings - Lucas Estienne
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"bytes"
+	"flag"
+	"strings"
+	"unicode/utf8"
+)
+
+// encodings of data files, auto detects the encoding from the bytes of the file
+const (
+	EncodingAuto = "auto"
+	EncodingUTF8 = "utf-8"
+	EncodingUTF8BOM = "utf-8-bom"
+	EncodingWindows1252 = "windows-1252"
+	EncodingISO88591 = "iso-8859-1"
+)
+
+var encodingChoices = []string { EncodingAuto, EncodingUTF8, EncodingUTF8BOM, EncodingWindows1252, EncodingISO88591 }
+
+// other names of the encodings, e.g. from spreadsheet export dialogs
+var encodingAliases = map[string]string {
+	"utf8": EncodingUTF8,
+	"utf-8-sig": EncodingUTF8BOM,
+	"utf8-bom": EncodingUTF8BOM,
+	"cp1252": EncodingWindows1252,
+	"win1252": EncodingWindows1252,
+	"latin1": EncodingISO88591,
+	"latin-1": EncodingISO88591,
+	"iso8859-1": EncodingISO88591,
+}
+
+// environment variable choosing the encoding of data files, e.g. CHEESEDIR_ENCODING=windows-1252
+const EncodingEnvVar = "CHEESEDIR_ENCODING"
+
+// encoding of the data files read, chosen by the -encoding flags, the menu or the environment
+var dataFileEncoding = EncodingAuto
+
+// data files already warned about, they are read more than once on import
+var warnedDataFiles = map[string]bool{}
+
+// characters of the Windows-1252 bytes 0x80 to 0x9F, the other bytes are the same as in ISO-8859-1,
+// 0 marks the five bytes Windows-1252 leaves undefined
+var windows1252Runes = [32]rune {
+	0x20AC, 0, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021, 0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, 0, 0x017D, 0,
+	0, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014, 0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, 0, 0x017E, 0x0178,
+}
+
+// simple data structure describing how a data file was decoded
+type Decoding struct {
+	Encoding string
+	// whether the encoding was detected rather than chosen
+	Detected bool
+	// invalid byte sequences replaced by U+FFFD, and the line of the first one
+	Invalid int
+	FirstInvalidLine int
+}
+
+// helper function to get the encoding of a name, ignoring case and accepting aliases
+func parseEncoding(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if alias, ok := encodingAliases[name]; ok {
+		name = alias
+	}
+	if !stringInSlice(name, encodingChoices) {
+		return "", fmt.Errorf("unknown encoding %q, expected one of %v", name, encodingChoices)
+	}
+	return name, nil
+}
+
+// function to choose the encoding of the data files read next
+func setDataFileEncoding(name string) error {
+	encoding, err := parseEncoding(name)
+	if err == nil {
+		dataFileEncoding = encoding
+	}
+	return err
+}
+
+// helper function to add the -encoding flag of the commands reading data files
+func encodingFlag(flags *flag.FlagSet) *string {
+	return flags.String("encoding", dataFileEncoding, fmt.Sprintf("encoding of the data files %v", encodingChoices))
+}
+
+// helper function to read the encoding of the data files from stdin, asking again until it is known
+func readEncoding() {
+	for {
+		if setDataFileEncoding(readNewOrKeepDefaultString(fmt.Sprintf("encoding %v", encodingChoices), dataFileEncoding)) == nil {
+			return
+		}
+	}
+}
+
+// function to detect the encoding of a data file, a byte order mark or else mostly valid UTF-8, a few invalid
+// bytes in UTF-8 text are reported rather than mangling its accents, a file without UTF-8 accents or with more
+// invalid byte sequences than valid ones is taken for Windows-1252 which older releases and spreadsheet exports use
+func detectEncoding(data []byte) string {
+	if bytes.HasPrefix(data, []byte(byteOrderMark)) {
+		return EncodingUTF8BOM
+	}
+
+	valid, invalid := 0, 0
+	for rest := data; len(rest) > 0; {
+		r, size := utf8.DecodeRune(rest)
+		switch {
+			case r == utf8.RuneError && size == 1:
+				invalid++
+			case size > 1:
+				valid++
+		}
+		rest = rest[size:]
+	}
+	if invalid > 0 && (valid == 0 || invalid > valid) {
+		return EncodingWindows1252
+	}
+	return EncodingUTF8
+}
+
+// function to transcode the bytes of a data file to UTF-8, dropping a byte order mark and
+// replacing invalid byte sequences by U+FFFD
+func decodeText(data []byte, encoding string) (string, Decoding, error) {
+	encoding, err := parseEncoding(encoding)
+	if err != nil {
+		return "", Decoding{}, err
+	}
+	d := Decoding{Encoding: encoding}
+	if encoding == EncodingAuto {
+		encoding = detectEncoding(data)
+		d = Decoding{Encoding: encoding, Detected: true}
+	}
+
+	var b strings.Builder
+	b.Grow(len(data))
+	line := 1
+	invalid := func() {
+		if d.Invalid == 0 {
+			d.FirstInvalidLine = line
+		}
+		d.Invalid++
+		b.WriteRune(utf8.RuneError)
+	}
+
+	switch encoding {
+		case EncodingUTF8, EncodingUTF8BOM:
+			data = bytes.TrimPrefix(data, []byte(byteOrderMark))
+			for len(data) > 0 {
+				r, size := utf8.DecodeRune(data)
+				if r == utf8.RuneError && size == 1 {
+					invalid()
+				} else {
+					b.Write(data[:size])
+				}
+				if r == '\n' {
+					line++
+				}
+				data = data[size:]
+			}
+		case EncodingWindows1252, EncodingISO88591:
+			for _, c := range data {
+				switch {
+					case encoding == EncodingWindows1252 && c >= 0x80 && c <= 0x9F && windows1252Runes[c - 0x80] == 0:
+						invalid()
+					case encoding == EncodingWindows1252 && c >= 0x80 && c <= 0x9F:
+						b.WriteRune(windows1252Runes[c - 0x80])
+					default:
+						// the first 256 characters of Unicode are ISO-8859-1
+						b.WriteRune(rune(c))
+				}
+				if c == '\n' {
+					line++
+				}
+			}
+	}
+
+	return b.String(), d, nil
+}
+
+// function to warn once about each data file with invalid byte sequences for its encoding
+func warnInvalidEncoding(filePath string, d Decoding) {
+	if d.Invalid == 0 || warnedDataFiles[filePath] {
+		return
+	}
+	warnedDataFiles[filePath] = true
+
+	fmt.Fprintf(os.Stderr, "Warning: %s has %d invalid %s byte sequences, the first on line %d, they were replaced by %q.\n",
+		filePath, d.Invalid, d.Encoding, d.FirstInvalidLine, utf8.RuneError)
+	// a detected UTF-8 file is mostly valid, only a chosen one may really be another encoding
+	if !d.Detected && (d.Encoding == EncodingUTF8 || d.Encoding == EncodingUTF8BOM) {
+		fmt.Fprintf(os.Stderr, "Older releases are %s encoded, try -encoding %s.\n", EncodingWindows1252, EncodingWindows1252)
+	}
+}
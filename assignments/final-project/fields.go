@@ -0,0 +1,337 @@
+// CST8333 Cheese Directory App - Field Registry - Lucas Estienne
+
+package main
+
+import (
+	"fmt"
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// kinds of values a field holds
+const (
+	FieldInt = "int"
+	FieldText = "text"
+	FieldFloat = "float"
+	FieldBool = "bool"
+	FieldDate = "date"
+)
+
+// byte order mark some spreadsheet programs write at the start of CSV files
+const byteOrderMark = "\ufeff"
+
+// simple data structure describing a field of a record: its Record field and export header, its column
+// in the cheese_records view, its English/French data file headers, its kind of value and prompt label,
+// the SQL expression it is read from when it is not stored in the cheeses table, and a pointer to its value
+type Field struct {
+	Name string
+	Column string
+	HeaderEn string
+	HeaderFr string
+	Type string
+	Prompt string
+	Searchable bool
+	Exportable bool
+	Vocabulary bool
+	Expression string
+	Value func(r *Record) interface{}
+}
+
+// fields of a record, in display and export order, adding a field to Record only needs an entry here
+var recordFields = []Field {
+	{"CheeseId", "cheese_id", "CheeseId", "", FieldInt, "Cheese ID (int)", false, true, false, "",
+		func(r *Record) interface{} { return &r.CheeseId }},
+	{"CheeseName", "cheese_name", "CheeseNameEn", "CheeseNameFr", FieldText, "Cheese Name", true, true, false, "",
+		func(r *Record) interface{} { return &r.CheeseName }},
+	// manufacturers have their own table
+	{"ManufacturerName", "manufacturer_name", "ManufacturerNameEn", "ManufacturerNameFr", FieldText, "Manufacturer Name", true, true, false,
+		"COALESCE(NULLIF(m.name_en, ''), NULLIF(m.name_fr, ''))",
+		func(r *Record) interface{} { return &r.ManufacturerName }},
+	{"ManufacturerProvCode", "manufacturer_prov_code", "ManufacturerProvCode", "", FieldText, "Manufacturer Prov Code", true, true, false,
+		"NULLIF(m.prov_code, '')",
+		func(r *Record) interface{} { return &r.ManufacturerProvCode }},
+	{"ManufacturingType", "manufacturing_type", "ManufacturingTypeEn", "ManufacturingTypeFr", FieldText, "Manufacturing Type", true, true, true, "",
+		func(r *Record) interface{} { return &r.ManufacturingType }},
+	{"WebSite", "website", "WebSiteEn", "WebSiteFr", FieldText, "Website", true, true, false, "",
+		func(r *Record) interface{} { return &r.WebSite }},
+	{"FatContentPercent", "fat_content_percent", "FatContentPercent", "", FieldFloat, "Fat Content Percent", false, true, false, "",
+		func(r *Record) interface{} { return &r.FatContentPercent }},
+	{"MoisturePercent", "moisture_percent", "MoisturePercent", "", FieldFloat, "Moisture Percent", false, true, false, "",
+		func(r *Record) interface{} { return &r.MoisturePercent }},
+	{"Particularities", "particularities", "ParticularitiesEn", "ParticularitiesFr", FieldText, "Particularities", true, true, false, "",
+		func(r *Record) interface{} { return &r.Particularities }},
+	{"Flavour", "flavour", "FlavourEn", "FlavourFr", FieldText, "Flavour", true, true, false, "",
+		func(r *Record) interface{} { return &r.Flavour }},
+	{"Characteristics", "characteristics", "CharacteristicsEn", "CharacteristicsFr", FieldText, "Characteristics", true, true, false, "",
+		func(r *Record) interface{} { return &r.Characteristics }},
+	{"Ripening", "ripening", "RipeningEn", "RipeningFr", FieldText, "Ripening", true, true, false, "",
+		func(r *Record) interface{} { return &r.Ripening }},
+	// organic is filtered on as a boolean rather than offered as a search column
+	{"Organic", "organic", "Organic", "", FieldBool, "Organic (bool)", false, true, false, "",
+		func(r *Record) interface{} { return &r.Organic }},
+	{"CategoryType", "category_type", "CategoryTypeEn", "CategoryTypeFr", FieldText, "Category Type", true, true, true, "",
+		func(r *Record) interface{} { return &r.CategoryType }},
+	{"MilkType", "milk_type", "MilkTypeEn", "MilkTypeFr", FieldText, "Milk Type", true, true, true, "",
+		func(r *Record) interface{} { return &r.MilkType }},
+	{"MilkTreatmentType", "milk_treatment_type", "MilkTreatmentTypeEn", "MilkTreatmentTypeFr", FieldText, "Milk Treatment Type", true, true, true, "",
+		func(r *Record) interface{} { return &r.MilkTreatmentType }},
+	{"RindType", "rind_type", "RindTypeEn", "RindTypeFr", FieldText, "Rind Type", true, true, true, "",
+		func(r *Record) interface{} { return &r.RindType }},
+	// last update dates are stamped rather than prompted for
+	{"LastUpdateDate", "last_update_date", "LastUpdateDate", "", FieldDate, "", true, true, false, "",
+		func(r *Record) interface{} { return &r.LastUpdateDate }},
+	// ratings are computed from the reviews of the cheese
+	{"AverageRating", "average_rating", "", "", FieldFloat, "", false, false, false,
+		"(SELECT ROUND(AVG(rating), 2) FROM reviews WHERE reviews.cheese_id = c.cheese_id)",
+		func(r *Record) interface{} { return &r.AverageRating }},
+	{"ReviewCount", "review_count", "", "", FieldInt, "", false, false, false,
+		"(SELECT COUNT(*) FROM reviews WHERE reviews.cheese_id = c.cheese_id)",
+		func(r *Record) interface{} { return &r.ReviewCount }},
+}
+
+// columns of the cheese_records view that are not record fields
+var recordViewExtraColumns = []string { "c.manufacturer_id", "c.ripening_min_days", "c.ripening_max_days" }
+
+// SQL column types of each kind of value
+var fieldSqlTypes = map[string]string {
+	FieldInt: "INTEGER",
+	FieldText: "TEXT",
+	FieldFloat: "REAL",
+	FieldBool: "INTEGER",
+	FieldDate: "TEXT",
+}
+
+// helper function to list the fields matching a condition
+func fieldsWhere(match func(f Field) bool) []Field {
+	var fs []Field
+	for _, f := range recordFields {
+		if match(f) {
+			fs = append(fs, f)
+		}
+	}
+	return fs
+}
+
+// helper function to find a field by its column
+func fieldByColumn(column string) (Field, bool) {
+	for _, f := range recordFields {
+		if f.Column == column {
+			return f, true
+		}
+	}
+	return Field{}, false
+}
+
+// helper function to list the columns of fields
+func fieldColumns(fs []Field) []string {
+	var columns []string
+	for _, f := range fs {
+		columns = append(columns, f.Column)
+	}
+	return columns
+}
+
+// function to check if a field is stored in the cheeses table
+func (f Field) Stored() bool {
+	return f.Expression == ""
+}
+
+// helper function to list the headers of the exported fields
+func exportHeaders() []string {
+	var headers []string
+	for _, f := range fieldsWhere(func(f Field) bool { return f.Exportable }) {
+		headers = append(headers, f.Name)
+	}
+	return headers
+}
+
+// helper function to get the SQL select list of the record fields
+func recordSelectColumns() string {
+	return strings.Join(fieldColumns(recordFields), ", ")
+}
+
+// helper function to build the scan destinations of a record, in recordFields order
+func recordScanDestinations(r *Record) []interface{} {
+	var dests []interface{}
+	for _, f := range recordFields {
+		dests = append(dests, f.Value(r))
+	}
+	return dests
+}
+
+// function to read the record id and record of the current row, the row must select id then recordSelectColumns
+func scanRecord(rows *sql.Rows) (int64, Record, error) {
+	var id int64
+	var r Record
+	err := rows.Scan(append([]interface{}{&id}, recordScanDestinations(&r)...)...)
+	return id, r, err
+}
+
+// helper function to build the statement inserting a record into the cheeses table, matching insertRecordArgs
+func insertRecordSql() string {
+	columns := append(fieldColumns(fieldsWhere(Field.Stored)), "manufacturer_id", "ripening_min_days", "ripening_max_days")
+	return fmt.Sprintf(`INSERT INTO cheeses (%s) VALUES (%s)`,
+		strings.Join(columns, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", "))
+}
+
+// helper function to build the values inserted for a record, the stored fields then the manufacturer and parsed ripening period
+func insertRecordArgs(r Record, manufacturerId int) []interface{} {
+	var args []interface{}
+	for _, f := range fieldsWhere(Field.Stored) {
+		args = append(args, f.Value(&r))
+	}
+
+	// ripening periods are parsed from the text
+	ripeningMinDays, ripeningMaxDays := r.RipeningDays()
+	return append(args, manufacturerId, ripeningMinDays, ripeningMaxDays)
+}
+
+// function to export the value of a field, missing values are empty
+func exportField(r Record, f Field) string {
+	switch v := f.Value(&r).(type) {
+		case *int:
+			return strconv.Itoa(*v)
+		case *bool:
+			return strconv.FormatBool(*v)
+		case *sql.NullString:
+			return v.String
+		case *sql.NullFloat64:
+			return exportFloat(*v)
+		case *Date:
+			return exportDate(*v)
+	}
+	return ""
+}
+
+// function to display the value of a field, missing values are N/A
+func displayField(r Record, f Field) string {
+	switch v := f.Value(&r).(type) {
+		case *sql.NullString:
+			return displayString(*v)
+		case *sql.NullFloat64:
+			return displayFloat(*v)
+		case *Date:
+			return v.String()
+	}
+	return exportField(r, f)
+}
+
+// function to set the value of a field from text, values that cannot be parsed are zero or missing
+func parseField(r *Record, f Field, s string) {
+	s = strings.TrimSpace(s)
+
+	switch v := f.Value(r).(type) {
+		case *int:
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil { n = 0 }
+			*v = int(n)
+		case *bool:
+			b, err := strconv.ParseBool(s)
+			if err != nil { b = false }
+			*v = b
+		case *sql.NullString:
+			*v = nullString(s)
+		case *sql.NullFloat64:
+			*v = parseNullFloat(s)
+		case *Date:
+			d, err := parseDate(s)
+			if err != nil { d = Date{} }
+			*v = d
+	}
+}
+
+// helper function to map the headers of a data file to their index, ignoring a byte order mark and spacing
+func csvHeaderIndexes(headers []string) map[string]int {
+	indexes := map[string]int{}
+	for i, h := range headers {
+		indexes[strings.TrimSpace(strings.TrimPrefix(h, byteOrderMark))] = i
+	}
+	return indexes
+}
+
+// helper function to get the value of a column of a data file line, empty when the column is absent
+func csvValue(line []string, indexes map[string]int, header string) string {
+	if i, ok := indexes[header]; ok && header != "" && i < len(line) {
+		return line[i]
+	}
+	return ""
+}
+
+// helper function to get the set of columns of a table
+func tableColumns(q querier, table string) (map[string]bool, error) {
+	columns := map[string]bool{}
+
+	rows, err := q.Query(`PRAGMA table_info(` + table + `)`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var (
+			cid int
+			name, columnType string
+			notNull int
+			defaultValue sql.NullString
+			pk int
+		)
+		if err = rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		columns[name] = true
+	}
+	rows.Close()
+	return columns, nil
+}
+
+// function to create the cheeses columns of the stored fields the table does not have yet
+func addFieldColumns(database *sql.DB) {
+	existing, err := tableColumns(database, "cheeses")
+	check(err)
+
+	var statements []string
+	for _, f := range fieldsWhere(Field.Stored) {
+		if !existing[f.Column] {
+			statements = append(statements, fmt.Sprintf(`ALTER TABLE cheeses ADD COLUMN %s %s`, f.Column, fieldSqlTypes[f.Type]))
+		}
+	}
+
+	tx, err := database.Begin()
+	check(err)
+	if err = execAll(tx, statements...); err != nil {
+		tx.Rollback()
+		check(err)
+	}
+	check(tx.Commit())
+}
+
+// function to create the cheese_records view reading every field
+func syncFieldSchema(database *sql.DB) {
+	var selects []string
+	for _, f := range recordFields {
+		if !f.Stored() {
+			selects = append(selects, f.Expression + " AS " + f.Column)
+			continue
+		}
+		if f.Vocabulary {
+			// categorical fields store the codes of their terms
+			selects = append(selects, vocabularyViewExpression(f.Column) + " AS " + f.Column)
+			continue
+		}
+		selects = append(selects, "c." + f.Column)
+	}
+
+	tx, err := database.Begin()
+	check(err)
+	err = execAll(tx,
+		`DROP VIEW IF EXISTS cheese_records`,
+		`CREATE VIEW cheese_records AS
+			SELECT c.id, ` + strings.Join(append(selects, recordViewExtraColumns...), ", ") + `
+			FROM cheeses c LEFT JOIN manufacturers m ON m.id = c.manufacturer_id`,
+	)
+	if err != nil {
+		tx.Rollback()
+		check(err)
+	}
+	check(tx.Commit())
+}
@@ -0,0 +1,177 @@
+// CST8333 Cheese Directory App - Compressed and Archived Input - Lucas Estienne
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// data file path meaning stdin, e.g. "cheesedir diff - < release.csv"
+const StdinPath = "-"
+
+// separator between a zip archive and the CSV file to read inside it, e.g. "release.zip#cheeses.csv"
+const zipMemberSeparator = "#"
+
+// first bytes of gzip-compressed files and of zip archives
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zipMagic = []byte("PK\x03\x04")
+)
+
+// stdin can only be read once, it is kept for the functions reading the same data file again
+var (
+	stdinOnce sync.Once
+	stdinData []byte
+	stdinErr error
+)
+
+// simple data structure reading a data file through its decompression and closing every layer underneath it
+type dataFileReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+// function to close a data file, the decompression first
+func (d dataFileReader) Close() error {
+	var first error
+	for i := len(d.closers) - 1; i >= 0; i-- {
+		if err := d.closers[i].Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// helper function to read all of stdin the first time, later calls get the same bytes
+func readStdin() ([]byte, error) {
+	stdinOnce.Do(func() {
+		stdinData, stdinErr = io.ReadAll(os.Stdin)
+	})
+	return stdinData, stdinErr
+}
+
+// helper function to split a path into a zip archive and the file inside it, when one is given after the separator
+func splitZipMember(filePath string) (string, string) {
+	i := strings.LastIndex(filePath, zipMemberSeparator)
+	if i > 0 && strings.EqualFold(filepath.Ext(filePath[:i]), ".zip") {
+		return filePath[:i], filePath[i + len(zipMemberSeparator):]
+	}
+	return filePath, ""
+}
+
+// function to choose the CSV file to read in a zip archive, the one named or else the only CSV file in it
+func zipMember(files []*zip.File, archive string, member string) (*zip.File, error) {
+	var csvFiles []*zip.File
+	var names []string
+	for _, f := range files {
+		if f.FileInfo().IsDir() || strings.HasPrefix(f.Name, "__MACOSX/") {
+			continue
+		}
+		if member != "" && (f.Name == member || path.Base(f.Name) == member) {
+			return f, nil
+		}
+		if strings.EqualFold(path.Ext(f.Name), ".csv") {
+			csvFiles = append(csvFiles, f)
+			names = append(names, f.Name)
+		}
+	}
+
+	switch {
+		case member != "":
+			return nil, fmt.Errorf("%s has no file %s", archive, member)
+		case len(csvFiles) == 0:
+			return nil, fmt.Errorf("%s has no CSV file", archive)
+		case len(csvFiles) > 1:
+			return nil, fmt.Errorf("%s has several CSV files %v, choose one with %s%s<name>", archive, names, archive, zipMemberSeparator)
+	}
+	return csvFiles[0], nil
+}
+
+// function to open the CSV file chosen in a zip archive
+func openZipMember(r *zip.Reader, archive string, member string) (io.ReadCloser, error) {
+	f, err := zipMember(r.File, archive, member)
+	if err != nil {
+		return nil, err
+	}
+	return f.Open()
+}
+
+// function to open a data file for reading, decompressing it when it is gzip-compressed or a zip archive,
+// the format is found by the extension or else by the first bytes of the file, the path "-" reads stdin
+func openDataFile(filePath string) (io.ReadCloser, error) {
+	if filePath == StdinPath {
+		data, err := readStdin()
+		if err != nil {
+			return nil, err
+		}
+		return openDataBytes(data)
+	}
+
+	archive, member := splitZipMember(filePath)
+	file, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	buffered := bufio.NewReader(file)
+	magic, _ := buffered.Peek(len(zipMagic))
+
+	switch ext := strings.ToLower(filepath.Ext(archive)); {
+		case member != "" || ext == ".zip" || bytes.HasPrefix(magic, zipMagic):
+			info, err := file.Stat()
+			if err == nil {
+				var r *zip.Reader
+				if r, err = zip.NewReader(file, info.Size()); err == nil {
+					var csvFile io.ReadCloser
+					if csvFile, err = openZipMember(r, archive, member); err == nil {
+						return dataFileReader{csvFile, []io.Closer{file, csvFile}}, nil
+					}
+				}
+			}
+			file.Close()
+			return nil, err
+		case ext == ".gz" || bytes.HasPrefix(magic, gzipMagic):
+			decompressed, err := gzip.NewReader(buffered)
+			if err != nil {
+				file.Close()
+				return nil, fmt.Errorf("%s: %v", filePath, err)
+			}
+			return dataFileReader{decompressed, []io.Closer{file, decompressed}}, nil
+	}
+	return dataFileReader{buffered, []io.Closer{file}}, nil
+}
+
+// function to open a data file already read into memory, e.g. from stdin, the format is found by its first bytes
+func openDataBytes(data []byte) (io.ReadCloser, error) {
+	switch {
+		case bytes.HasPrefix(data, zipMagic):
+			r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				return nil, err
+			}
+			return openZipMember(r, "stdin", "")
+		case bytes.HasPrefix(data, gzipMagic):
+			return gzip.NewReader(bytes.NewReader(data))
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// function to find the data file, the CSV file or else a compressed copy of it, e.g. the download left zipped
+func findDataFile(filePath string) string {
+	candidates := []string{filePath, filePath + ".gz", strings.TrimSuffix(filePath, filepath.Ext(filePath)) + ".zip"}
+	for _, c := range candidates {
+		if _, err := os.Stat(c); err == nil {
+			return c
+		}
+	}
+	return filePath
+}
@@ -0,0 +1,743 @@
+// CST8333 Cheese Directory App - Shop Inventory - Lucas Estienne
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"database/sql"
+	"errors"
+	"flag"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// units stock is counted in
+const (
+	UnitKg = "kg"
+	UnitWheel = "wheel"
+)
+
+var stockUnits = []string { UnitKg, UnitWheel }
+
+// quantities are kept to the gram, wheels are counted whole
+const stockPrecision = 1000
+
+// kinds of stock movements, receiving adds to a lot and selling or wasting takes from it
+const (
+	MovementReceive = "receive"
+	MovementSell = "sell"
+	MovementWaste = "waste"
+)
+
+// simple data structure containing a lot of a cheese in stock
+type StockLot struct {
+	Id int
+	CheeseId int
+	LotNumber string
+	Unit string
+	Quantity float64
+	UnitCost sql.NullFloat64
+	ReceivedDate Date
+	BestBeforeDate Date
+}
+
+// simple data structure containing a stock movement of a lot
+type StockMovement struct {
+	Id int
+	CheeseId int
+	LotNumber string
+	Unit string
+	Kind string
+	Quantity float64
+	Username string
+	CreatedAt string
+	Note sql.NullString
+}
+
+// simple data structure containing a cheese whose stock is below its threshold
+type LowStockAlert struct {
+	CheeseId int
+	Unit string
+	MinQuantity float64
+	Quantity float64
+}
+
+// function to create the inventory tables
+func migrateInventory(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE inventory_lots (
+			id INTEGER PRIMARY KEY,
+			cheese_id INTEGER NOT NULL,
+			lot_number TEXT NOT NULL,
+			unit TEXT NOT NULL CHECK (unit IN ('kg', 'wheel')),
+			quantity REAL NOT NULL DEFAULT 0 CHECK (quantity >= 0),
+			unit_cost REAL,
+			received_date TEXT,
+			best_before_date TEXT,
+			UNIQUE (cheese_id, lot_number)
+		)`,
+		`CREATE TABLE stock_movements (
+			id INTEGER PRIMARY KEY,
+			lot_id INTEGER NOT NULL REFERENCES inventory_lots(id),
+			kind TEXT NOT NULL CHECK (kind IN ('receive', 'sell', 'waste')),
+			quantity REAL NOT NULL,
+			username TEXT NOT NULL,
+			created_at TEXT NOT NULL,
+			note TEXT
+		)`,
+		`CREATE TABLE stock_thresholds (
+			cheese_id INTEGER NOT NULL,
+			unit TEXT NOT NULL,
+			min_quantity REAL NOT NULL,
+			PRIMARY KEY (cheese_id, unit)
+		)`,
+	)
+}
+
+// helper function to round a quantity to the gram, so that sums and differences of quantities compare exactly
+func roundQuantity(quantity float64) float64 {
+	return math.Round(quantity * stockPrecision) / stockPrecision
+}
+
+// migration rounding stock quantities to the gram, earlier sales could leave fractions of a gram in a lot
+func migrateStockQuantities(tx *sql.Tx) error {
+	return execAll(tx,
+		`UPDATE inventory_lots SET quantity = ROUND(quantity, 3)`,
+		`UPDATE stock_movements SET quantity = ROUND(quantity, 3)`,
+		`UPDATE stock_thresholds SET min_quantity = ROUND(min_quantity, 3)`,
+	)
+}
+
+// helper function to check a unit and a positive quantity, at least a gram or a whole number of wheels
+func checkStockQuantity(unit string, quantity float64) error {
+	if !stringInSlice(unit, stockUnits) {
+		return fmt.Errorf("unknown unit %q, expected one of %v", unit, stockUnits)
+	}
+	if roundQuantity(quantity) <= 0 {
+		return fmt.Errorf("invalid quantity %g, expected more than 0", quantity)
+	}
+	if unit == UnitWheel && quantity != math.Trunc(quantity) {
+		return fmt.Errorf("invalid quantity %g, wheels are counted whole", quantity)
+	}
+	return nil
+}
+
+// helper function to record a stock movement of a lot
+func insertStockMovement(tx *sql.Tx, lotId int, kind string, quantity float64, username string, note sql.NullString) error {
+	_, err := tx.Exec(`
+		INSERT INTO stock_movements (lot_id, kind, quantity, username, created_at, note) VALUES (?, ?, ?, ?, ?, ?)
+	`, lotId, kind, quantity, username, time.Now().UTC().Format(time.RFC3339), note)
+	return err
+}
+
+// function to receive stock into a lot, creating the lot or adding to it, a known cost and best-before date replace the lot's
+func receiveStock(database *sql.DB, username string, lot StockLot) error {
+	var (
+		lotId int
+		unit string
+	)
+
+	lot.LotNumber = strings.TrimSpace(lot.LotNumber)
+	if lot.LotNumber == "" {
+		return errors.New("a lot needs a lot number")
+	}
+	if err := checkStockQuantity(lot.Unit, lot.Quantity); err != nil {
+		return err
+	}
+	lot.Quantity = roundQuantity(lot.Quantity)
+	if lot.ReceivedDate.IsZero() {
+		lot.ReceivedDate = today()
+	}
+
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = checkCheeseExists(tx, lot.CheeseId); err != nil {
+		return err
+	}
+
+	err = tx.QueryRow(`
+		SELECT id, unit FROM inventory_lots WHERE cheese_id = ? AND lot_number = ?
+	`, lot.CheeseId, lot.LotNumber).Scan(&lotId, &unit)
+	switch {
+		case err == sql.ErrNoRows:
+			var result sql.Result
+			result, err = tx.Exec(`
+				INSERT INTO inventory_lots (cheese_id, lot_number, unit, quantity, unit_cost, received_date, best_before_date)
+				VALUES (?, ?, ?, ?, ?, ?, ?)
+			`, lot.CheeseId, lot.LotNumber, lot.Unit, lot.Quantity, lot.UnitCost, lot.ReceivedDate, lot.BestBeforeDate)
+			if err == nil {
+				var id int64
+				id, err = result.LastInsertId()
+				lotId = int(id)
+			}
+		case err != nil:
+		case unit != lot.Unit:
+			err = fmt.Errorf("lot %s is counted in %s, not %s", lot.LotNumber, unit, lot.Unit)
+		default:
+			_, err = tx.Exec(`
+				UPDATE inventory_lots SET quantity = ROUND(quantity + ?, 3), unit_cost = COALESCE(?, unit_cost),
+				best_before_date = COALESCE(?, best_before_date) WHERE id = ?
+			`, lot.Quantity, lot.UnitCost, lot.BestBeforeDate, lotId)
+	}
+	if err == nil {
+		err = insertStockMovement(tx, lotId, MovementReceive, lot.Quantity, username, sql.NullString{})
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// function to sell or waste stock of a cheese, from one lot or from the lots closest to their best-before date first
+func takeStock(database *sql.DB, username string, kind string, cheeseId int, lotNumber string, unit string, quantity float64, note string) error {
+	if kind != MovementSell && kind != MovementWaste {
+		return fmt.Errorf("unknown stock movement %q", kind)
+	}
+	if err := checkStockQuantity(unit, quantity); err != nil {
+		return err
+	}
+	quantity = roundQuantity(quantity)
+
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT id, quantity FROM inventory_lots
+		WHERE cheese_id = ? AND unit = ? AND quantity > 0 AND (? = '' OR lot_number = ?)
+		ORDER BY best_before_date IS NULL, best_before_date, received_date, id
+	`, cheeseId, unit, lotNumber, lotNumber)
+	if err != nil {
+		return err
+	}
+	var lots []StockLot
+	available := 0.0
+	for rows.Next() {
+		var l StockLot
+		if err = rows.Scan(&l.Id, &l.Quantity); err != nil {
+			rows.Close()
+			return err
+		}
+		l.Quantity = roundQuantity(l.Quantity)
+		lots = append(lots, l)
+		available = roundQuantity(available + l.Quantity)
+	}
+	rows.Close()
+
+	if available < quantity {
+		return fmt.Errorf("only %g %s of CheeseId %d in stock", available, unit, cheeseId)
+	}
+
+	remaining := quantity
+	for _, l := range lots {
+		if remaining <= 0 {
+			break
+		}
+		taken := l.Quantity
+		if remaining < taken {
+			taken = remaining
+		}
+		remaining = roundQuantity(remaining - taken)
+
+		if _, err = tx.Exec(`UPDATE inventory_lots SET quantity = MAX(ROUND(quantity - ?, 3), 0) WHERE id = ?`, taken, l.Id); err != nil {
+			return err
+		}
+		if err = insertStockMovement(tx, l.Id, kind, taken, username, nullString(note)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// function to set the quantity below which a cheese is low on stock, 0 removes the threshold
+func setStockThreshold(database *sql.DB, cheeseId int, unit string, minQuantity float64) error {
+	if !stringInSlice(unit, stockUnits) {
+		return fmt.Errorf("unknown unit %q, expected one of %v", unit, stockUnits)
+	}
+	if minQuantity <= 0 {
+		_, err := database.Exec(`DELETE FROM stock_thresholds WHERE cheese_id = ? AND unit = ?`, cheeseId, unit)
+		return err
+	}
+	if err := checkCheeseExists(database, cheeseId); err != nil {
+		return err
+	}
+
+	_, err := database.Exec(`
+		INSERT INTO stock_thresholds (cheese_id, unit, min_quantity) VALUES (?, ?, ?)
+		ON CONFLICT (cheese_id, unit) DO UPDATE SET min_quantity = excluded.min_quantity
+	`, cheeseId, unit, roundQuantity(minQuantity))
+	return err
+}
+
+// function to select the lots in stock, of one cheese or of all cheeses when cheeseId is 0
+func getStockLots(database *sql.DB, cheeseId int) []StockLot {
+	var lots []StockLot
+
+	rows, err := database.Query(`
+		SELECT id, cheese_id, lot_number, unit, quantity, unit_cost, received_date, best_before_date
+		FROM inventory_lots WHERE quantity > 0 AND (? = 0 OR cheese_id = ?)
+		ORDER BY cheese_id, best_before_date IS NULL, best_before_date, id
+	`, cheeseId, cheeseId)
+	check(err)
+	defer rows.Close()
+
+	for rows.Next() {
+		var l StockLot
+		err = rows.Scan(&l.Id, &l.CheeseId, &l.LotNumber, &l.Unit, &l.Quantity, &l.UnitCost, &l.ReceivedDate, &l.BestBeforeDate)
+		if err != nil {
+			log.Fatal(err)
+		}
+		lots = append(lots, l)
+	}
+
+	return lots
+}
+
+// function to select the most recent stock movements, of one cheese or of all cheeses when cheeseId is 0
+func getStockMovements(database *sql.DB, cheeseId int, limit int) []StockMovement {
+	var movements []StockMovement
+
+	rows, err := database.Query(`
+		SELECT m.id, l.cheese_id, l.lot_number, l.unit, m.kind, m.quantity, m.username, m.created_at, m.note
+		FROM stock_movements m JOIN inventory_lots l ON l.id = m.lot_id
+		WHERE ? = 0 OR l.cheese_id = ? ORDER BY m.id DESC LIMIT ?
+	`, cheeseId, cheeseId, limit)
+	check(err)
+	defer rows.Close()
+
+	for rows.Next() {
+		var m StockMovement
+		err = rows.Scan(&m.Id, &m.CheeseId, &m.LotNumber, &m.Unit, &m.Kind, &m.Quantity, &m.Username, &m.CreatedAt, &m.Note)
+		if err != nil {
+			log.Fatal(err)
+		}
+		movements = append(movements, m)
+	}
+
+	return movements
+}
+
+// function to select the cheeses whose stock is below their threshold
+func getLowStockAlerts(database *sql.DB) []LowStockAlert {
+	var alerts []LowStockAlert
+
+	rows, err := database.Query(`
+		SELECT t.cheese_id, t.unit, t.min_quantity, COALESCE(ROUND(SUM(l.quantity), 3), 0) AS quantity
+		FROM stock_thresholds t LEFT JOIN inventory_lots l ON l.cheese_id = t.cheese_id AND l.unit = t.unit
+		GROUP BY t.cheese_id, t.unit HAVING quantity < t.min_quantity ORDER BY t.cheese_id
+	`)
+	check(err)
+	defer rows.Close()
+
+	for rows.Next() {
+		var a LowStockAlert
+		if err = rows.Scan(&a.CheeseId, &a.Unit, &a.MinQuantity, &a.Quantity); err != nil {
+			log.Fatal(err)
+		}
+		alerts = append(alerts, a)
+	}
+
+	return alerts
+}
+
+// function to select the lots in stock whose best-before date is within a number of days, or past
+func getExpiringLots(database *sql.DB, days int) []StockLot {
+	var expiring []StockLot
+
+	cutoff := Date{today().AddDate(0, 0, days)}
+	for _, l := range getStockLots(database, 0) {
+		if !l.BestBeforeDate.IsZero() && !l.BestBeforeDate.After(cutoff.Time) {
+			expiring = append(expiring, l)
+		}
+	}
+	return expiring
+}
+
+// helper function to get the names of the cheeses in the directory, by CheeseId
+func cheeseNamesById(database *sql.DB) map[int]string {
+	names := map[int]string{}
+	for _, r := range getAllCheeses(database) {
+		if _, ok := names[r.CheeseId]; !ok {
+			names[r.CheeseId] = displayString(r.CheeseName)
+		}
+	}
+	return names
+}
+
+// helper function to display a quantity without trailing zeros
+func formatQuantity(quantity float64, unit string) string {
+	return strconv.FormatFloat(roundQuantity(quantity), 'f', -1, 64) + " " + unit
+}
+
+// function to build the stock valuation report, the quantity and value in stock of each cheese and unit,
+// lots without a cost count in the quantity but not in the value
+func stockValuationRows(database *sql.DB) [][]string {
+	type key struct {
+		CheeseId int
+		Unit string
+	}
+	var keys []key
+	quantities, values, unvalued := map[key]float64{}, map[key]float64{}, map[key]float64{}
+
+	for _, l := range getStockLots(database, 0) {
+		k := key{l.CheeseId, l.Unit}
+		if _, ok := quantities[k]; !ok {
+			keys = append(keys, k)
+		}
+		quantities[k] += l.Quantity
+		if l.UnitCost.Valid {
+			values[k] += l.Quantity * l.UnitCost.Float64
+		} else {
+			unvalued[k] += l.Quantity
+		}
+	}
+
+	names := cheeseNamesById(database)
+	rows := [][]string{{"CheeseId", "CheeseName", "Quantity", "Without cost", "Value"}}
+	total := 0.0
+	for _, k := range keys {
+		rows = append(rows, []string{
+			strconv.Itoa(k.CheeseId), names[k.CheeseId], formatQuantity(quantities[k], k.Unit),
+			formatQuantity(unvalued[k], k.Unit), fmt.Sprintf("%.2f", values[k]),
+		})
+		total += values[k]
+	}
+	return append(rows, []string{"Total", "", "", "", fmt.Sprintf("%.2f", total)})
+}
+
+// function to output the stock valuation report to a file, or to the screen when the path is empty
+func outputStockValuation(database *sql.DB, format string, filePath string) error {
+	if !stringInSlice(format, reportFormats) {
+		return fmt.Errorf("unknown format %q, expected one of %v", format, reportFormats)
+	}
+
+	w, closeOutput, err := createOutput(filePath)
+	if err != nil {
+		return err
+	}
+	defer closeOutput()
+
+	err = writeReportRows(w, stockValuationRows(database), format)
+	if err == nil && filePath != "" {
+		fmt.Printf("\n Done writing to %s.\n", filePath)
+	}
+	return err
+}
+
+// function to print lots as a table
+func printStockLots(database *sql.DB, lots []StockLot) {
+	names := cheeseNamesById(database)
+
+	rows := [][]string{{"CheeseId", "CheeseName", "Lot", "Quantity", "Unit cost", "Received", "Best before"}}
+	for _, l := range lots {
+		rows = append(rows, []string{
+			strconv.Itoa(l.CheeseId), names[l.CheeseId], l.LotNumber, formatQuantity(l.Quantity, l.Unit),
+			displayFloat(l.UnitCost), l.ReceivedDate.String(), l.BestBeforeDate.String(),
+		})
+	}
+
+	writeAlignedTable(os.Stdout, rows)
+	fmt.Printf("\n%d lots in stock\n", len(lots))
+}
+
+// function to print stock movements as a table
+func printStockMovements(movements []StockMovement) {
+	rows := [][]string{{"CheeseId", "Lot", "Movement", "Quantity", "By", "Date", "Note"}}
+	for _, m := range movements {
+		rows = append(rows, []string{
+			strconv.Itoa(m.CheeseId), m.LotNumber, m.Kind, formatQuantity(m.Quantity, m.Unit), m.Username,
+			strings.SplitN(m.CreatedAt, "T", 2)[0], displayString(m.Note),
+		})
+	}
+
+	writeAlignedTable(os.Stdout, rows)
+}
+
+// function to print the low-stock alerts and the lots expiring within a number of days
+func printStockAlerts(database *sql.DB, days int) {
+	names := cheeseNamesById(database)
+
+	alerts := getLowStockAlerts(database)
+	fmt.Printf("\n Low stock: %d\n", len(alerts))
+	for _, a := range alerts {
+		fmt.Printf("   CheeseId %-6d %-40s %s in stock, minimum %s\n", a.CheeseId, names[a.CheeseId],
+			formatQuantity(a.Quantity, a.Unit), formatQuantity(a.MinQuantity, a.Unit))
+	}
+
+	expiring := getExpiringLots(database, days)
+	fmt.Printf("\n Best before within %d days: %d\n", days, len(expiring))
+	for _, l := range expiring {
+		fmt.Printf("   CheeseId %-6d %-40s lot %s, %s, best before %s\n", l.CheeseId, names[l.CheeseId],
+			l.LotNumber, formatQuantity(l.Quantity, l.Unit), l.BestBeforeDate)
+	}
+}
+
+// helper function to read a quantity from stdin, asking again until it is a number
+func readQuantity(toRead string) float64 {
+	for {
+		q := parseNullFloat(readRequiredString(toRead))
+		if q.Valid && q.Float64 > 0 {
+			return q.Float64
+		}
+		fmt.Println("\nPlease enter a number more than 0.")
+	}
+}
+
+// helper function to read a date from stdin, blank for none
+func readDate(toRead string) Date {
+	for {
+		d, err := parseDate(readString(toRead + " (YYYY-MM-DD, Enter for none)"))
+		if err == nil {
+			return d
+		}
+		fmt.Printf("\n%v\n", err)
+	}
+}
+
+// function to manage the shop inventory from the menu
+func manageInventory(database *sql.DB, user User) {
+	selection := 0
+
+	fmt.Printf("\nShop inventory...\n\n")
+	fmt.Println(" 1. List the stock")
+	fmt.Println(" 2. List recent stock movements")
+	fmt.Println(" 3. Low-stock and best-before alerts")
+	fmt.Println(" 4. Stock valuation report")
+	if hasRole(user, RoleEditor) {
+		fmt.Println(" 5. Receive stock")
+		fmt.Println(" 6. Sell stock")
+		fmt.Println(" 7. Waste stock")
+		fmt.Println(" 8. Set a low-stock threshold")
+	}
+
+	// loop until selection is valid
+	for selection == 0 {
+		fmt.Printf("Please choose an option: ")
+
+		_, err := fmt.Scanf("%d", &selection)
+
+		if err != nil || selection < 1 || selection > 8 {
+			selection = 0
+			fmt.Println("\nPlease enter a valid option.")
+		} else if selection > 4 && !hasRole(user, RoleEditor) {
+			writeAuditLog(database, user.Username, "inventory", AuditDenied, "role "+user.Role)
+			fmt.Printf("\nYour role (%s) does not allow this option.\n", user.Role)
+			return
+		}
+	}
+
+	var err error
+
+	switch selection {
+		case 1:
+			cheeseId, _ := strconv.Atoi(readNewOrKeepDefaultString("CheeseId (0 for all cheeses)", "0"))
+			printStockLots(database, getStockLots(database, cheeseId))
+		case 2:
+			cheeseId, _ := strconv.Atoi(readNewOrKeepDefaultString("CheeseId (0 for all cheeses)", "0"))
+			printStockMovements(getStockMovements(database, cheeseId, 50))
+		case 3:
+			days, convErr := strconv.Atoi(readNewOrKeepDefaultString("days ahead for best-before dates", "7"))
+			if convErr != nil {
+				days = 7
+			}
+			printStockAlerts(database, days)
+		case 4:
+			err = outputStockValuation(database, FormatTable, "")
+		case 5:
+			lot := StockLot{}
+			if lot.CheeseId, err = strconv.Atoi(readRequiredString("CheeseId")); err != nil {
+				break
+			}
+			lot.LotNumber = readRequiredString("lot number")
+			lot.Unit = readColumnChoice("unit", stockUnits, false)
+			lot.Quantity = readQuantity("quantity")
+			lot.UnitCost = parseNullFloat(readString("cost per " + lot.Unit + " (Enter for unknown)"))
+			lot.BestBeforeDate = readDate("best-before date")
+			err = receiveStock(database, user.Username, lot)
+			if err == nil {
+				writeAuditLog(database, user.Username, "receive stock", AuditAllowed,
+					fmt.Sprintf("CheeseId %d lot %s: %s", lot.CheeseId, lot.LotNumber, formatQuantity(lot.Quantity, lot.Unit)))
+			}
+		case 6, 7:
+			kind := MovementSell
+			if selection == 7 {
+				kind = MovementWaste
+			}
+			var cheeseId int
+			if cheeseId, err = strconv.Atoi(readRequiredString("CheeseId")); err != nil {
+				break
+			}
+			lotNumber := readString("lot number (Enter for the lots closest to their best-before date)")
+			unit := readColumnChoice("unit", stockUnits, false)
+			quantity := readQuantity("quantity")
+			note := ""
+			if kind == MovementWaste {
+				note = readString("reason")
+			}
+			err = takeStock(database, user.Username, kind, cheeseId, lotNumber, unit, quantity, note)
+			if err == nil {
+				writeAuditLog(database, user.Username, kind + " stock", AuditAllowed,
+					fmt.Sprintf("CheeseId %d: %s", cheeseId, formatQuantity(quantity, unit)))
+			}
+		case 8:
+			var cheeseId int
+			if cheeseId, err = strconv.Atoi(readRequiredString("CheeseId")); err != nil {
+				break
+			}
+			unit := readColumnChoice("unit", stockUnits, false)
+			min := parseNullFloat(readRequiredString("minimum quantity (0 to remove the threshold)"))
+			err = setStockThreshold(database, cheeseId, unit, min.Float64)
+	}
+
+	if err != nil {
+		fmt.Printf("\nError: %v\n", err)
+	}
+}
+
+// helper function to parse the CheeseId and quantity arguments of a stock command
+func parseCheeseIdAndQuantity(args []string) (int, float64, error) {
+	if len(args) != 2 {
+		return 0, 0, errors.New("expected a CheeseId and a quantity")
+	}
+	cheeseId, err := strconv.Atoi(args[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid CheeseId %q", args[0])
+	}
+	quantity := parseNullFloat(args[1])
+	if !quantity.Valid {
+		return 0, 0, fmt.Errorf("invalid quantity %q", args[1])
+	}
+	return cheeseId, quantity.Float64, nil
+}
+
+// function to run the "stock" command
+func stockCommand(database *sql.DB, user User, args []string) error {
+	cheeseId := 0
+	if len(args) > 0 {
+		var err error
+		if cheeseId, err = strconv.Atoi(args[0]); err != nil {
+			return fmt.Errorf("invalid CheeseId %q", args[0])
+		}
+	}
+
+	printStockLots(database, getStockLots(database, cheeseId))
+	return nil
+}
+
+// function to run the "stock-receive" command
+func stockReceiveCommand(database *sql.DB, user User, args []string) error {
+	flags := flag.NewFlagSet("stock-receive", flag.ContinueOnError)
+	lotNumber := flags.String("lot", "", "lot number")
+	unit := flags.String("unit", UnitKg, fmt.Sprintf("unit %v", stockUnits))
+	cost := flags.String("cost", "", "cost per unit")
+	received := flags.String("received", "", "received date, today when empty")
+	bestBefore := flags.String("best-before", "", "best-before date")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	cheeseId, quantity, err := parseCheeseIdAndQuantity(flags.Args())
+	if err != nil {
+		return err
+	}
+	lot := StockLot{CheeseId: cheeseId, LotNumber: *lotNumber, Unit: *unit, Quantity: quantity, UnitCost: parseNullFloat(*cost)}
+	if *cost != "" && !lot.UnitCost.Valid {
+		return fmt.Errorf("invalid cost %q", *cost)
+	}
+	if lot.ReceivedDate, err = parseDate(*received); err != nil {
+		return err
+	}
+	if lot.BestBeforeDate, err = parseDate(*bestBefore); err != nil {
+		return err
+	}
+
+	return receiveStock(database, user.Username, lot)
+}
+
+// function to run the "stock-sell" and "stock-waste" commands
+func stockTakeCommand(kind string) func(database *sql.DB, user User, args []string) error {
+	return func(database *sql.DB, user User, args []string) error {
+		flags := flag.NewFlagSet("stock-" + kind, flag.ContinueOnError)
+		lotNumber := flags.String("lot", "", "lot number, the lots closest to their best-before date when empty")
+		unit := flags.String("unit", UnitKg, fmt.Sprintf("unit %v", stockUnits))
+		note := flags.String("note", "", "note, e.g. the reason for wasting")
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+
+		cheeseId, quantity, err := parseCheeseIdAndQuantity(flags.Args())
+		if err != nil {
+			return err
+		}
+		return takeStock(database, user.Username, kind, cheeseId, *lotNumber, *unit, quantity, *note)
+	}
+}
+
+// function to run the "stock-movements" command
+func stockMovementsCommand(database *sql.DB, user User, args []string) error {
+	flags := flag.NewFlagSet("stock-movements", flag.ContinueOnError)
+	limit := flags.Int("limit", 50, "number of movements to list")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	cheeseId := 0
+	if flags.NArg() > 0 {
+		var err error
+		if cheeseId, err = strconv.Atoi(flags.Arg(0)); err != nil {
+			return fmt.Errorf("invalid CheeseId %q", flags.Arg(0))
+		}
+	}
+
+	printStockMovements(getStockMovements(database, cheeseId, *limit))
+	return nil
+}
+
+// function to run the "stock-threshold" command
+func stockThresholdCommand(database *sql.DB, user User, args []string) error {
+	flags := flag.NewFlagSet("stock-threshold", flag.ContinueOnError)
+	unit := flags.String("unit", UnitKg, fmt.Sprintf("unit %v", stockUnits))
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	cheeseId, minQuantity, err := parseCheeseIdAndQuantity(flags.Args())
+	if err != nil {
+		return err
+	}
+	return setStockThreshold(database, cheeseId, *unit, minQuantity)
+}
+
+// function to run the "stock-alerts" command
+func stockAlertsCommand(database *sql.DB, user User, args []string) error {
+	flags := flag.NewFlagSet("stock-alerts", flag.ContinueOnError)
+	days := flags.Int("days", 7, "days ahead for best-before dates")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	printStockAlerts(database, *days)
+	return nil
+}
+
+// function to run the "stock-valuation" command
+func stockValuationCommand(database *sql.DB, user User, args []string) error {
+	flags := flag.NewFlagSet("stock-valuation", flag.ContinueOnError)
+	format := flags.String("format", FormatTable, fmt.Sprintf("output format %v", reportFormats))
+	filePath := flags.String("out", "", "output file, the screen when empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	return outputStockValuation(database, *format, *filePath)
+}
@@ -0,0 +1,523 @@
+// CST8333 Cheese Directory App - Manufacturers - Lucas Estienne
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"database/sql"
+	"errors"
+	"flag"
+	"log"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// simple data structure containing a manufacturer (fromagerie)
+type Manufacturer struct {
+	Id int
+	NameEn string
+	NameFr string
+	ProvCode string
+	WebSite string
+	NumCheeses int
+}
+
+// interface satisfied by both *sql.DB and *sql.Tx
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// accented letters and their unaccented equivalents
+var accentReplacer = strings.NewReplacer(
+	"à", "a", "â", "a", "ä", "a", "á", "a", "ç", "c", "é", "e", "è", "e", "ê", "e", "ë", "e",
+	"î", "i", "ï", "i", "í", "i", "ô", "o", "ö", "o", "ó", "o", "ù", "u", "û", "u", "ü", "u", "ú", "u",
+	"ÿ", "y", "œ", "oe", "æ", "ae",
+)
+
+// helper function to normalize a name for comparisons: lowercase, no accents, punctuation or repeated spaces
+func normalizeName(name string) string {
+	s := accentReplacer.Replace(strings.ToLower(name))
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return ' '
+	}, s)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// helper function to build the key identifying a manufacturer by name and province
+func manufacturerKey(name string, provCode string) string {
+	return normalizeName(name) + "|" + strings.ToUpper(strings.TrimSpace(provCode))
+}
+
+// function to return the display name of a manufacturer, English first like the other fields
+func (m Manufacturer) Name() string {
+	return displayString(getFirstNonEmptyString(m.NameEn, m.NameFr))
+}
+
+// helper function to list the keys a manufacturer is known by, unnamed manufacturers are known by their province
+func (m Manufacturer) keys() []string {
+	var keys []string
+	for _, name := range []string{m.NameEn, m.NameFr} {
+		if strings.TrimSpace(name) != "" {
+			keys = append(keys, manufacturerKey(name, m.ProvCode))
+		}
+	}
+	if len(keys) == 0 {
+		keys = append(keys, manufacturerKey("", m.ProvCode))
+	}
+	return keys
+}
+
+// helper function to add alias keys to a manufacturer, keys already used by another manufacturer are an error
+func addManufacturerAliases(q querier, id int, keys []string) error {
+	for _, key := range keys {
+		var owner int
+		err := q.QueryRow(`SELECT manufacturer_id FROM manufacturer_aliases WHERE alias_key = ?`, key).Scan(&owner)
+		if err == sql.ErrNoRows {
+			_, err = q.Exec(`INSERT INTO manufacturer_aliases (alias_key, manufacturer_id) VALUES (?, ?)`, key, id)
+		} else if err == nil && owner != id {
+			err = fmt.Errorf("the name %q is already used by manufacturer #%d, merge them instead", key, owner)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// function to find the manufacturer known by any of the keys, 0 when there is none
+func findManufacturerId(q querier, keys []string) (int, error) {
+	id := 0
+	for _, key := range keys {
+		err := q.QueryRow(`SELECT manufacturer_id FROM manufacturer_aliases WHERE alias_key = ?`, key).Scan(&id)
+		if err == nil {
+			return id, nil
+		} else if err != sql.ErrNoRows {
+			return 0, err
+		}
+	}
+	return 0, nil
+}
+
+// function to find the manufacturer of a record by name and province, creating it when there is none
+func manufacturerIdForRecord(q querier, r Record) (int, error) {
+	m := Manufacturer{NameEn: r.ManufacturerName.String, ProvCode: r.ManufacturerProvCode.String}
+
+	// the record only has the display name, so existing manufacturers are left untouched
+	id, err := findManufacturerId(q, m.keys())
+	if err != nil || id != 0 {
+		return id, err
+	}
+	return resolveManufacturer(q, m)
+}
+
+// function to find the manufacturer matching any of the names of m, creating it when there is none;
+// blank names, province and website of an existing manufacturer are filled from m
+func resolveManufacturer(q querier, m Manufacturer) (int, error) {
+	// records without a manufacturer name share one unnamed manufacturer per province
+	id, err := findManufacturerId(q, m.keys())
+	if err != nil {
+		return 0, err
+	}
+
+	if id == 0 {
+		result, err := q.Exec(`
+			INSERT INTO manufacturers (name_en, name_fr, prov_code, website) VALUES (?, ?, ?, ?)
+		`, nullString(m.NameEn), nullString(m.NameFr), nullString(m.ProvCode), nullString(m.WebSite))
+		if err != nil {
+			return 0, err
+		}
+		newId, _ := result.LastInsertId()
+		id = int(newId)
+	} else {
+		_, err := q.Exec(`
+			UPDATE manufacturers SET
+			name_en = COALESCE(NULLIF(name_en, ''), NULLIF(?, '')),
+			name_fr = COALESCE(NULLIF(name_fr, ''), NULLIF(?, '')),
+			website = COALESCE(NULLIF(website, ''), NULLIF(?, ''))
+			WHERE id = ?
+		`, m.NameEn, m.NameFr, m.WebSite, id)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// ignore names already used by another manufacturer, the first match wins
+	for _, key := range m.keys() {
+		q.Exec(`INSERT OR IGNORE INTO manufacturer_aliases (alias_key, manufacturer_id) VALUES (?, ?)`, key, id)
+	}
+
+	return id, nil
+}
+
+// function to read the bilingual manufacturers from the data file
+func loadManufacturers(filePath string) []Manufacturer {
+	var ms []Manufacturer
+
+	// Load lines from CSV
+	lines, err := getLinesFromCSV(filePath)
+	check(err)
+
+	// get rid of column names
+	indexes := csvHeaderIndexes(lines[0])
+	for _, line := range lines[1:] {
+		ms = append(ms, Manufacturer {
+			NameEn: strings.TrimSpace(csvValue(line, indexes, "ManufacturerNameEn")),
+			NameFr: strings.TrimSpace(csvValue(line, indexes, "ManufacturerNameFr")),
+			ProvCode: strings.TrimSpace(csvValue(line, indexes, "ManufacturerProvCode")),
+			WebSite: getFirstNonEmptyString(csvValue(line, indexes, "WebSiteEn"), csvValue(line, indexes, "WebSiteFr")).String,
+		})
+	}
+
+	return ms
+}
+
+// function to add the manufacturers of the data file to the database, keeping existing names
+func importManufacturers(database *sql.DB, ms []Manufacturer) {
+	tx, err := database.Begin()
+	check(err)
+
+	for _, m := range ms {
+		_, err = resolveManufacturer(tx, m)
+		if err != nil {
+			tx.Rollback()
+			log.Fatal(err)
+		}
+	}
+
+	check(tx.Commit())
+}
+
+// function to select all manufacturers with their number of cheeses
+func getManufacturers(database *sql.DB) []Manufacturer {
+	var ms []Manufacturer
+
+	// prepare select
+	statement, _ := database.Prepare(`
+		SELECT m.id, COALESCE(m.name_en, ''), COALESCE(m.name_fr, ''), COALESCE(m.prov_code, ''),
+		COALESCE(m.website, ''), COUNT(c.id)
+		FROM manufacturers m LEFT JOIN cheeses c ON c.manufacturer_id = m.id
+		GROUP BY m.id ORDER BY m.prov_code, COALESCE(NULLIF(m.name_en, ''), m.name_fr)
+	`)
+	// query select
+	rows, _ := statement.Query()
+	defer rows.Close()
+
+	// loop through resultset
+	for rows.Next() {
+		var m Manufacturer
+		err := rows.Scan(&m.Id, &m.NameEn, &m.NameFr, &m.ProvCode, &m.WebSite, &m.NumCheeses)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ms = append(ms, m)
+	}
+
+	return ms
+}
+
+// function to select a manufacturer by id
+func getManufacturerById(database *sql.DB, id int) (Manufacturer, error) {
+	for _, m := range getManufacturers(database) {
+		if m.Id == id {
+			return m, nil
+		}
+	}
+	return Manufacturer{}, fmt.Errorf("no manufacturer #%d", id)
+}
+
+// function to select the cheeses of one manufacturer
+func getCheesesByManufacturer(database *sql.DB, id int) []Record {
+	return queryRecords(database, []Filter{{Column: "manufacturer_id", Value: strconv.Itoa(id)}}, "")
+}
+
+// function to rename a manufacturer, its previous names stay known so reloading the data file keeps it
+func updateManufacturer(database *sql.DB, m Manufacturer) error {
+	if strings.TrimSpace(m.NameEn) == "" && strings.TrimSpace(m.NameFr) == "" {
+		return errors.New("a manufacturer needs an English or French name")
+	}
+
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`
+		UPDATE manufacturers SET name_en = ?, name_fr = ?, prov_code = ?, website = ? WHERE id = ?
+	`, m.NameEn, m.NameFr, m.ProvCode, m.WebSite, m.Id)
+	if err == nil {
+		if n, _ := result.RowsAffected(); n == 0 {
+			err = fmt.Errorf("no manufacturer #%d", m.Id)
+		}
+	}
+	if err == nil {
+		err = addManufacturerAliases(tx, m.Id, m.keys())
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// function to merge a manufacturer into another, moving its cheeses and names
+func mergeManufacturers(database *sql.DB, keepId int, dropId int) error {
+	if keepId == dropId {
+		return errors.New("cannot merge a manufacturer with itself")
+	}
+	keep, err := getManufacturerById(database, keepId)
+	if err != nil {
+		return err
+	}
+	drop, err := getManufacturerById(database, dropId)
+	if err != nil {
+		return err
+	}
+
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+
+	// move the cheeses and names, and fill the kept manufacturer's blanks
+	_, err = tx.Exec(`UPDATE cheeses SET manufacturer_id = ? WHERE manufacturer_id = ?`, keep.Id, drop.Id)
+	if err == nil {
+		_, err = tx.Exec(`UPDATE manufacturer_aliases SET manufacturer_id = ? WHERE manufacturer_id = ?`, keep.Id, drop.Id)
+	}
+	if err == nil {
+		_, err = tx.Exec(`
+			UPDATE manufacturers SET
+			name_en = COALESCE(NULLIF(name_en, ''), ?), name_fr = COALESCE(NULLIF(name_fr, ''), ?),
+			website = COALESCE(NULLIF(website, ''), ?) WHERE id = ?
+		`, nullString(drop.NameEn), nullString(drop.NameFr), nullString(drop.WebSite), keep.Id)
+	}
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM manufacturers WHERE id = ?`, drop.Id)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// function to print manufacturers with their number of cheeses, optionally those whose name contains a text
+func printManufacturers(database *sql.DB, search string) {
+	rows := [][]string{{"#", "Prov", "Name (En)", "Name (Fr)", "Cheeses"}}
+
+	for _, m := range getManufacturers(database) {
+		if search != "" && !strings.Contains(normalizeName(m.NameEn + " " + m.NameFr), normalizeName(search)) {
+			continue
+		}
+		rows = append(rows, []string{strconv.Itoa(m.Id), m.ProvCode, m.NameEn, m.NameFr, strconv.Itoa(m.NumCheeses)})
+	}
+
+	writeAlignedTable(os.Stdout, rows)
+}
+
+// function to print the cheeses of a manufacturer
+func printManufacturerCheeses(database *sql.DB, id int) error {
+	m, err := getManufacturerById(database, id)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\n%s (%s), %d cheeses:\n", m.Name(), m.ProvCode, m.NumCheeses)
+	for _, r := range getCheesesByManufacturer(database, id) {
+		fmt.Printf("%s %s\n", renderRecord(r), classificationSummary(r))
+	}
+	return nil
+}
+
+// helper function to read a manufacturer number from stdin
+func readManufacturerId(toRead string) int {
+	id := 0
+
+	for id <= 0 {
+		var err error
+		id, err = strconv.Atoi(strings.TrimPrefix(readRequiredString(toRead), "#"))
+		if err != nil {
+			id = 0
+			fmt.Println("\nPlease enter a valid integer.")
+		}
+	}
+
+	return id
+}
+
+// function to list, view, rename and merge manufacturers from the menu, returns whether any changed
+func manageManufacturers(database *sql.DB, user User) bool {
+	selection := 0
+
+	fmt.Printf("\nManufacturers...\n\n")
+	fmt.Println(" 1. List manufacturers with their number of cheeses")
+	fmt.Println(" 2. Display all cheeses of a manufacturer")
+	if hasRole(user, RoleEditor) {
+		fmt.Println(" 3. Rename a manufacturer")
+		fmt.Println(" 4. Merge two manufacturers")
+	}
+
+	// loop until selection is valid
+	for selection == 0 {
+		fmt.Printf("Please choose an option: ")
+
+		_, err := fmt.Scanf("%d", &selection)
+
+		if err != nil || selection < 1 || selection > 4 {
+			selection = 0
+			fmt.Println("\nPlease enter a valid option.")
+		} else if selection > 2 && !hasRole(user, RoleEditor) {
+			writeAuditLog(database, user.Username, "manufacturers", AuditDenied, "role "+user.Role)
+			fmt.Printf("\nYour role (%s) does not allow this option.\n", user.Role)
+			return false
+		}
+	}
+
+	var err error
+
+	switch selection {
+		case 1:
+			printManufacturers(database, readNewOrKeepDefaultString("text to search in names (Enter for all)", ""))
+		case 2:
+			err = printManufacturerCheeses(database, readManufacturerId("manufacturer #"))
+		case 3:
+			var m Manufacturer
+			m, err = getManufacturerById(database, readManufacturerId("manufacturer #"))
+			if err != nil {
+				break
+			}
+			fmt.Printf("\n Press Enter to keep the same value, otherwise input your value...\n")
+			m.NameEn = readNewOrKeepDefaultString("English name", m.NameEn)
+			m.NameFr = readNewOrKeepDefaultString("French name", m.NameFr)
+			m.ProvCode = readNewOrKeepDefaultString("Prov Code", m.ProvCode)
+			m.WebSite = readNewOrKeepDefaultString("Website", m.WebSite)
+			err = updateManufacturer(database, m)
+			if err == nil {
+				writeAuditLog(database, user.Username, "rename manufacturer", AuditAllowed, fmt.Sprintf("#%d to %s", m.Id, m.Name()))
+				fmt.Printf("\n Renamed manufacturer #%d to %s.\n", m.Id, m.Name())
+				return true
+			}
+		case 4:
+			keepId := readManufacturerId("# of the manufacturer to keep")
+			dropId := readManufacturerId("# of the manufacturer to merge into it")
+			err = mergeManufacturers(database, keepId, dropId)
+			if err == nil {
+				writeAuditLog(database, user.Username, "merge manufacturers", AuditAllowed, fmt.Sprintf("#%d into #%d", dropId, keepId))
+				fmt.Printf("\n Merged manufacturer #%d into #%d.\n", dropId, keepId)
+				return true
+			}
+	}
+
+	if err != nil {
+		fmt.Printf("\nError: %v\n", err)
+	}
+	return false
+}
+
+// function to run the "manufacturers" command
+func manufacturersCommand(database *sql.DB, user User, args []string) error {
+	flags := flag.NewFlagSet("manufacturers", flag.ContinueOnError)
+	search := flags.String("search", "", "only list manufacturers whose name contains this text")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	printManufacturers(database, *search)
+	return nil
+}
+
+// helper function to parse a manufacturer number argument
+func parseManufacturerId(arg string) (int, error) {
+	id, err := strconv.Atoi(strings.TrimPrefix(arg, "#"))
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("invalid manufacturer number %q", arg)
+	}
+	return id, nil
+}
+
+// function to run the "manufacturer-cheeses" command
+func manufacturerCheesesCommand(database *sql.DB, user User, args []string) error {
+	if len(args) != 1 {
+		return errors.New("expected a manufacturer number")
+	}
+	id, err := parseManufacturerId(args[0])
+	if err != nil {
+		return err
+	}
+	return printManufacturerCheeses(database, id)
+}
+
+// function to run the "manufacturer-rename" command
+func manufacturerRenameCommand(database *sql.DB, user User, args []string) error {
+	flags := flag.NewFlagSet("manufacturer-rename", flag.ContinueOnError)
+	nameEn := flags.String("en", "", "new English name")
+	nameFr := flags.String("fr", "", "new French name")
+	provCode := flags.String("prov", "", "new province code")
+	website := flags.String("website", "", "new website")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("expected a manufacturer number")
+	}
+	id, err := parseManufacturerId(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	m, err := getManufacturerById(database, id)
+	if err != nil {
+		return err
+	}
+
+	// only the given flags change
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+			case "en":
+				m.NameEn = *nameEn
+			case "fr":
+				m.NameFr = *nameFr
+			case "prov":
+				m.ProvCode = *provCode
+			case "website":
+				m.WebSite = *website
+		}
+	})
+
+	if err = updateManufacturer(database, m); err != nil {
+		return err
+	}
+	fmt.Printf("Renamed manufacturer #%d to %s.\n", m.Id, m.Name())
+	return nil
+}
+
+// function to run the "manufacturer-merge" command
+func manufacturerMergeCommand(database *sql.DB, user User, args []string) error {
+	if len(args) < 2 {
+		return errors.New("expected the manufacturer number to keep followed by the ones to merge into it")
+	}
+	keepId, err := parseManufacturerId(args[0])
+	if err != nil {
+		return err
+	}
+
+	for _, arg := range args[1:] {
+		dropId, err := parseManufacturerId(arg)
+		if err != nil {
+			return err
+		}
+		if err = mergeManufacturers(database, keepId, dropId); err != nil {
+			return err
+		}
+		fmt.Printf("Merged manufacturer #%d into #%d.\n", dropId, keepId)
+	}
+	return nil
+}
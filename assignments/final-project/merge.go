@@ -0,0 +1,405 @@
+// CST8333 Cheese Directory App - Merge Import of Upstream Releases - Lucas Estienne
+
+package main
+
+import (
+	"fmt"
+	"database/sql"
+	"errors"
+	"flag"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// kinds of changes a merge makes to the local records
+const (
+	MergeAdd = "add"
+	MergeUpdate = "update"
+	MergeDelete = "delete"
+	MergeRestore = "restore"
+)
+
+// ways conflicts are resolved, asking for each one or keeping one side for all
+const (
+	ResolveAsk = "ask"
+	ResolveLocal = "local"
+	ResolveUpstream = "upstream"
+)
+
+var resolveChoices = []string { ResolveAsk, ResolveLocal, ResolveUpstream }
+
+// simple data structure containing a change a merge makes to the local records, conflicts are only applied when
+// resolved in favour of upstream, Field is empty for changes of whole records
+type MergeChange struct {
+	CheeseId int
+	CheeseName string
+	Kind string
+	Field string
+	Local string
+	Upstream string
+	Conflict bool
+	Apply bool
+}
+
+// function to create the table keeping the last imported upstream release, the base of three-way merges
+func migrateUpstreamSnapshot(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE upstream_imports (
+			id INTEGER PRIMARY KEY,
+			file_path TEXT NOT NULL,
+			imported_at TEXT NOT NULL
+		)`,
+		`CREATE TABLE upstream_snapshot (
+			cheese_id INTEGER NOT NULL,
+			field TEXT NOT NULL,
+			value TEXT NOT NULL,
+			PRIMARY KEY (cheese_id, field)
+		)`,
+	)
+}
+
+// function to replace the upstream snapshot with the records of an imported release, one record per CheeseId
+func saveUpstreamSnapshot(database *sql.DB, filePath string, upstream []Record) error {
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = execAll(tx, `DELETE FROM upstream_snapshot`)
+	if err == nil {
+		_, err = tx.Exec(`INSERT INTO upstream_imports (file_path, imported_at) VALUES (?, ?)`,
+			filePath, time.Now().UTC().Format(time.RFC3339))
+	}
+	if err != nil {
+		return err
+	}
+
+	statement, err := tx.Prepare(`INSERT INTO upstream_snapshot (cheese_id, field, value) VALUES (?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	byId, ids, _ := recordsByCheeseId(upstream)
+	for _, id := range ids {
+		for _, f := range fieldsWhere(func(f Field) bool { return f.Exportable }) {
+			if _, err = statement.Exec(id, f.Name, exportField(byId[id], f)); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// function to load the upstream snapshot as records, with the file and date of the import, ok is false when there is none
+func loadUpstreamSnapshot(database *sql.DB) ([]Record, string, bool) {
+	var filePath, importedAt string
+
+	err := database.QueryRow(`SELECT file_path, imported_at FROM upstream_imports ORDER BY id DESC LIMIT 1`).Scan(&filePath, &importedAt)
+	if err == sql.ErrNoRows {
+		return nil, "", false
+	}
+	check(err)
+
+	rows, err := database.Query(`SELECT cheese_id, field, value FROM upstream_snapshot ORDER BY cheese_id`)
+	check(err)
+	defer rows.Close()
+
+	fields := map[string]Field{}
+	for _, f := range recordFields {
+		fields[f.Name] = f
+	}
+
+	var records []Record
+	for rows.Next() {
+		var (
+			cheeseId int
+			name, value string
+		)
+		if err = rows.Scan(&cheeseId, &name, &value); err != nil {
+			log.Fatal(err)
+		}
+		if len(records) == 0 || records[len(records) - 1].CheeseId != cheeseId {
+			records = append(records, Record{CheeseId: cheeseId})
+		}
+		if f, ok := fields[name]; ok {
+			parseField(&records[len(records) - 1], f, value)
+		}
+	}
+
+	return records, fmt.Sprintf("%s imported %s", filePath, strings.SplitN(importedAt, "T", 2)[0]), true
+}
+
+// helper function to merge the fields of a cheese changed upstream, a field changed on one side only takes that
+// side's value, a field changed differently on both sides is a conflict, except the last update date which keeps the latest
+func mergeFields(base Record, upstream Record, local Record) []MergeChange {
+	var changes []MergeChange
+	for _, f := range fieldsWhere(func(f Field) bool { return f.Exportable }) {
+		b, u, l := exportField(base, f), exportField(upstream, f), exportField(local, f)
+		if u == b || u == l {
+			continue
+		}
+
+		c := MergeChange{CheeseId: local.CheeseId, CheeseName: local.CheeseName.String, Kind: MergeUpdate, Field: f.Name, Local: l, Upstream: u}
+		switch {
+			case l == b:
+				c.Apply = true
+			case f.Type == FieldDate:
+				c.Apply = u > l
+			default:
+				c.Conflict = true
+		}
+		if c.Apply || c.Conflict {
+			changes = append(changes, c)
+		}
+	}
+	return changes
+}
+
+// function to plan a three-way merge of the new upstream release into the local records, against the last imported release
+func planMerge(base []Record, upstream []Record, local []Record) []MergeChange {
+	var changes []MergeChange
+
+	baseById, baseIds, _ := recordsByCheeseId(base)
+	upstreamById, upstreamIds, _ := recordsByCheeseId(upstream)
+	localById, _, _ := recordsByCheeseId(local)
+
+	ids := append(append([]int{}, baseIds...), upstreamIds...)
+	sort.Ints(ids)
+
+	for i, id := range ids {
+		if i > 0 && ids[i - 1] == id {
+			continue
+		}
+		b, inBase := baseById[id]
+		u, inUpstream := upstreamById[id]
+		l, inLocal := localById[id]
+
+		switch {
+			// new upstream, or created on both sides and merged field by field
+			case inUpstream && !inBase && !inLocal:
+				changes = append(changes, MergeChange{CheeseId: id, CheeseName: u.CheeseName.String, Kind: MergeAdd, Apply: true})
+			case inUpstream && !inBase:
+				changes = append(changes, mergeFields(Record{CheeseId: id}, u, l)...)
+
+			// removed upstream, a conflict when edited locally
+			case !inUpstream && inLocal:
+				edited := len(compareFields(b, l)) > 0
+				c := MergeChange{CheeseId: id, CheeseName: l.CheeseName.String, Kind: MergeDelete, Conflict: edited, Apply: !edited}
+				if edited {
+					c.Local, c.Upstream = "edited", "removed"
+				}
+				changes = append(changes, c)
+			case !inUpstream:
+
+			// deleted locally, a conflict when edited upstream
+			case !inLocal:
+				if len(compareFields(b, u)) > 0 {
+					changes = append(changes, MergeChange{CheeseId: id, CheeseName: u.CheeseName.String, Kind: MergeRestore,
+						Local: "deleted", Upstream: "edited", Conflict: true})
+				}
+
+			default:
+				changes = append(changes, mergeFields(b, u, l)...)
+		}
+	}
+
+	return changes
+}
+
+// function to apply the changes of a merge to the local records, every record of a CheeseId is updated or deleted
+func applyMerge(local []Record, upstream []Record, changes []MergeChange) []Record {
+	local = append([]Record{}, local...)
+	upstreamById, _, _ := recordsByCheeseId(upstream)
+	fields := map[string]Field{}
+	for _, f := range recordFields {
+		fields[f.Name] = f
+	}
+
+	deleted := map[int]bool{}
+	for _, c := range changes {
+		if !c.Apply {
+			continue
+		}
+		switch c.Kind {
+			case MergeAdd, MergeRestore:
+				local = append(local, upstreamById[c.CheeseId])
+			case MergeDelete:
+				deleted[c.CheeseId] = true
+			case MergeUpdate:
+				for i := range local {
+					if local[i].CheeseId == c.CheeseId {
+						parseField(&local[i], fields[c.Field], c.Upstream)
+					}
+				}
+		}
+	}
+
+	var merged []Record
+	for _, r := range local {
+		if !deleted[r.CheeseId] {
+			merged = append(merged, r)
+		}
+	}
+	return merged
+}
+
+// helper function to describe a change of a merge
+func describeMergeChange(c MergeChange) string {
+	if c.Field != "" {
+		return fmt.Sprintf("%d %s, %s: local %q, upstream %q", c.CheeseId, c.CheeseName, c.Field, c.Local, c.Upstream)
+	}
+	if c.Conflict {
+		return fmt.Sprintf("%d %s: %s locally, %s upstream", c.CheeseId, c.CheeseName, c.Local, c.Upstream)
+	}
+	return fmt.Sprintf("%d %s", c.CheeseId, c.CheeseName)
+}
+
+// function to resolve the conflicts of a merge, asking for each one or keeping one side for all
+func resolveConflicts(changes []MergeChange, resolve string) {
+	for i := range changes {
+		if !changes[i].Conflict {
+			continue
+		}
+		switch resolve {
+			case ResolveUpstream:
+				changes[i].Apply = true
+			case ResolveAsk:
+				fmt.Printf("\n Conflict: %s\n", describeMergeChange(changes[i]))
+				answer := readNewOrKeepDefaultString("keep (l)ocal or take (u)pstream", "l")
+				changes[i].Apply = strings.HasPrefix(strings.ToLower(answer), "u")
+		}
+	}
+}
+
+// function to print the changes of a merge, the automatic ones counted by kind and the conflicts with their resolution
+func printMerge(changes []MergeChange, dryRun bool) {
+	counts := map[string]int{}
+	updated := map[int]bool{}
+	var conflicts []MergeChange
+	for _, c := range changes {
+		if c.Conflict {
+			conflicts = append(conflicts, c)
+			continue
+		}
+		counts[c.Kind]++
+		if c.Kind == MergeUpdate {
+			updated[c.CheeseId] = true
+		}
+	}
+
+	verb := "Merged"
+	if dryRun {
+		verb = "Would merge"
+	}
+	fmt.Printf("\n%s: %d added, %d updated fields in %d cheeses, %d deleted\n", verb,
+		counts[MergeAdd], counts[MergeUpdate], len(updated), counts[MergeDelete])
+
+	fmt.Printf("\n Conflicts: %d\n", len(conflicts))
+	for _, c := range conflicts {
+		kept := "kept local"
+		switch {
+			case dryRun:
+				kept = "unresolved"
+			case c.Apply:
+				kept = "took upstream"
+		}
+		fmt.Printf("   %s (%s)\n", describeMergeChange(c), kept)
+	}
+}
+
+// function to merge a new upstream release into the database, keeping local creates, edits and deletes,
+// the base is the last imported release unless a base file is given
+func mergeImport(database *sql.DB, filePath string, basePath string, resolve string, dryRun bool) ([]Record, error) {
+	if !stringInSlice(resolve, resolveChoices) {
+		return nil, fmt.Errorf("unknown resolution %q, expected one of %v", resolve, resolveChoices)
+	}
+
+	upstream, err := loadRecordsFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var base []Record
+	if basePath != "" {
+		if base, err = loadRecordsFile(basePath); err != nil {
+			return nil, err
+		}
+		base, _ = canonicalizeRecords(loadVocabulary(database), base)
+		fmt.Printf("\nMerging %s against %s\n", filePath, basePath)
+	} else {
+		var description string
+		var ok bool
+		if base, description, ok = loadUpstreamSnapshot(database); !ok {
+			return nil, errors.New("no release was imported yet, give the release the database was imported from as base")
+		}
+		fmt.Printf("\nMerging %s against %s\n", filePath, description)
+	}
+
+	// upstream values are mapped onto the vocabularies like an import
+	upstream, unmapped := canonicalizeRecords(loadVocabulary(database), upstream)
+	printUnmappedValues(unmapped)
+
+	local := getAllCheeses(database)
+	changes := planMerge(base, upstream, local)
+	if !dryRun {
+		resolveConflicts(changes, resolve)
+	}
+	printMerge(changes, dryRun)
+	if dryRun {
+		return local, nil
+	}
+
+	importManufacturers(database, loadManufacturers(filePath))
+	merged := applyMerge(local, upstream, changes)
+	syncDb(merged, database)
+	return merged, saveUpstreamSnapshot(database, filePath, upstream)
+}
+
+// function to merge a new release from the menu, returning the merged records
+func displayMergeImport(database *sql.DB, records []Record) []Record {
+	filePath := readNewOrKeepDefaultString("data file of the new release", findDataFile(DataFilePath))
+	// databases imported before snapshots were saved need the release they were imported from
+	basePath := ""
+	if _, _, ok := loadUpstreamSnapshot(database); !ok {
+		fmt.Println("\nNo release was imported since snapshots were kept.")
+		basePath = readRequiredString("data file the database was imported from")
+	}
+	readEncoding()
+
+	merged, err := mergeImport(database, filePath, basePath, ResolveAsk, false)
+	if err != nil {
+		fmt.Printf("\nError: %v\n", err)
+		return records
+	}
+	return merged
+}
+
+// function to run the "merge-import" command
+func mergeImportCommand(database *sql.DB, user User, args []string) error {
+	flags := flag.NewFlagSet("merge-import", flag.ContinueOnError)
+	basePath := flags.String("base", "", "data file of the last imported release, the saved snapshot when empty")
+	resolve := flags.String("resolve", ResolveAsk, fmt.Sprintf("conflict resolution %v", resolveChoices))
+	dryRun := flags.Bool("dry-run", false, "list the changes and conflicts without merging")
+	encoding := encodingFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := setDataFileEncoding(*encoding); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("expected the data file of the new release")
+	}
+	// conflicts are asked about on stdin, which then holds the release
+	if (flags.Arg(0) == StdinPath || *basePath == StdinPath) && *resolve == ResolveAsk && !*dryRun {
+		return fmt.Errorf("the release is read from stdin, choose -resolve %s or %s", ResolveLocal, ResolveUpstream)
+	}
+
+	_, err := mergeImport(database, flags.Arg(0), *basePath, *resolve, *dryRun)
+	return err
+}
+
@@ -0,0 +1,151 @@
+// CST8333 Cheese Directory App - Database Migrations - Lucas Estienne
+
+package main
+
+import (
+	"fmt"
+	"database/sql"
+	"log"
+)
+
+// schema migrations, applied in order once each and tracked with PRAGMA user_version
+var migrations = []func(tx *sql.Tx) error {
+	migrateManufacturers,
+	migrateVocabularies,
+	migrateMilkTypes,
+	migrateRipening,
+	migrateUpdateDates,
+	migrateMissingValues,
+	migrateCustomAttributes,
+	migrateReviews,
+	migrateCollections,
+	migrateInventory,
+	migrateUpstreamSnapshot,
+	migrateRecordMerges,
+	migrateStockQuantities,
+	migrateVocabularyCodes,
+}
+
+// function to apply the migrations the database has not seen yet
+func runMigrations(database *sql.DB) {
+	var version int
+
+	err := database.QueryRow("PRAGMA user_version").Scan(&version)
+	check(err)
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := database.Begin()
+		check(err)
+
+		err = migrations[i](tx)
+		if err == nil {
+			_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i + 1))
+		}
+		if err != nil {
+			tx.Rollback()
+			log.Fatalf("Error applying database migration %d: %v", i + 1, err)
+		}
+
+		check(tx.Commit())
+	}
+}
+
+// helper function to execute several statements, stopping at the first error
+func execAll(tx *sql.Tx, statements ...string) error {
+	for _, s := range statements {
+		if _, err := tx.Exec(s); err != nil {
+			return fmt.Errorf("%v in %s", err, s)
+		}
+	}
+	return nil
+}
+
+// migration moving manufacturer names and provinces out of the cheeses table into a deduplicated manufacturers table
+func migrateManufacturers(tx *sql.Tx) error {
+	err := execAll(tx,
+		`CREATE TABLE manufacturers (
+			id INTEGER PRIMARY KEY,
+			name_en TEXT,
+			name_fr TEXT,
+			prov_code TEXT,
+			website TEXT
+		)`,
+		`CREATE TABLE manufacturer_aliases (
+			alias_key TEXT PRIMARY KEY,
+			manufacturer_id INTEGER NOT NULL REFERENCES manufacturers(id)
+		)`,
+		`ALTER TABLE cheeses ADD COLUMN manufacturer_id INTEGER REFERENCES manufacturers(id)`,
+	)
+	if err != nil {
+		return err
+	}
+
+	// databases created since then never had the manufacturer columns
+	columns, err := tableColumns(tx, "cheeses")
+	if err != nil {
+		return err
+	}
+	if columns["manufacturer_name"] {
+		if err = moveManufacturerColumns(tx); err != nil {
+			return err
+		}
+	}
+
+	return execAll(tx,
+		`CREATE VIEW cheese_records AS
+			SELECT c.id, c.cheese_id, c.cheese_name,
+			COALESCE(NULLIF(m.name_en, ''), NULLIF(m.name_fr, ''), '') AS manufacturer_name,
+			COALESCE(m.prov_code, '') AS manufacturer_prov_code,
+			c.manufacturing_type, c.website, c.fat_content_percent, c.moisture_percent,
+			c.particularities, c.flavour, c.characteristics, c.ripening,
+			c.organic, c.category_type, c.milk_type, c.milk_treatment_type,
+			c.rind_type, c.last_update_date, c.manufacturer_id
+			FROM cheeses c LEFT JOIN manufacturers m ON m.id = c.manufacturer_id`,
+	)
+}
+
+// function to move the manufacturer names and provinces of the cheeses table into the manufacturers table
+func moveManufacturerColumns(tx *sql.Tx) error {
+	// existing names, most used spelling first so it becomes the manufacturer's name
+	rows, err := tx.Query(`
+		SELECT manufacturer_name, manufacturer_prov_code, MAX(CASE WHEN website != 'N/A' THEN website END), COUNT(*)
+		FROM cheeses GROUP BY manufacturer_name, manufacturer_prov_code ORDER BY COUNT(*) DESC
+	`)
+	if err != nil {
+		return err
+	}
+
+	var existing []Manufacturer
+	for rows.Next() {
+		var (
+			m Manufacturer
+			website sql.NullString
+		)
+		if err = rows.Scan(&m.NameEn, &m.ProvCode, &website, &m.NumCheeses); err != nil {
+			rows.Close()
+			return err
+		}
+		m.WebSite = website.String
+		existing = append(existing, m)
+	}
+	rows.Close()
+
+	// names differing only by case, accents, punctuation or spacing share the same manufacturer
+	for _, m := range existing {
+		id, err := resolveManufacturer(tx, m)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			UPDATE cheeses SET manufacturer_id = ? WHERE manufacturer_name = ? AND manufacturer_prov_code = ?
+		`, id, m.NameEn, m.ProvCode)
+		if err != nil {
+			return err
+		}
+	}
+
+	return execAll(tx,
+		`ALTER TABLE cheeses DROP COLUMN manufacturer_name`,
+		`ALTER TABLE cheeses DROP COLUMN manufacturer_prov_code`,
+	)
+}
@@ -0,0 +1,178 @@
+// CST8333 Cheese Directory App - Multi-valued Milk Types - Lucas Estienne
+
+package main
+
+import (
+	"database/sql"
+	"regexp"
+	"strings"
+)
+
+// filter columns matching records on their set of milk types, e.g. milk_type_contains=goat or milk_type_exactly=cow+ewe
+const (
+	MilkTypeContains = "milk_type_contains"
+	MilkTypeExactly = "milk_type_exactly"
+)
+
+// separators of combined milk types in English and French, e.g. "Cow, Goat and Ewe" or "Vache et chèvre"
+var milkTypeSeparator = regexp.MustCompile(`(?i)\s*(?:,|\+|&|/|\band\b|\bet\b)\s*`)
+
+// function to create the milk types join table and fill it from the existing records
+func migrateMilkTypes(tx *sql.Tx) error {
+	err := execAll(tx,
+		`CREATE TABLE cheese_milk_types (
+			record_id INTEGER NOT NULL REFERENCES cheeses(id),
+			milk_type TEXT NOT NULL,
+			position INTEGER NOT NULL,
+			PRIMARY KEY (record_id, milk_type)
+		)`,
+	)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`SELECT id, milk_type FROM cheeses`)
+	if err != nil {
+		return err
+	}
+
+	milkTypes := map[int64][]string{}
+	for rows.Next() {
+		var (
+			id int64
+			milkType sql.NullString
+		)
+		if err = rows.Scan(&id, &milkType); err != nil {
+			rows.Close()
+			return err
+		}
+		milkTypes[id] = splitMilkTypes(milkType.String)
+	}
+	rows.Close()
+
+	for id, types := range milkTypes {
+		if err = insertMilkTypes(tx, id, types); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// helper function to split a combined milk type into its parts, missing values have none
+func splitMilkTypes(value string) []string {
+	var types []string
+
+	if !nullString(value).Valid {
+		return nil
+	}
+	for _, part := range milkTypeSeparator.Split(value, -1) {
+		part = strings.TrimSpace(part)
+		if part != "" && !stringInSlice(part, types) {
+			types = append(types, part)
+		}
+	}
+	return types
+}
+
+// function to parse a milk type into the set of English labels of its parts,
+// returns the set and the parts that do not map onto a vocabulary term
+func parseMilkTypes(vocab Vocabulary, value string) ([]string, []string) {
+	var types, unknown []string
+
+	// combined values that are terms themselves, e.g. "Brebis et chèvre", are split from their English label
+	if t, ok := vocab.lookup("milk_type", value); ok {
+		value = t.LabelEn
+	}
+
+	for _, part := range splitMilkTypes(value) {
+		if t, ok := vocab.lookup("milk_type", part); ok {
+			part = t.LabelEn
+		} else {
+			unknown = append(unknown, part)
+		}
+		if !stringInSlice(part, types) {
+			types = append(types, part)
+		}
+	}
+	return types, unknown
+}
+
+// helper function to render a set of milk types the way the directory writes them, e.g. "Cow, Goat and Ewe",
+// an empty set is a missing value
+func renderMilkTypes(types []string) sql.NullString {
+	switch len(types) {
+		case 0:
+			return sql.NullString{}
+		case 1:
+			return nullString(types[0])
+	}
+	return nullString(strings.Join(types[:len(types) - 1], ", ") + " and " + types[len(types) - 1])
+}
+
+// function to get the codes of the set of milk types of a value, parts without a term keep their text
+func milkTypeCodes(vocab Vocabulary, value string) []string {
+	var codes []string
+
+	types, _ := parseMilkTypes(vocab, value)
+	for _, t := range types {
+		if code := vocab.code("milk_type", t); !stringInSlice(code, codes) {
+			codes = append(codes, code)
+		}
+	}
+	return codes
+}
+
+// helper function to build the SQL expression rendering the stored set of milk types of a record the way the
+// directory writes them, e.g. "Cow, Goat and Ewe", each code shown as the English label of its term
+func milkTypeLabelExpression() string {
+	label := vocabularyLabelExpression("milk_type", "mt.milk_type")
+	first := `(SELECT GROUP_CONCAT(label, ', ') FROM (
+		SELECT ` + label + ` AS label FROM cheese_milk_types mt
+		WHERE mt.record_id = c.id AND mt.position < (SELECT MAX(position) FROM cheese_milk_types WHERE record_id = c.id)
+		ORDER BY mt.position))`
+	last := `(SELECT ` + label + ` FROM cheese_milk_types mt WHERE mt.record_id = c.id ORDER BY mt.position DESC LIMIT 1)`
+	return `COALESCE(` + first + ` || ' and ' || ` + last + `, ` + last + `)`
+}
+
+// function to get the set of milk types of a record
+func (r Record) MilkTypes() []string {
+	return splitMilkTypes(r.MilkType.String)
+}
+
+// function to store the set of milk types of a record
+func insertMilkTypes(q querier, recordId int64, types []string) error {
+	for i, t := range types {
+		_, err := q.Exec(`
+			INSERT OR IGNORE INTO cheese_milk_types (record_id, milk_type, position) VALUES (?, ?, ?)
+		`, recordId, t, i)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// helper function to check if a filter column matches on the set of milk types
+func isMilkTypeSetColumn(column string) bool {
+	return column == MilkTypeContains || column == MilkTypeExactly
+}
+
+// helper function to build the conditions matching the set of milk types by their codes,
+// "contains" needs every listed milk type and "exactly" no other one
+func milkTypeSetConditions(f Filter) ([]string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	types := splitMilkTypes(f.Value)
+	for _, t := range types {
+		conditions = append(conditions, `id IN (SELECT record_id FROM cheese_milk_types WHERE milk_type = ` +
+			vocabularyCodeExpression("milk_type") + `)`)
+		args = append(args, normalizeName(t), t)
+	}
+
+	if f.Column == MilkTypeExactly {
+		conditions = append(conditions, `(SELECT COUNT(*) FROM cheese_milk_types WHERE record_id = id) = ?`)
+		args = append(args, len(types))
+	}
+	return conditions, args
+}
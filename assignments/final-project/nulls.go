@@ -0,0 +1,122 @@
+// CST8333 Cheese Directory App - Missing Values - Lucas Estienne
+
+package main
+
+import (
+	"fmt"
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// how missing values are displayed, they are stored as NULL and exported as empty cells
+const MissingValue = "N/A"
+
+// helper function to convert text to a nullable string, blanks and N/A are missing
+func nullString(s string) sql.NullString {
+	s = strings.TrimSpace(s)
+	if s == "" || s == MissingValue {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: s, Valid: true}
+}
+
+// helper function to return the first of two non empty strings, or a missing value
+func getFirstNonEmptyString(first string, second string) sql.NullString {
+	if s := nullString(first); s.Valid {
+		return s
+	}
+	return nullString(second)
+}
+
+// helper function to parse a nullable number, blanks, N/A and text that is not a number are missing
+func parseNullFloat(s string) sql.NullFloat64 {
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return sql.NullFloat64{}
+	}
+	return sql.NullFloat64{Float64: f, Valid: true}
+}
+
+// helper function to display a nullable string
+func displayString(s sql.NullString) string {
+	if !s.Valid {
+		return MissingValue
+	}
+	return s.String
+}
+
+// helper function to display a nullable percentage
+func displayFloat(f sql.NullFloat64) string {
+	if !f.Valid {
+		return MissingValue
+	}
+	return fmt.Sprintf("%.2f", f.Float64)
+}
+
+// helper function to export a nullable percentage, missing values are empty
+func exportFloat(f sql.NullFloat64) string {
+	if !f.Valid {
+		return ""
+	}
+	return fmt.Sprintf("%.2f", f.Float64)
+}
+
+// function to render a record for display, missing values are shown the same way in every field
+func renderRecord(r Record) string {
+	var fields []string
+
+	for i, value := range recordToSlice(r) {
+		if value == "" {
+			value = MissingValue
+		}
+		fields = append(fields, recordHeaders[i] + ":" + value)
+	}
+	// custom attributes the record has
+	for _, name := range attributeNames(r.Attributes) {
+		fields = append(fields, name + ":" + r.Attributes[name])
+	}
+	return "{" + strings.Join(fields, " ") + "}"
+}
+
+// migration replacing the "N/A" and "??" placeholders and the zero numbers standing for blanks with NULL
+func migrateMissingValues(tx *sql.Tx) error {
+	var statements []string
+
+	for _, column := range []string{
+		"cheese_name", "manufacturing_type", "website", "particularities", "flavour", "characteristics",
+		"ripening", "category_type", "milk_type", "milk_treatment_type", "rind_type", "last_update_date",
+	} {
+		statements = append(statements, fmt.Sprintf(
+			`UPDATE cheeses SET %s = NULL WHERE TRIM(%s) IN ('', 'N/A')`, column, column))
+	}
+
+	// the data file has no real 0% values, older imports stored blanks as 0 and rounded through float32
+	for _, column := range []string{"fat_content_percent", "moisture_percent"} {
+		statements = append(statements, fmt.Sprintf(
+			`UPDATE cheeses SET %s = CASE WHEN %s = 0 THEN NULL ELSE ROUND(%s, 2) END`, column, column, column))
+	}
+
+	statements = append(statements,
+		`UPDATE manufacturers SET name_en = NULL WHERE TRIM(name_en) IN ('', 'N/A')`,
+		`UPDATE manufacturers SET name_fr = NULL WHERE TRIM(name_fr) IN ('', 'N/A')`,
+		`UPDATE manufacturers SET prov_code = NULL WHERE TRIM(prov_code) IN ('', '??')`,
+		`UPDATE manufacturers SET website = NULL WHERE TRIM(website) IN ('', 'N/A')`,
+		// keys of unknown provinces and of unnamed manufacturers are blank
+		`UPDATE manufacturer_aliases SET alias_key = SUBSTR(alias_key, 1, LENGTH(alias_key) - 2) WHERE alias_key LIKE '%|??'`,
+		`UPDATE manufacturer_aliases SET alias_key = SUBSTR(alias_key, 4) WHERE alias_key LIKE 'n a|%'`,
+		`DROP VIEW cheese_records`,
+		`CREATE VIEW cheese_records AS
+			SELECT c.id, c.cheese_id, c.cheese_name,
+			COALESCE(NULLIF(m.name_en, ''), NULLIF(m.name_fr, '')) AS manufacturer_name,
+			NULLIF(m.prov_code, '') AS manufacturer_prov_code,
+			c.manufacturing_type, c.website, c.fat_content_percent, c.moisture_percent,
+			c.particularities, c.flavour, c.characteristics, c.ripening,
+			c.organic, c.category_type, c.milk_type, c.milk_treatment_type,
+			c.rind_type, c.last_update_date, c.manufacturer_id,
+			c.ripening_min_days, c.ripening_max_days
+			FROM cheeses c LEFT JOIN manufacturers m ON m.id = c.manufacturer_id`,
+	)
+
+	return execAll(tx, statements...)
+}
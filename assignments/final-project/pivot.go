@@ -0,0 +1,363 @@
+// CST8333 Cheese Directory App - Pivot Reports - Lucas Estienne
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"database/sql"
+	"encoding/csv"
+	"flag"
+	"html"
+	"log"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// output formats for reports
+const (
+	FormatTable = "table"
+	FormatCSV = "csv"
+	FormatMarkdown = "markdown"
+	FormatHTML = "html"
+)
+
+var reportFormats = []string { FormatTable, FormatCSV, FormatMarkdown }
+
+// simple data structure containing one cell of a pivot table
+type PivotCell struct {
+	Count int
+	Sum float64
+	NumValues int
+}
+
+// simple data structure containing a cross-tabulation of two columns
+type PivotTable struct {
+	RowColumn string
+	ColColumn string
+	AvgColumn string
+	RowKeys []string
+	ColKeys []string
+	Cells map[string]map[string]PivotCell
+	RowTotals map[string]PivotCell
+	ColTotals map[string]PivotCell
+	Total PivotCell
+}
+
+// helper function to add a cell's values to another
+func (c PivotCell) add(o PivotCell) PivotCell {
+	return PivotCell{c.Count + o.Count, c.Sum + o.Sum, c.NumValues + o.NumValues}
+}
+
+// helper function to return the average of the numeric column in a cell
+func (c PivotCell) Average() float64 {
+	if c.NumValues == 0 {
+		return 0
+	}
+	return c.Sum / float64(c.NumValues)
+}
+
+// function to cross-tabulate two columns, optionally averaging a numeric column (missing values are skipped)
+func buildPivotTable(database *sql.DB, rowColumn string, colColumn string, avgColumn string, filters []Filter) PivotTable {
+	pt := PivotTable {
+		RowColumn: rowColumn,
+		ColColumn: colColumn,
+		AvgColumn: avgColumn,
+		Cells: map[string]map[string]PivotCell{},
+		RowTotals: map[string]PivotCell{},
+		ColTotals: map[string]PivotCell{},
+	}
+
+	avg := "NULL"
+	if avgColumn != "" {
+		// missing values are NULL and left out of the average
+		avg = avgColumn
+	}
+	where, args := buildWhereClause(filters)
+
+	// prepare select
+	statement, _ := database.Prepare(fmt.Sprintf(`
+		SELECT COALESCE(CAST(%s AS TEXT), ''), COALESCE(CAST(%s AS TEXT), ''),
+		COUNT(*), COALESCE(SUM(%s), 0), COUNT(%s) FROM cheese_records%s GROUP BY 1, 2
+	`, columnExpression(rowColumn), columnExpression(colColumn), avg, avg, where))
+	// query select
+	rows, _ := statement.Query(args...)
+	defer rows.Close()
+
+	// loop through resultset
+	for rows.Next() {
+		var (
+			r string
+			c string
+			cell PivotCell
+		)
+		err := rows.Scan(&r, &c, &cell.Count, &cell.Sum, &cell.NumValues)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		r, c = countValueLabel(rowColumn, r), countValueLabel(colColumn, c)
+		if _, ok := pt.Cells[r]; !ok {
+			pt.Cells[r] = map[string]PivotCell{}
+			pt.RowKeys = append(pt.RowKeys, r)
+		}
+		if _, ok := pt.ColTotals[c]; !ok {
+			pt.ColKeys = append(pt.ColKeys, c)
+		}
+		pt.Cells[r][c] = cell
+		pt.RowTotals[r] = pt.RowTotals[r].add(cell)
+		pt.ColTotals[c] = pt.ColTotals[c].add(cell)
+		pt.Total = pt.Total.add(cell)
+	}
+
+	sort.Strings(pt.RowKeys)
+	sort.Strings(pt.ColKeys)
+
+	return pt
+}
+
+// helper function to format a pivot cell
+func formatPivotCell(pt PivotTable, cell PivotCell) string {
+	if cell.Count == 0 {
+		return ""
+	}
+	if pt.AvgColumn == "" {
+		return fmt.Sprintf("%d", cell.Count)
+	}
+	if cell.NumValues == 0 {
+		return fmt.Sprintf("%d (-)", cell.Count)
+	}
+	return fmt.Sprintf("%d (%.2f)", cell.Count, cell.Average())
+}
+
+// function to convert a pivot table to rows of text, including the header and totals
+func pivotTableToRows(pt PivotTable) [][]string {
+	var rows [][]string
+
+	corner := pt.RowColumn + " \\ " + pt.ColColumn
+	if pt.AvgColumn != "" {
+		corner += " (avg " + pt.AvgColumn + ")"
+	}
+	rows = append(rows, append(append([]string{corner}, pt.ColKeys...), "Total"))
+
+	for _, r := range pt.RowKeys {
+		row := []string{r}
+		for _, c := range pt.ColKeys {
+			row = append(row, formatPivotCell(pt, pt.Cells[r][c]))
+		}
+		rows = append(rows, append(row, formatPivotCell(pt, pt.RowTotals[r])))
+	}
+
+	totals := []string{"Total"}
+	for _, c := range pt.ColKeys {
+		totals = append(totals, formatPivotCell(pt, pt.ColTotals[c]))
+	}
+	rows = append(rows, append(totals, formatPivotCell(pt, pt.Total)))
+
+	return rows
+}
+
+// function to write rows as an aligned table, columns of numbers right aligned and the others left aligned
+func writeAlignedTable(w io.Writer, rows [][]string) {
+	var (
+		widths []int
+		numeric []bool
+	)
+
+	for n, row := range rows {
+		for i, v := range row {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+				numeric = append(numeric, i > 0)
+			}
+			if l := utf8.RuneCountInString(v); l > widths[i] {
+				widths[i] = l
+			}
+			// the header row does not count, numbers start with a digit or a sign
+			if n > 0 && v != "" && !strings.ContainsAny(v[:1], "0123456789-+") {
+				numeric[i] = false
+			}
+		}
+	}
+
+	for _, row := range rows {
+		var cells []string
+		for i, v := range row {
+			pad := strings.Repeat(" ", widths[i] - utf8.RuneCountInString(v))
+			if numeric[i] {
+				cells = append(cells, pad + v)
+			} else {
+				cells = append(cells, v + pad)
+			}
+		}
+		fmt.Fprintln(w, strings.TrimRight(strings.Join(cells, "  "), " "))
+	}
+}
+
+// function to write rows as a Markdown table, the first row being the header
+func writeMarkdownTable(w io.Writer, rows [][]string) {
+	escape := func(row []string) string {
+		var cells []string
+		for _, v := range row {
+			cells = append(cells, strings.ReplaceAll(v, "|", "\\|"))
+		}
+		return "| " + strings.Join(cells, " | ") + " |"
+	}
+
+	for i, row := range rows {
+		fmt.Fprintln(w, escape(row))
+		if i == 0 {
+			separator := []string{"---"}
+			for j := 1; j < len(row); j++ {
+				separator = append(separator, "---:")
+			}
+			fmt.Fprintln(w, "| " + strings.Join(separator, " | ") + " |")
+		}
+	}
+}
+
+// function to write rows as an HTML table, the first row being the header, highlighted rows get the class "differs"
+func writeHTMLTable(w io.Writer, rows [][]string, highlighted map[int]bool) {
+	fmt.Fprintln(w, "<table>")
+	for i, row := range rows {
+		tag, class := "td", ""
+		if i == 0 {
+			tag = "th"
+		}
+		if highlighted[i] {
+			class = ` class="differs"`
+		}
+
+		var cells []string
+		for _, v := range row {
+			cells = append(cells, "<" + tag + ">" + html.EscapeString(v) + "</" + tag + ">")
+		}
+		fmt.Fprintf(w, "  <tr%s>%s</tr>\n", class, strings.Join(cells, ""))
+	}
+	fmt.Fprintln(w, "</table>")
+}
+
+// function to write rows in one of the report formats
+func writeReportRows(w io.Writer, rows [][]string, format string) error {
+	switch format {
+		case FormatTable:
+			writeAlignedTable(w, rows)
+		case FormatCSV:
+			writer := csv.NewWriter(w)
+			writer.WriteAll(rows)
+			return writer.Error()
+		case FormatMarkdown:
+			writeMarkdownTable(w, rows)
+		default:
+			return fmt.Errorf("unknown format %q, expected one of %v", format, reportFormats)
+	}
+	return nil
+}
+
+// helper function to list the numeric columns that can be averaged
+func numericColumns() []string {
+	var columns []string
+	for _, c := range statisticsNumericColumns {
+		columns = append(columns, c.Column)
+	}
+	return columns
+}
+
+// helper function to read one column from a list, or "" when empty is allowed and nothing is entered
+func readColumnChoice(toRead string, columns []string, allowEmpty bool) string {
+	c := ""
+
+	for c == "" {
+		fmt.Printf("\n Please pick the %s from the following columns", toRead)
+		if allowEmpty {
+			fmt.Printf(" (press Enter for none)")
+		}
+		fmt.Printf(":\n%v: ", columns)
+
+		c = strings.TrimSpace(readNewOrKeepDefaultString("column", ""))
+		if c == "" && allowEmpty {
+			return ""
+		} else if !stringInSlice(c, columns) {
+			c = ""
+			fmt.Printf("\nPlease enter a valid (from the list) column name.\n")
+		}
+	}
+
+	return c
+}
+
+// function to build and output a pivot table from the menu
+func displayPivotTable(database *sql.DB) {
+	fmt.Printf("\nCross-tabulation report...\n")
+
+	rowColumn := readColumnChoice("row column", filterColumns(), false)
+	colColumn := readColumnChoice("column column", filterColumns(), false)
+	avgColumn := readColumnChoice("numeric column to average", numericColumns(), true)
+
+	format := ""
+	for !stringInSlice(format, reportFormats) {
+		format = readNewOrKeepDefaultString(fmt.Sprintf("format %v", reportFormats), FormatTable)
+	}
+
+	filePath := ""
+	if format != FormatTable {
+		filePath = readNewOrKeepDefaultString("output file (Enter for the screen)", "")
+	}
+
+	err := outputPivotTable(buildPivotTable(database, rowColumn, colColumn, avgColumn, nil), format, filePath)
+	if err != nil {
+		fmt.Printf("\nError: %v\n", err)
+	}
+}
+
+// function to output a pivot table to a file, or to the screen when the path is empty
+func outputPivotTable(pt PivotTable, format string, filePath string) error {
+	w, closeOutput, err := createOutput(filePath)
+	if err != nil {
+		return err
+	}
+	defer closeOutput()
+
+	// exported files start with their header
+	if filePath == "" {
+		fmt.Fprintln(w)
+	}
+	err = writeReportRows(w, pivotTableToRows(pt), format)
+	if err == nil && filePath != "" {
+		fmt.Printf("\n Done writing to %s.\n", filePath)
+	}
+	return err
+}
+
+// function to run the "pivot" command
+func pivotCommand(database *sql.DB, user User, args []string) error {
+	flags := flag.NewFlagSet("pivot", flag.ContinueOnError)
+	avgColumn := flags.String("avg", "", fmt.Sprintf("numeric column to average %v", numericColumns()))
+	format := flags.String("format", FormatTable, fmt.Sprintf("output format %v", reportFormats))
+	filePath := flags.String("out", "", "output file, the screen when empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() < 2 {
+		return fmt.Errorf("expected a row column and a column column from %v", filterColumns())
+	}
+	rowColumn, colColumn := flags.Arg(0), flags.Arg(1)
+	if !stringInSlice(rowColumn, filterColumns()) || !stringInSlice(colColumn, filterColumns()) {
+		return fmt.Errorf("pivot columns must be one of %v", filterColumns())
+	}
+	if *avgColumn != "" && !stringInSlice(*avgColumn, numericColumns()) {
+		return fmt.Errorf("average column must be one of %v", numericColumns())
+	}
+	if !stringInSlice(*format, reportFormats) {
+		return fmt.Errorf("unknown format %q, expected one of %v", *format, reportFormats)
+	}
+
+	filters, err := parseFilterArgs(flags.Args()[2:])
+	if err != nil {
+		return err
+	}
+
+	return outputPivotTable(buildPivotTable(database, rowColumn, colColumn, *avgColumn, filters), *format, *filePath)
+}
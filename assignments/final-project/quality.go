@@ -0,0 +1,280 @@
+// CST8333 Cheese Directory App - Data Quality Audit - Lucas Estienne
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"database/sql"
+	"encoding/csv"
+	"flag"
+	"strconv"
+	"strings"
+)
+
+// kinds of data quality issues
+const (
+	IssueMissingTranslation = "missing translation"
+	IssueImplausibleValue = "implausible value"
+	IssueDuplicateId = "duplicate id"
+	IssueWhitespace = "suspicious whitespace"
+)
+
+var issueKinds = []string { IssueMissingTranslation, IssueImplausibleValue, IssueDuplicateId, IssueWhitespace }
+
+// numeric percentage columns checked for plausible values
+var qualityNumericColumns = []string { "FatContentPercent", "MoisturePercent" }
+
+// simple data structure containing how complete a column is
+type FieldCompleteness struct {
+	Field string
+	Filled int
+	Percent float64
+}
+
+// simple data structure containing one data quality issue
+type QualityIssue struct {
+	CheeseId string
+	Kind string
+	Field string
+	Detail string
+}
+
+// simple data structure containing the data quality report
+type QualityReport struct {
+	Source string
+	NumRecords int
+	Completeness []FieldCompleteness
+	Issues []QualityIssue
+}
+
+// helper function to check if a value counts as missing
+func isMissingValue(value string) bool {
+	v := strings.TrimSpace(value)
+	return v == "" || v == MissingValue
+}
+
+// helper function to check a value for leading/trailing, repeated or control whitespace
+func hasSuspiciousWhitespace(value string) bool {
+	return value != strings.TrimSpace(value) || strings.Contains(value, "  ") ||
+		strings.ContainsAny(value, "\t\r\n\u00a0")
+}
+
+// helper function to find the index of a header, -1 when absent
+func headerIndex(headers []string, name string) int {
+	if i, ok := csvHeaderIndexes(headers)[name]; ok {
+		return i
+	}
+	return -1
+}
+
+// function to audit a table of data, the first line is the header and the first column the CheeseId
+func buildQualityReport(source string, lines [][]string) QualityReport {
+	report := QualityReport{Source: source}
+
+	if len(lines) == 0 {
+		return report
+	}
+	headers, rows := lines[0], lines[1:]
+	if len(headers) > 0 {
+		// spreadsheet exports may start with a byte order mark
+		headers = append([]string{strings.TrimPrefix(headers[0], byteOrderMark)}, headers[1:]...)
+	}
+	report.NumRecords = len(rows)
+
+	// completeness of every column, zero is missing for numeric columns
+	for i, h := range headers {
+		filled := 0
+		for _, row := range rows {
+			if i >= len(row) || isMissingValue(row[i]) {
+				continue
+			}
+			if stringInSlice(h, qualityNumericColumns) {
+				if v, err := strconv.ParseFloat(row[i], 64); err == nil && v == 0 {
+					continue
+				}
+			}
+			filled++
+		}
+		fc := FieldCompleteness{Field: h, Filled: filled}
+		if len(rows) > 0 {
+			fc.Percent = float64(filled) * 100 / float64(len(rows))
+		}
+		report.Completeness = append(report.Completeness, fc)
+	}
+
+	seen := map[string]int{}
+	for _, row := range rows {
+		id := row[0]
+		seen[id]++
+		if seen[id] == 2 {
+			report.Issues = append(report.Issues, QualityIssue{id, IssueDuplicateId, headers[0], "CheeseId appears more than once"})
+		}
+
+		for i, h := range headers {
+			if i >= len(row) {
+				break
+			}
+
+			// bilingual columns come in "...En"/"...Fr" pairs, one side filled and the other not is a missing translation
+			if strings.HasSuffix(h, "En") {
+				if j := headerIndex(headers, strings.TrimSuffix(h, "En") + "Fr"); j >= 0 && j < len(row) {
+					if isMissingValue(row[i]) && !isMissingValue(row[j]) {
+						report.Issues = append(report.Issues, QualityIssue{id, IssueMissingTranslation, h, "only " + headers[j] + " is filled"})
+					} else if !isMissingValue(row[i]) && isMissingValue(row[j]) {
+						report.Issues = append(report.Issues, QualityIssue{id, IssueMissingTranslation, headers[j], "only " + h + " is filled"})
+					}
+				}
+			}
+
+			if row[i] != "" && hasSuspiciousWhitespace(row[i]) {
+				report.Issues = append(report.Issues, QualityIssue{id, IssueWhitespace, h, strconv.Quote(row[i])})
+			}
+		}
+
+		// implausible percentages
+		var percents []float64
+		for _, c := range qualityNumericColumns {
+			i := headerIndex(headers, c)
+			if i < 0 || i >= len(row) || isMissingValue(row[i]) {
+				continue
+			}
+			v, err := strconv.ParseFloat(strings.TrimSpace(row[i]), 64)
+			if err != nil {
+				report.Issues = append(report.Issues, QualityIssue{id, IssueImplausibleValue, c, strconv.Quote(row[i]) + " is not a number"})
+				continue
+			}
+			if v <= 0 || v > 100 {
+				report.Issues = append(report.Issues, QualityIssue{id, IssueImplausibleValue, c, fmt.Sprintf("%g%%", v)})
+			}
+			percents = append(percents, v)
+		}
+		if len(percents) == 2 && percents[0] + percents[1] > 100 {
+			report.Issues = append(report.Issues, QualityIssue{id, IssueImplausibleValue, strings.Join(qualityNumericColumns, "+"),
+				fmt.Sprintf("%g%% + %g%% is over 100%%", percents[0], percents[1])})
+		}
+	}
+
+	return report
+}
+
+// function to audit the records in the database
+func buildDatabaseQualityReport(database *sql.DB) QualityReport {
+	lines := [][]string{recordHeaders}
+	for _, r := range getAllCheeses(database) {
+		lines = append(lines, recordToSlice(r))
+	}
+	return buildQualityReport("database", lines)
+}
+
+// function to print the data quality report, listing at most limit issues of each kind
+func printQualityReport(report QualityReport, limit int) {
+	fmt.Printf("\nData quality report for %s (%d records)\n\n", report.Source, report.NumRecords)
+
+	rows := [][]string{{"Field", "Filled", "Complete"}}
+	for _, fc := range report.Completeness {
+		rows = append(rows, []string{fc.Field, fmt.Sprintf("%d", fc.Filled), fmt.Sprintf("%.1f%%", fc.Percent)})
+	}
+	writeAlignedTable(os.Stdout, rows)
+
+	for _, kind := range issueKinds {
+		var issues []QualityIssue
+		for _, i := range report.Issues {
+			if i.Kind == kind {
+				issues = append(issues, i)
+			}
+		}
+
+		fmt.Printf("\n %s: %d\n", kind, len(issues))
+		for n, i := range issues {
+			if n == limit {
+				fmt.Printf("   ... and %d more (export the report to see all of them)\n", len(issues) - limit)
+				break
+			}
+			fmt.Printf("   CheeseId %-6s %-25s %s\n", i.CheeseId, i.Field, i.Detail)
+		}
+	}
+}
+
+// function to export the offending CheeseIds of the report to a CSV file
+func exportQualityIssues(report QualityReport, filePath string) error {
+	w, closeOutput, err := createOutput(filePath)
+	if err != nil {
+		return err
+	}
+	defer closeOutput()
+
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"CheeseId", "Issue", "Field", "Detail"})
+	for _, i := range report.Issues {
+		writer.Write([]string{i.CheeseId, i.Kind, i.Field, i.Detail})
+	}
+	writer.Flush()
+	if err = writer.Error(); err != nil {
+		return err
+	}
+
+	fmt.Printf("\n Wrote %d issues to %s.\n", len(report.Issues), filePath)
+	return nil
+}
+
+// function to display the data quality report from the menu
+func displayQualityReport(database *sql.DB) {
+	var report QualityReport
+
+	fmt.Printf("\nData quality audit...\n\n")
+
+	// only the source file has both languages, the database keeps one of them
+	if strings.HasPrefix(strings.ToLower(readNewOrKeepDefaultString("audit the database instead of the source file (y/n)", "n")), "y") {
+		report = buildDatabaseQualityReport(database)
+	} else {
+		dataFile := findDataFile(DataFilePath)
+		lines, err := getLinesFromCSV(dataFile)
+		check(err)
+		report = buildQualityReport(dataFile, lines)
+	}
+
+	printQualityReport(report, 20)
+
+	filePath := readNewOrKeepDefaultString("file to export the offending CheeseIds to (Enter to skip)", "")
+	if filePath != "" {
+		if err := exportQualityIssues(report, filePath); err != nil {
+			fmt.Printf("\nError: %v\n", err)
+		}
+	}
+}
+
+// function to run the "quality" command
+func qualityCommand(database *sql.DB, user User, args []string) error {
+	var report QualityReport
+
+	flags := flag.NewFlagSet("quality", flag.ContinueOnError)
+	fromDb := flags.Bool("db", false, "audit the database instead of the source file")
+	filePath := flags.String("file", findDataFile(DataFilePath), "source CSV file to audit, compressed or \"-\" for stdin")
+	limit := flags.Int("limit", 20, "maximum issues of each kind to list")
+	exportPath := flags.String("export", "", "CSV file to export the offending CheeseIds to")
+	encoding := encodingFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := setDataFileEncoding(*encoding); err != nil {
+		return err
+	}
+
+	if *fromDb {
+		report = buildDatabaseQualityReport(database)
+	} else {
+		lines, err := getLinesFromCSV(*filePath)
+		if err != nil {
+			return err
+		}
+		report = buildQualityReport(*filePath, lines)
+	}
+
+	printQualityReport(report, *limit)
+
+	if *exportPath != "" {
+		return exportQualityIssues(report, *exportPath)
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+// CST8333 Cheese Directory App - Tasting Reviews - Lucas Estienne
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"database/sql"
+	"errors"
+	"flag"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sort column ordering records by average rating, cheeses without reviews last
+const SortRating = "rating"
+
+// lowest and highest ratings
+const (
+	MinRating = 1
+	MaxRating = 5
+)
+
+// simple data structure containing a tasting review, linked to its cheese by CheeseId
+type Review struct {
+	Id int
+	CheeseId int
+	Username string
+	Rating int
+	Notes sql.NullString
+	CreatedAt string
+}
+
+// function to create the reviews table
+func migrateReviews(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE reviews (
+			id INTEGER PRIMARY KEY,
+			cheese_id INTEGER NOT NULL,
+			username TEXT NOT NULL,
+			rating INTEGER NOT NULL CHECK (rating BETWEEN 1 AND 5),
+			notes TEXT,
+			created_at TEXT NOT NULL
+		)`,
+		`CREATE INDEX reviews_cheese_id ON reviews (cheese_id)`,
+	)
+}
+
+// function to add a review of a cheese in the directory
+func addReview(database *sql.DB, r Review) error {
+	if r.Rating < MinRating || r.Rating > MaxRating {
+		return fmt.Errorf("invalid rating %d, expected %d to %d", r.Rating, MinRating, MaxRating)
+	}
+	if err := checkCheeseExists(database, r.CheeseId); err != nil {
+		return err
+	}
+
+	_, err := database.Exec(`
+		INSERT INTO reviews (cheese_id, username, rating, notes, created_at) VALUES (?, ?, ?, ?, ?)
+	`, r.CheeseId, r.Username, r.Rating, r.Notes, time.Now().UTC().Format(time.RFC3339))
+	return err
+}
+
+// function to select the reviews of a cheese, of all cheeses when cheeseId is 0, newest first
+func getReviews(database *sql.DB, cheeseId int) []Review {
+	var reviews []Review
+
+	rows, err := database.Query(`
+		SELECT id, cheese_id, username, rating, notes, created_at FROM reviews
+		WHERE ? = 0 OR cheese_id = ? ORDER BY created_at DESC, id DESC
+	`, cheeseId, cheeseId)
+	check(err)
+	defer rows.Close()
+
+	for rows.Next() {
+		var r Review
+		if err = rows.Scan(&r.Id, &r.CheeseId, &r.Username, &r.Rating, &r.Notes, &r.CreatedAt); err != nil {
+			log.Fatal(err)
+		}
+		reviews = append(reviews, r)
+	}
+
+	return reviews
+}
+
+// function to delete a review, only admins may delete the reviews of other users
+func deleteReview(database *sql.DB, user User, id int) error {
+	var author string
+
+	err := database.QueryRow(`SELECT username FROM reviews WHERE id = ?`, id).Scan(&author)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("no review #%d", id)
+	}
+	if err != nil {
+		return err
+	}
+	if author != user.Username && !hasRole(user, RoleAdmin) {
+		return fmt.Errorf("review #%d was written by %s, only admins may delete it", id, author)
+	}
+
+	_, err = database.Exec(`DELETE FROM reviews WHERE id = ?`, id)
+	return err
+}
+
+// helper function to describe the average rating of a record
+func describeRating(r Record) string {
+	if r.ReviewCount == 0 {
+		return "no reviews"
+	}
+	if r.ReviewCount == 1 {
+		return fmt.Sprintf("rated %.1f/5 from 1 review", r.AverageRating.Float64)
+	}
+	return fmt.Sprintf("rated %.1f/5 from %d reviews", r.AverageRating.Float64, r.ReviewCount)
+}
+
+// helper function to display the average rating of a record in a table column
+func ratingCell(r Record) string {
+	if r.ReviewCount == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.1f (%d)", r.AverageRating.Float64, r.ReviewCount)
+}
+
+// function to print reviews as a table
+func printReviews(reviews []Review) {
+	rows := [][]string{{"Review", "CheeseId", "Rating", "By", "Date", "Notes"}}
+	for _, r := range reviews {
+		rows = append(rows, []string{
+			strconv.Itoa(r.Id), strconv.Itoa(r.CheeseId), strings.Repeat("*", r.Rating), r.Username,
+			strings.SplitN(r.CreatedAt, "T", 2)[0], displayString(r.Notes),
+		})
+	}
+
+	writeAlignedTable(os.Stdout, rows)
+	fmt.Printf("\n%d reviews\n", len(reviews))
+}
+
+// helper function to read a rating from stdin, asking again until it is valid
+func readRating() int {
+	for {
+		rating, err := strconv.Atoi(readRequiredString(fmt.Sprintf("rating (%d-%d)", MinRating, MaxRating)))
+		if err == nil && rating >= MinRating && rating <= MaxRating {
+			return rating
+		}
+		fmt.Printf("\nPlease enter a whole number between %d and %d.\n", MinRating, MaxRating)
+	}
+}
+
+// function to list, add and delete reviews from the menu
+func manageReviews(database *sql.DB, user User) {
+	selection := 0
+
+	fmt.Printf("\nTasting reviews...\n\n")
+	fmt.Println(" 1. List the reviews of a cheese")
+	if hasRole(user, RoleEditor) {
+		fmt.Println(" 2. Review a cheese")
+		fmt.Println(" 3. Delete a review")
+	}
+
+	// loop until selection is valid
+	for selection == 0 {
+		fmt.Printf("Please choose an option: ")
+
+		_, err := fmt.Scanf("%d", &selection)
+
+		if err != nil || selection < 1 || selection > 3 {
+			selection = 0
+			fmt.Println("\nPlease enter a valid option.")
+		} else if selection > 1 && !hasRole(user, RoleEditor) {
+			writeAuditLog(database, user.Username, "reviews", AuditDenied, "role "+user.Role)
+			fmt.Printf("\nYour role (%s) does not allow this option.\n", user.Role)
+			return
+		}
+	}
+
+	var err error
+
+	switch selection {
+		case 1:
+			cheeseId, _ := strconv.Atoi(readNewOrKeepDefaultString("CheeseId (0 for all cheeses)", "0"))
+			printReviews(getReviews(database, cheeseId))
+		case 2:
+			r := Review{Username: user.Username}
+			r.CheeseId, err = strconv.Atoi(readRequiredString("CheeseId"))
+			if err != nil {
+				break
+			}
+			r.Rating = readRating()
+			r.Notes = nullString(readString("tasting notes"))
+			err = addReview(database, r)
+			if err == nil {
+				writeAuditLog(database, user.Username, "add review", AuditAllowed, fmt.Sprintf("CheeseId %d: %d", r.CheeseId, r.Rating))
+			}
+		case 3:
+			var id int
+			id, err = strconv.Atoi(readRequiredString("# of the review"))
+			if err != nil {
+				break
+			}
+			err = deleteReview(database, user, id)
+			if err == nil {
+				writeAuditLog(database, user.Username, "delete review", AuditAllowed, fmt.Sprintf("review %d", id))
+			}
+	}
+
+	if err != nil {
+		fmt.Printf("\nError: %v\n", err)
+	}
+}
+
+// function to run the "reviews" command
+func reviewsCommand(database *sql.DB, user User, args []string) error {
+	cheeseId := 0
+	if len(args) > 0 {
+		var err error
+		if cheeseId, err = strconv.Atoi(args[0]); err != nil {
+			return fmt.Errorf("invalid CheeseId %q", args[0])
+		}
+	}
+
+	printReviews(getReviews(database, cheeseId))
+	return nil
+}
+
+// function to run the "review-add" command
+func reviewAddCommand(database *sql.DB, user User, args []string) error {
+	flags := flag.NewFlagSet("review-add", flag.ContinueOnError)
+	notes := flags.String("notes", "", "tasting notes")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return errors.New("expected a CheeseId and a rating")
+	}
+
+	cheeseId, err := strconv.Atoi(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("invalid CheeseId %q", flags.Arg(0))
+	}
+	rating, err := strconv.Atoi(flags.Arg(1))
+	if err != nil {
+		return fmt.Errorf("invalid rating %q, expected %d to %d", flags.Arg(1), MinRating, MaxRating)
+	}
+
+	return addReview(database, Review{CheeseId: cheeseId, Username: user.Username, Rating: rating, Notes: nullString(*notes)})
+}
+
+// function to run the "review-delete" command
+func reviewDeleteCommand(database *sql.DB, user User, args []string) error {
+	if len(args) != 1 {
+		return errors.New("expected the # of the review")
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid review # %q", args[0])
+	}
+	return deleteReview(database, user, id)
+}
@@ -0,0 +1,229 @@
+// CST8333 Cheese Directory App - Ripening Periods - Lucas Estienne
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"database/sql"
+	"errors"
+	"flag"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// filter columns matching records on their ripening period, e.g. aged_at_least="6 months"
+const (
+	AgedAtLeast = "aged_at_least"
+	AgedAtMost = "aged_at_most"
+)
+
+// sort column ordering records by ripening period, unknown periods last
+const SortRipening = "ripening_days"
+
+// number of days in each English and French unit of a ripening period
+var ripeningUnitDays = map[string]int64 {
+	"day": 1, "days": 1, "jour": 1, "jours": 1,
+	"week": 7, "weeks": 7, "semaine": 7, "semaines": 7,
+	"month": 30, "months": 30, "mois": 30,
+	"year": 365, "years": 365, "an": 365, "ans": 365, "annee": 365, "annees": 365,
+}
+
+// a number or a range of numbers followed by a unit, e.g. "9 months", "2 to 3 weeks", "2-4 mois", "30 à45 jours"
+var ripeningPeriodPattern = regexp.MustCompile(
+	`(\d+)\s*(?:(?:to|-|or|a|ou)\s*(\d+))?\s*(days?|jours?|weeks?|semaines?|months?|mois|years?|annees?|ans?)\b`)
+
+// ripening texts meaning the cheese is not ripened
+var unripenedPattern = regexp.MustCompile(
+	`^(unripened|unriped|none|non affine|non-affine|sans affinage|pas d'affinage|not required to ripen)\b`)
+
+// words making a period a lower or an upper bound
+var (
+	ripeningAtLeastPattern = regexp.MustCompile(`\b(minimum|min|at least|au moins|more than|plus de)\b`)
+	ripeningAtMostPattern = regexp.MustCompile(`\b(less than|moins de|maximum|max|at most|au plus)\b`)
+)
+
+// function to parse a ripening text into a minimum and maximum number of days,
+// the maximum is null for open-ended periods and both are null when the text is not understood
+func parseRipening(text string) (sql.NullInt64, sql.NullInt64) {
+	var min, max sql.NullInt64
+
+	s := strings.TrimSpace(accentReplacer.Replace(strings.ToLower(text)))
+
+	if unripenedPattern.MatchString(s) {
+		return sql.NullInt64{Int64: 0, Valid: true}, sql.NullInt64{Int64: 0, Valid: true}
+	}
+
+	m := ripeningPeriodPattern.FindStringSubmatch(s)
+	if m == nil {
+		return min, max
+	}
+
+	days := ripeningUnitDays[m[3]]
+	low, _ := strconv.ParseInt(m[1], 10, 64)
+	high := low
+	if m[2] != "" {
+		high, _ = strconv.ParseInt(m[2], 10, 64)
+	}
+	min = sql.NullInt64{Int64: low * days, Valid: true}
+	max = sql.NullInt64{Int64: high * days, Valid: true}
+
+	switch {
+		case ripeningAtMostPattern.MatchString(s):
+			min = sql.NullInt64{Int64: 0, Valid: true}
+		case ripeningAtLeastPattern.MatchString(s):
+			max = sql.NullInt64{}
+	}
+
+	return min, max
+}
+
+// function to get the ripening period of a record in days
+func (r Record) RipeningDays() (sql.NullInt64, sql.NullInt64) {
+	return parseRipening(r.Ripening.String)
+}
+
+// helper function to describe a ripening period in days
+func describeRipeningDays(min sql.NullInt64, max sql.NullInt64) string {
+	switch {
+		case !min.Valid:
+			return "unknown"
+		case !max.Valid:
+			return fmt.Sprintf("%d+ days", min.Int64)
+		case min.Int64 == max.Int64:
+			return fmt.Sprintf("%d days", min.Int64)
+	}
+	return fmt.Sprintf("%d-%d days", min.Int64, max.Int64)
+}
+
+// function to add the parsed ripening period columns and fill them from the ripening texts
+func migrateRipening(tx *sql.Tx) error {
+	err := execAll(tx,
+		`ALTER TABLE cheeses ADD COLUMN ripening_min_days INTEGER`,
+		`ALTER TABLE cheeses ADD COLUMN ripening_max_days INTEGER`,
+		`DROP VIEW cheese_records`,
+		`CREATE VIEW cheese_records AS
+			SELECT c.id, c.cheese_id, c.cheese_name,
+			COALESCE(NULLIF(m.name_en, ''), NULLIF(m.name_fr, ''), '') AS manufacturer_name,
+			COALESCE(m.prov_code, '') AS manufacturer_prov_code,
+			c.manufacturing_type, c.website, c.fat_content_percent, c.moisture_percent,
+			c.particularities, c.flavour, c.characteristics, c.ripening,
+			c.organic, c.category_type, c.milk_type, c.milk_treatment_type,
+			c.rind_type, c.last_update_date, c.manufacturer_id,
+			c.ripening_min_days, c.ripening_max_days
+			FROM cheeses c LEFT JOIN manufacturers m ON m.id = c.manufacturer_id`,
+	)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`SELECT DISTINCT ripening FROM cheeses`)
+	if err != nil {
+		return err
+	}
+
+	var texts []string
+	for rows.Next() {
+		var text sql.NullString
+		if err = rows.Scan(&text); err != nil {
+			rows.Close()
+			return err
+		}
+		texts = append(texts, text.String)
+	}
+	rows.Close()
+
+	for _, text := range texts {
+		min, max := parseRipening(text)
+		_, err = tx.Exec(`
+			UPDATE cheeses SET ripening_min_days = ?, ripening_max_days = ? WHERE ripening = ?
+		`, min, max, text)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// helper function to check if a filter column matches on the ripening period
+func isRipeningColumn(column string) bool {
+	return column == AgedAtLeast || column == AgedAtMost
+}
+
+// helper function to build the condition matching the ripening period, the value is a period such as "6 months"
+func ripeningCondition(f Filter) (string, []interface{}, error) {
+	min, max := parseRipening(f.Value)
+	if !min.Valid {
+		return "", nil, fmt.Errorf("invalid ripening period %q, expected e.g. \"6 months\" or \"2 ans\"", f.Value)
+	}
+
+	if f.Column == AgedAtLeast {
+		return "ripening_min_days >= ?", []interface{}{min.Int64}, nil
+	}
+	if !max.Valid {
+		max = min
+	}
+	// open-ended periods never match a maximum
+	return "ripening_max_days <= ?", []interface{}{max.Int64}, nil
+}
+
+// helper function to build the ORDER BY expression of a sort column
+func sortExpression(column string, descending bool) (string, error) {
+	direction := " ASC"
+	if descending {
+		direction = " DESC"
+	}
+
+	switch {
+		case column == "":
+			return "", nil
+		case column == SortRipening:
+			return "ripening_min_days" + direction + " NULLS LAST, ripening_max_days" + direction + " NULLS LAST, id ASC", nil
+		case column == SortRating:
+			return "average_rating" + direction + " NULLS LAST, review_count DESC, id ASC", nil
+		case stringInSlice(column, filterColumns()):
+			return columnExpression(column) + direction + ", id ASC", nil
+	}
+	return "", fmt.Errorf("unknown sort column %q, expected %s, %s or one of %v", column, SortRipening, SortRating, filterColumns())
+}
+
+// function to print records as a table with their ripening period, last update date and rating
+func printRecordTable(rs []Record) {
+	rows := [][]string{{"CheeseId", "CheeseName", "ManufacturerName", "Ripening", "Ripening days", "LastUpdateDate", "Rating"}}
+	for _, r := range rs {
+		rows = append(rows, []string{
+			strconv.Itoa(r.CheeseId), displayString(r.CheeseName), displayString(r.ManufacturerName), displayString(r.Ripening),
+			describeRipeningDays(r.RipeningDays()),
+			r.LastUpdateDate.String(), ratingCell(r),
+		})
+	}
+
+	writeAlignedTable(os.Stdout, rows)
+	fmt.Printf("\n%d records\n", len(rs))
+}
+
+// function to run the "search" command
+func searchCommand(database *sql.DB, user User, args []string) error {
+	flags := flag.NewFlagSet("search", flag.ContinueOnError)
+	sortColumn := flags.String("sort", "", "column to sort on, "+SortRipening+" for the ripening period, "+SortRating+" for the average rating")
+	descending := flags.Bool("desc", false, "sort in descending order")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	filters, err := parseFilterArgs(flags.Args())
+	if err != nil {
+		return err
+	}
+	if len(filters) == 0 {
+		return errors.New("expected at least one column=value filter")
+	}
+	orderBy, err := sortExpression(*sortColumn, *descending)
+	if err != nil {
+		return err
+	}
+
+	printRecordTable(queryRecords(database, filters, orderBy))
+	return nil
+}
@@ -0,0 +1,323 @@
+// CST8333 Cheese Directory App - Similar Cheeses - Lucas Estienne
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"database/sql"
+	"errors"
+	"flag"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// weights of each criterion in the similarity score, the text weight covers Flavour and Characteristics
+var similarityWeights = struct {
+	MilkType, MilkTreatment, Rind, Category, Fat, Moisture, Ripening, Text float64
+}{2, 1, 1, 1.5, 1, 1, 1, 2.5}
+
+// fat and moisture percentage points apart at which two cheeses are no longer similar
+const similarPercentRange = 15.0
+
+// number of shared flavour words given as explanation
+const similarTermsShown = 3
+
+// words too common in flavour texts to tell cheeses apart, in English and French
+var similarityStopWords = map[string]bool {
+	"and": true, "the": true, "with": true, "very": true, "its": true, "has": true, "that": true, "for": true,
+	"from": true, "this": true, "into": true, "slightly": true, "cheese": true, "taste": true, "flavour": true,
+	"les": true, "des": true, "une": true, "est": true, "avec": true, "pour": true, "par": true, "aux": true,
+	"dans": true, "qui": true, "son": true, "ses": true, "sur": true, "très": true, "fromage": true, "saveur": true,
+}
+
+// simple data structure containing a record similar to another, its score from 0 to 1 and the reasons why
+type SimilarCheese struct {
+	Record Record
+	Score float64
+	Reasons []string
+}
+
+// helper function to split a text into its lower case words, leaving out short and common words
+func similarityTerms(text string) []string {
+	var terms []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(c rune) bool { return !unicode.IsLetter(c) }) {
+		if len([]rune(w)) >= 3 && !similarityStopWords[w] {
+			terms = append(terms, w)
+		}
+	}
+	return terms
+}
+
+// function to build the TF-IDF vectors of the Flavour and Characteristics texts of records
+func textVectors(rs []Record) []map[string]float64 {
+	counts := make([]map[string]float64, len(rs))
+	documentFrequency := map[string]int{}
+
+	for i, r := range rs {
+		counts[i] = map[string]float64{}
+		for _, t := range similarityTerms(r.Flavour.String + " " + r.Characteristics.String) {
+			if counts[i][t] == 0 {
+				documentFrequency[t]++
+			}
+			counts[i][t]++
+		}
+	}
+
+	// frequent terms of a text count more, terms found in many records count less
+	for _, v := range counts {
+		for t, n := range v {
+			v[t] = n * math.Log(float64(len(rs)) / float64(documentFrequency[t]))
+		}
+	}
+	return counts
+}
+
+// helper function to get the cosine similarity of two vectors and their shared terms, most significant first
+func cosineSimilarity(a map[string]float64, b map[string]float64) (float64, []string) {
+	var dot, normA, normB float64
+	var shared []string
+
+	for t, w := range a {
+		normA += w * w
+		if wb, ok := b[t]; ok && w * wb > 0 {
+			dot += w * wb
+			shared = append(shared, t)
+		}
+	}
+	for _, w := range b {
+		normB += w * w
+	}
+	if dot == 0 {
+		return 0, nil
+	}
+
+	sort.Slice(shared, func(i, j int) bool {
+		wi, wj := a[shared[i]] * b[shared[i]], a[shared[j]] * b[shared[j]]
+		if wi != wj {
+			return wi > wj
+		}
+		return shared[i] < shared[j]
+	})
+	return dot / math.Sqrt(normA * normB), shared
+}
+
+// helper function to compare two texts case insensitively, missing values never match
+func sameValue(a sql.NullString, b sql.NullString) bool {
+	return a.Valid && b.Valid && strings.EqualFold(strings.TrimSpace(a.String), strings.TrimSpace(b.String))
+}
+
+// helper function to get the similarity of two percentages, 1 when equal down to 0 at similarPercentRange apart
+func percentSimilarity(a sql.NullFloat64, b sql.NullFloat64) float64 {
+	if !a.Valid || !b.Valid {
+		return 0
+	}
+	return math.Max(0, 1 - math.Abs(a.Float64 - b.Float64) / similarPercentRange)
+}
+
+// helper function to get the middle of the ripening period of a record in days
+func ripeningMidpoint(r Record) (float64, bool) {
+	min, max := r.RipeningDays()
+	if !min.Valid {
+		return 0, false
+	}
+	if !max.Valid {
+		return float64(min.Int64), true
+	}
+	return float64(min.Int64 + max.Int64) / 2, true
+}
+
+// helper function to get the similarity of two ripening periods, the ratio of the shorter to the longer
+func ripeningSimilarity(a Record, b Record) float64 {
+	da, okA := ripeningMidpoint(a)
+	db, okB := ripeningMidpoint(b)
+	switch {
+		case !okA || !okB:
+			return 0
+		case da == db:
+			return 1
+	}
+	return math.Min(da, db) / math.Max(da, db)
+}
+
+// helper function to get the similarity of two sets of milk types, the share of milk types in common
+func milkTypeSimilarity(a []string, b []string) (float64, []string) {
+	var shared []string
+	union := len(b)
+	for _, t := range a {
+		if stringInSlice(t, b) {
+			shared = append(shared, t)
+		} else {
+			union++
+		}
+	}
+	if union == 0 {
+		return 0, nil
+	}
+	return float64(len(shared)) / float64(union), shared
+}
+
+// function to score how similar a record is to a target record, explaining the attributes they share
+func scoreSimilarity(target Record, r Record, targetText map[string]float64, text map[string]float64) SimilarCheese {
+	w := similarityWeights
+	s := SimilarCheese{Record: r}
+	score := 0.0
+
+	if sim, shared := milkTypeSimilarity(target.MilkTypes(), r.MilkTypes()); sim > 0 {
+		score += w.MilkType * sim
+		s.Reasons = append(s.Reasons, "milk: " + strings.ToLower(renderMilkTypes(shared).String))
+	}
+	if sameValue(target.MilkTreatmentType, r.MilkTreatmentType) {
+		score += w.MilkTreatment
+		s.Reasons = append(s.Reasons, strings.ToLower(r.MilkTreatmentType.String))
+	}
+	if sameValue(target.RindType, r.RindType) {
+		score += w.Rind
+		s.Reasons = append(s.Reasons, "rind: " + strings.ToLower(r.RindType.String))
+	}
+	if sameValue(target.CategoryType, r.CategoryType) {
+		score += w.Category
+		s.Reasons = append(s.Reasons, "category: " + strings.ToLower(r.CategoryType.String))
+	}
+	if sim := percentSimilarity(target.FatContentPercent, r.FatContentPercent); sim > 0 {
+		score += w.Fat * sim
+		if sim >= 0.8 {
+			s.Reasons = append(s.Reasons, fmt.Sprintf("fat %g%% vs %g%%", r.FatContentPercent.Float64, target.FatContentPercent.Float64))
+		}
+	}
+	if sim := percentSimilarity(target.MoisturePercent, r.MoisturePercent); sim > 0 {
+		score += w.Moisture * sim
+		if sim >= 0.8 {
+			s.Reasons = append(s.Reasons, fmt.Sprintf("moisture %g%% vs %g%%", r.MoisturePercent.Float64, target.MoisturePercent.Float64))
+		}
+	}
+	if sim := ripeningSimilarity(target, r); sim > 0 {
+		score += w.Ripening * sim
+		if sim >= 0.75 {
+			s.Reasons = append(s.Reasons, "ripened " + describeRipeningDays(r.RipeningDays()))
+		}
+	}
+	if sim, shared := cosineSimilarity(targetText, text); sim > 0 {
+		score += w.Text * sim
+		if len(shared) > similarTermsShown {
+			shared = shared[:similarTermsShown]
+		}
+		s.Reasons = append(s.Reasons, "tastes " + strings.Join(shared, ", "))
+	}
+
+	s.Score = score / (w.MilkType + w.MilkTreatment + w.Rind + w.Category + w.Fat + w.Moisture + w.Ripening + w.Text)
+	return s
+}
+
+// function to find the records most similar to the target record, leaving out records of the same cheese
+func findSimilar(rs []Record, target Record, limit int) []SimilarCheese {
+	vectors := textVectors(append([]Record{target}, rs...))
+
+	var similar []SimilarCheese
+	for i, r := range rs {
+		if r.CheeseId == target.CheeseId {
+			continue
+		}
+		if s := scoreSimilarity(target, r, vectors[0], vectors[i + 1]); s.Score > 0 {
+			similar = append(similar, s)
+		}
+	}
+
+	sort.SliceStable(similar, func(i, j int) bool { return similar[i].Score > similar[j].Score })
+	if limit > 0 && len(similar) > limit {
+		similar = similar[:limit]
+	}
+	return similar
+}
+
+// function to find the record of a cheese by its CheeseId or its name, an exact name before a partial one
+func findCheese(rs []Record, query string) (Record, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return Record{}, errors.New("expected a CheeseId or a cheese name")
+	}
+
+	if cheeseId, err := strconv.Atoi(query); err == nil {
+		for _, r := range rs {
+			if r.CheeseId == cheeseId {
+				return r, nil
+			}
+		}
+		return Record{}, fmt.Errorf("no cheese with CheeseId %d", cheeseId)
+	}
+
+	var partial []Record
+	for _, r := range rs {
+		if strings.EqualFold(r.CheeseName.String, query) {
+			return r, nil
+		}
+		if strings.Contains(strings.ToLower(r.CheeseName.String), strings.ToLower(query)) {
+			partial = append(partial, r)
+		}
+	}
+	switch len(partial) {
+		case 0:
+			return Record{}, fmt.Errorf("no cheese named %q", query)
+		case 1:
+			return partial[0], nil
+	}
+
+	var names []string
+	for _, r := range partial {
+		names = append(names, fmt.Sprintf("%s (%d)", r.CheeseName.String, r.CheeseId))
+	}
+	return Record{}, fmt.Errorf("%q matches %d cheeses, use a CheeseId: %s", query, len(partial), strings.Join(names, "; "))
+}
+
+// function to print similar cheeses as a table with the reasons they are similar
+func printSimilar(target Record, similar []SimilarCheese) {
+	fmt.Printf("\nCheeses similar to %s (%d):\n\n", displayString(target.CheeseName), target.CheeseId)
+
+	rows := [][]string{{"#", "CheeseId", "CheeseName", "Score", "Shared attributes"}}
+	for i, s := range similar {
+		rows = append(rows, []string{
+			strconv.Itoa(i + 1), strconv.Itoa(s.Record.CheeseId), displayString(s.Record.CheeseName),
+			fmt.Sprintf("%.0f%%", s.Score * 100), strings.Join(s.Reasons, "; "),
+		})
+	}
+
+	writeAlignedTable(os.Stdout, rows)
+}
+
+// function to find similar cheeses from the menu
+func displaySimilar(database *sql.DB) {
+	rs := getAllCheeses(database)
+
+	target, err := findCheese(rs, readRequiredString("CheeseId or name of the cheese"))
+	if err != nil {
+		fmt.Printf("\nError: %v\n", err)
+		return
+	}
+	limit, err := strconv.Atoi(readNewOrKeepDefaultString("number of similar cheeses", "5"))
+	if err != nil {
+		limit = 5
+	}
+
+	printSimilar(target, findSimilar(rs, target, limit))
+}
+
+// function to run the "similar" command
+func similarCommand(database *sql.DB, user User, args []string) error {
+	flags := flag.NewFlagSet("similar", flag.ContinueOnError)
+	limit := flags.Int("n", 5, "number of similar cheeses")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	rs := getAllCheeses(database)
+	target, err := findCheese(rs, strings.Join(flags.Args(), " "))
+	if err != nil {
+		return err
+	}
+
+	printSimilar(target, findSimilar(rs, target, *limit))
+	return nil
+}
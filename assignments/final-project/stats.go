@@ -0,0 +1,212 @@
+// CST8333 Cheese Directory App - Statistics - Lucas Estienne
+
+package main
+
+import (
+	"fmt"
+	"database/sql"
+	"log"
+	"strings"
+)
+
+// simple data structure describing a column used in reports
+type ReportColumn struct {
+	Column string
+	Label string
+}
+
+// columns the statistics report counts records by
+var statisticsCountColumns = []ReportColumn {
+	{"manufacturer_prov_code", "Province"},
+	{"category_type", "Category"},
+	{"milk_type", "Milk type"},
+	{"milk_treatment_type", "Milk treatment"},
+	{"rind_type", "Rind type"},
+	{"organic", "Organic"},
+	{"firmness_class", "Firmness (MFFB)"},
+	{"fat_class", "Fat class (FDM)"},
+}
+
+// numeric columns the statistics report summarizes
+var statisticsNumericColumns = []ReportColumn {
+	{"fat_content_percent", "Fat content %"},
+	{"moisture_percent", "Moisture %"},
+}
+
+// simple data structure containing the number of records with a value
+type CountRow struct {
+	Value string
+	Count int
+}
+
+// simple data structure containing counts of records grouped by a column
+type CountGroup struct {
+	ReportColumn
+	Rows []CountRow
+}
+
+// simple data structure containing a summary of a numeric column
+type NumericSummary struct {
+	ReportColumn
+	Count int
+	Min float64
+	Mean float64
+	Median float64
+	Max float64
+}
+
+// simple data structure containing the statistics report
+type Statistics struct {
+	Filters []Filter
+	Total int
+	Counts []CountGroup
+	Numeric []NumericSummary
+}
+
+// function to count records grouped by a column
+func countByColumn(database *sql.DB, column string, filters []Filter) []CountRow {
+	var rs []CountRow
+
+	where, args := buildWhereClause(filters)
+	expression := columnExpression(column)
+
+	// prepare select
+	statement, _ := database.Prepare(fmt.Sprintf(`
+		SELECT COALESCE(CAST(%s AS TEXT), ''), COUNT(*) FROM cheese_records%s
+		GROUP BY 1 ORDER BY COUNT(*) DESC, 1 ASC
+	`, expression, where))
+	// query select
+	rows, _ := statement.Query(args...)
+	defer rows.Close()
+
+	// loop through resultset
+	for rows.Next() {
+		var r CountRow
+		err := rows.Scan(&r.Value, &r.Count)
+		if err != nil {
+			log.Fatal(err)
+		}
+		rs = append(rs, r)
+	}
+
+	return rs
+}
+
+// function to compute min/mean/median/max of a numeric column, missing values are skipped
+func summarizeColumn(database *sql.DB, column ReportColumn, filters []Filter) NumericSummary {
+	var (
+		min sql.NullFloat64
+		mean sql.NullFloat64
+		max sql.NullFloat64
+	)
+
+	summary := NumericSummary{ReportColumn: column}
+	where, args := buildWhereClause(filters, column.Column + " IS NOT NULL")
+
+	// prepare select
+	statement, _ := database.Prepare(fmt.Sprintf(`
+		SELECT COUNT(%s), MIN(%s), AVG(%s), MAX(%s) FROM cheese_records%s
+	`, column.Column, column.Column, column.Column, column.Column, where))
+	// query select
+	err := statement.QueryRow(args...).Scan(&summary.Count, &min, &mean, &max)
+	check(err)
+
+	if summary.Count == 0 {
+		return summary
+	}
+	summary.Min, summary.Mean, summary.Max = min.Float64, mean.Float64, max.Float64
+
+	// the median is the middle value, or the mean of the two middle values
+	statement, _ = database.Prepare(fmt.Sprintf(`
+		SELECT AVG(%s) FROM (SELECT %s FROM cheese_records%s ORDER BY %s LIMIT ? OFFSET ?)
+	`, column.Column, column.Column, where, column.Column))
+	err = statement.QueryRow(append(args, 2 - summary.Count % 2, (summary.Count - 1) / 2)...).Scan(&summary.Median)
+	check(err)
+
+	return summary
+}
+
+// function to compute the statistics report
+func getStatistics(database *sql.DB, filters []Filter) Statistics {
+	stats := Statistics{Filters: filters}
+
+	where, args := buildWhereClause(filters)
+	statement, _ := database.Prepare("SELECT COUNT(*) FROM cheese_records" + where)
+	err := statement.QueryRow(args...).Scan(&stats.Total)
+	check(err)
+
+	for _, c := range statisticsCountColumns {
+		stats.Counts = append(stats.Counts, CountGroup{c, countByColumn(database, c.Column, filters)})
+	}
+	for _, c := range statisticsNumericColumns {
+		stats.Numeric = append(stats.Numeric, summarizeColumn(database, c, filters))
+	}
+
+	return stats
+}
+
+// helper function to make a counted value readable
+func countValueLabel(column string, value string) string {
+	if column == "organic" {
+		if value == "1" {
+			return "Organic"
+		}
+		return "Not organic"
+	}
+	if strings.TrimSpace(value) == "" {
+		return "(blank)"
+	}
+	return value
+}
+
+// function to print the statistics report
+func printStatistics(stats Statistics) {
+	var conditions []string
+	for _, f := range stats.Filters {
+		conditions = append(conditions, f.Column + "=" + f.Value)
+	}
+
+	if len(conditions) > 0 {
+		fmt.Printf("\n%d records match %s\n", stats.Total, strings.Join(conditions, ", "))
+	} else {
+		fmt.Printf("\n%d records in the directory\n", stats.Total)
+	}
+
+	for _, g := range stats.Counts {
+		fmt.Printf("\n %s:\n", g.Label)
+		for _, r := range g.Rows {
+			fmt.Printf("   %-30s %6d\n", countValueLabel(g.Column, r.Value), r.Count)
+		}
+	}
+
+	fmt.Printf("\n %-15s %6s %8s %8s %8s %8s\n", "", "Count", "Min", "Mean", "Median", "Max")
+	for _, n := range stats.Numeric {
+		fmt.Printf(" %-15s %6d %8.2f %8.2f %8.2f %8.2f\n", n.Label, n.Count, n.Min, n.Mean, n.Median, n.Max)
+	}
+	fmt.Println(" (records with a missing value are not included in the numeric statistics)")
+}
+
+// function to display statistics from the menu, optionally filtered on one column
+func displayStatistics(database *sql.DB) {
+	var filters []Filter
+
+	fmt.Printf("\nDirectory statistics...\n\n")
+
+	if strings.HasPrefix(strings.ToLower(readNewOrKeepDefaultString("filter the statistics on a column (y/n)", "n")), "y") {
+		c, v := searchRecordHelper(attributeColumns(loadCustomAttributes(database)))
+		filters = append(filters, Filter{Column: c, Value: v})
+	}
+
+	printStatistics(getStatistics(database, filters))
+}
+
+// function to run the "stats" command
+func statsCommand(database *sql.DB, user User, args []string) error {
+	filters, err := parseFilterArgs(args)
+	if err != nil {
+		return err
+	}
+
+	printStatistics(getStatistics(database, filters))
+	return nil
+}
@@ -0,0 +1,603 @@
+// CST8333 Cheese Directory App - Controlled Vocabularies - Lucas Estienne
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"database/sql"
+	"errors"
+	"flag"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// categorical fields with a managed vocabulary
+var vocabularyFields = fieldsWhere(func(f Field) bool { return f.Vocabulary })
+
+// simple data structure containing a vocabulary term
+type VocabularyTerm struct {
+	Field string
+	Code string
+	LabelEn string
+	LabelFr string
+}
+
+// simple data structure containing the vocabularies of all categorical fields
+type Vocabulary struct {
+	Terms map[string][]VocabularyTerm
+	Aliases map[string]map[string]string
+}
+
+// simple data structure containing an imported value with no vocabulary term
+type UnmappedValue struct {
+	Field string
+	Value string
+	Count int
+}
+
+// function to create the vocabulary tables
+func migrateVocabularies(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE vocabulary_terms (
+			field TEXT NOT NULL,
+			code TEXT NOT NULL,
+			label_en TEXT NOT NULL,
+			label_fr TEXT,
+			PRIMARY KEY (field, code)
+		)`,
+		`CREATE TABLE vocabulary_aliases (
+			field TEXT NOT NULL,
+			alias TEXT NOT NULL,
+			code TEXT NOT NULL,
+			PRIMARY KEY (field, alias)
+		)`,
+	)
+}
+
+// helper function to get a pointer to the categorical field of a record stored in a column
+func recordVocabularyValue(r *Record, column string) *sql.NullString {
+	if f, ok := fieldByColumn(column); ok && f.Vocabulary {
+		return f.Value(r).(*sql.NullString)
+	}
+	return nil
+}
+
+// helper function to check if a column has a vocabulary
+func isVocabularyColumn(column string) bool {
+	for _, f := range vocabularyFields {
+		if f.Column == column {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function to build the code of a term from its English label
+func vocabularyCode(label string) string {
+	return strings.ReplaceAll(normalizeName(label), " ", "_")
+}
+
+// function to add a term with its English, French and code aliases, keeping existing terms
+func addVocabularyTerm(q querier, t VocabularyTerm) error {
+	if !isVocabularyColumn(t.Field) {
+		return fmt.Errorf("%q has no vocabulary, expected one of %v", t.Field, vocabularyColumns())
+	}
+	if strings.TrimSpace(t.Code) == "" || strings.TrimSpace(t.LabelEn) == "" {
+		return errors.New("a term needs a code and an English label")
+	}
+
+	_, err := q.Exec(`
+		INSERT OR IGNORE INTO vocabulary_terms (field, code, label_en, label_fr) VALUES (?, ?, ?, ?)
+	`, t.Field, t.Code, strings.TrimSpace(t.LabelEn), strings.TrimSpace(t.LabelFr))
+	if err != nil {
+		return err
+	}
+
+	for _, alias := range []string{t.Code, t.LabelEn, t.LabelFr} {
+		if strings.TrimSpace(alias) != "" {
+			_, err = q.Exec(`
+				INSERT OR IGNORE INTO vocabulary_aliases (field, alias, code) VALUES (?, ?, ?)
+			`, t.Field, normalizeName(alias), t.Code)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// function to map another spelling onto a term
+func addVocabularyAlias(q querier, field string, alias string, code string) error {
+	var n int
+
+	err := q.QueryRow(`SELECT COUNT(*) FROM vocabulary_terms WHERE field = ? AND code = ?`, field, code).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("no %s term with code %q", field, code)
+	}
+
+	_, err = q.Exec(`
+		INSERT OR REPLACE INTO vocabulary_aliases (field, alias, code) VALUES (?, ?, ?)
+	`, field, normalizeName(alias), code)
+	return err
+}
+
+// function to seed the vocabularies from the distinct English/French pairs of the data file,
+// only when no term exists yet so managed vocabularies are never overwritten
+func seedVocabularies(database *sql.DB, filePath string) {
+	var n int
+
+	err := database.QueryRow(`SELECT COUNT(*) FROM vocabulary_terms`).Scan(&n)
+	check(err)
+	if n > 0 {
+		return
+	}
+
+	// Load lines from CSV
+	lines, err := getLinesFromCSV(filePath)
+	check(err)
+
+	tx, err := database.Begin()
+	check(err)
+
+	// get rid of column names
+	indexes := csvHeaderIndexes(lines[0])
+	for _, line := range lines[1:] {
+		for _, f := range vocabularyFields {
+			en, fr := strings.TrimSpace(csvValue(line, indexes, f.HeaderEn)), strings.TrimSpace(csvValue(line, indexes, f.HeaderFr))
+			if en == "" || fr == "" {
+				continue
+			}
+			err = addVocabularyTerm(tx, VocabularyTerm{f.Column, vocabularyCode(en), en, fr})
+			if err != nil {
+				tx.Rollback()
+				log.Fatal(err)
+			}
+		}
+	}
+
+	check(tx.Commit())
+}
+
+// function to load the vocabularies from the database
+func loadVocabulary(database querier) Vocabulary {
+	vocab := Vocabulary{Terms: map[string][]VocabularyTerm{}, Aliases: map[string]map[string]string{}}
+
+	rows, err := database.Query(`
+		SELECT field, code, label_en, COALESCE(label_fr, '') FROM vocabulary_terms ORDER BY field, label_en
+	`)
+	check(err)
+	for rows.Next() {
+		var t VocabularyTerm
+		if err = rows.Scan(&t.Field, &t.Code, &t.LabelEn, &t.LabelFr); err != nil {
+			log.Fatal(err)
+		}
+		vocab.Terms[t.Field] = append(vocab.Terms[t.Field], t)
+	}
+	rows.Close()
+
+	rows, err = database.Query(`SELECT field, alias, code FROM vocabulary_aliases`)
+	check(err)
+	for rows.Next() {
+		var field, alias, code string
+		if err = rows.Scan(&field, &alias, &code); err != nil {
+			log.Fatal(err)
+		}
+		if vocab.Aliases[field] == nil {
+			vocab.Aliases[field] = map[string]string{}
+		}
+		vocab.Aliases[field][alias] = code
+	}
+	rows.Close()
+
+	return vocab
+}
+
+// function to find the term a value maps onto
+func (v Vocabulary) lookup(field string, value string) (VocabularyTerm, bool) {
+	code, ok := v.Aliases[field][normalizeName(value)]
+	if !ok {
+		return VocabularyTerm{}, false
+	}
+	for _, t := range v.Terms[field] {
+		if t.Code == code {
+			return t, true
+		}
+	}
+	return VocabularyTerm{}, false
+}
+
+// function to find the code of the term a value maps onto, its English label first so a relabelled term
+// keeps its code, values without a term are kept as they are
+func (v Vocabulary) code(field string, value string) string {
+	for _, t := range v.Terms[field] {
+		if t.LabelEn == value {
+			return t.Code
+		}
+	}
+	if t, ok := v.lookup(field, value); ok {
+		return t.Code
+	}
+	return value
+}
+
+// function to get a record the way the cheeses table stores it, categorical values are the codes of their
+// terms and the cheese_records view renders them as labels, e.g. "cow+goat" for "Cow and Goat"
+func (v Vocabulary) storedRecord(r Record) Record {
+	for _, f := range vocabularyFields {
+		value := recordVocabularyValue(&r, f.Column)
+		if !value.Valid {
+			continue
+		}
+		if f.Column == "milk_type" {
+			value.String = strings.Join(milkTypeCodes(v, value.String), "+")
+			continue
+		}
+		value.String = v.code(f.Column, value.String)
+	}
+	return r
+}
+
+// migration storing the codes of the vocabulary terms in the cheeses and cheese_milk_types tables rather than their labels
+func migrateVocabularyCodes(tx *sql.Tx) error {
+	vocab := loadVocabulary(tx)
+	columns := vocabularyColumns()
+
+	rows, err := tx.Query(`SELECT id, ` + strings.Join(columns, ", ") + ` FROM cheeses`)
+	if err != nil {
+		return err
+	}
+
+	records := map[int64]Record{}
+	for rows.Next() {
+		var id int64
+		var r Record
+		dests := []interface{}{&id}
+		for _, column := range columns {
+			dests = append(dests, recordVocabularyValue(&r, column))
+		}
+		if err = rows.Scan(dests...); err != nil {
+			rows.Close()
+			return err
+		}
+		records[id] = r
+	}
+	rows.Close()
+
+	update := `UPDATE cheeses SET ` + strings.Join(columns, " = ?, ") + ` = ? WHERE id = ?`
+	for id, r := range records {
+		stored := vocab.storedRecord(r)
+		var args []interface{}
+		for _, column := range columns {
+			args = append(args, *recordVocabularyValue(&stored, column))
+		}
+		if _, err = tx.Exec(update, append(args, id)...); err != nil {
+			return err
+		}
+
+		// milk type sets hold codes as well
+		if _, err = tx.Exec(`DELETE FROM cheese_milk_types WHERE record_id = ?`, id); err != nil {
+			return err
+		}
+		if err = insertMilkTypes(tx, id, milkTypeCodes(vocab, r.MilkType.String)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// function to replace categorical values of records with the English label of their term, the label records
+// are displayed and exported with, returns the records and the values that could not be mapped
+func canonicalizeRecords(vocab Vocabulary, records []Record) ([]Record, []UnmappedValue) {
+	counts := map[UnmappedValue]int{}
+
+	for i := range records {
+		for _, f := range vocabularyFields {
+			value := recordVocabularyValue(&records[i], f.Column)
+			if !value.Valid {
+				continue
+			}
+			if f.Column == "milk_type" {
+				// milk types are sets, each part maps onto a term
+				types, unknown := parseMilkTypes(vocab, value.String)
+				for _, u := range unknown {
+					counts[UnmappedValue{Field: f.Column, Value: u}]++
+				}
+				*value = renderMilkTypes(types)
+				continue
+			}
+			if t, ok := vocab.lookup(f.Column, value.String); ok {
+				value.String = t.LabelEn
+			} else {
+				counts[UnmappedValue{Field: f.Column, Value: value.String}]++
+			}
+		}
+	}
+
+	var unmapped []UnmappedValue
+	for u, n := range counts {
+		u.Count = n
+		unmapped = append(unmapped, u)
+	}
+	sort.Slice(unmapped, func(i, j int) bool {
+		if unmapped[i].Field != unmapped[j].Field {
+			return unmapped[i].Field < unmapped[j].Field
+		}
+		return unmapped[i].Value < unmapped[j].Value
+	})
+
+	return records, unmapped
+}
+
+// function to print the values that could not be mapped onto a vocabulary term
+func printUnmappedValues(unmapped []UnmappedValue) {
+	if len(unmapped) == 0 {
+		fmt.Println("\n All categorical values map onto a vocabulary term.")
+		return
+	}
+
+	fmt.Printf("\n %d values do not map onto a vocabulary term (add a term or an alias for them):\n", len(unmapped))
+	for _, u := range unmapped {
+		fmt.Printf("   %-20s %-30q %d records\n", u.Field, u.Value, u.Count)
+	}
+}
+
+// function to load the data file into the database, mapping categorical values onto the vocabularies
+func importRecords(database *sql.DB, filePath string) []Record {
+	records := loadData(filePath, NumRecordsToLoad)
+	importManufacturers(database, loadManufacturers(filePath))
+	seedVocabularies(database, filePath)
+
+	records, unmapped := canonicalizeRecords(loadVocabulary(database), records)
+	printUnmappedValues(unmapped)
+
+	// the data file has no custom attributes, cheeses keep the ones they had
+	attributes := map[int]map[string]string{}
+	for _, r := range getAllCheeses(database) {
+		if r.Attributes != nil {
+			attributes[r.CheeseId] = r.Attributes
+		}
+	}
+	for i := range records {
+		records[i].Attributes = attributes[records[i].CheeseId]
+	}
+
+	// sync in-memory records data structure with database
+	syncDb(records, database)
+	// the imported release is the base of the next merge import
+	check(saveUpstreamSnapshot(database, filePath, records))
+	return records
+}
+
+// helper function to build an SQL expression giving the English label of the term a value maps onto,
+// or the value itself, takes the normalized value and the value as arguments
+func vocabularyTermExpression(column string) string {
+	return `COALESCE((
+		SELECT t.label_en FROM vocabulary_aliases a
+		JOIN vocabulary_terms t ON t.field = a.field AND t.code = a.code
+		WHERE a.field = '` + column + `' AND a.alias = ?), ?)`
+}
+
+// helper function to build an SQL expression giving the code of the term a value maps onto,
+// or the value itself, takes the normalized value and the value as arguments
+func vocabularyCodeExpression(column string) string {
+	return `COALESCE((
+		SELECT a.code FROM vocabulary_aliases a
+		WHERE a.field = '` + column + `' AND a.alias = ?), ?)`
+}
+
+// helper function to build an SQL expression rendering a stored code as the English label of its term,
+// values without a term are shown as stored
+func vocabularyLabelExpression(column string, value string) string {
+	return `COALESCE((
+		SELECT t.label_en FROM vocabulary_terms t
+		WHERE t.field = '` + column + `' AND t.code = ` + value + `), ` + value + `)`
+}
+
+// helper function to get the cheese_records view column of a field with a vocabulary, its label rather than its code
+func vocabularyViewExpression(column string) string {
+	if column == "milk_type" {
+		return milkTypeLabelExpression()
+	}
+	return vocabularyLabelExpression(column, "c." + column)
+}
+
+// helper function to list the columns that have a vocabulary
+func vocabularyColumns() []string {
+	var columns []string
+	for _, f := range vocabularyFields {
+		columns = append(columns, f.Column)
+	}
+	return columns
+}
+
+// helper function to read a categorical value from a pick-list as the label of its term, stored as its code, Enter keeps the default
+func readVocabularyValue(vocab Vocabulary, column string, toRead string, def string) string {
+	terms := vocab.Terms[column]
+
+	// fields without terms yet are free text
+	if len(terms) == 0 {
+		return readNewOrKeepDefaultString(toRead, def)
+	}
+
+	fmt.Printf("\n %s:\n", toRead)
+	for i, t := range terms {
+		fmt.Printf("  %2d. %s (%s)\n", i + 1, t.LabelEn, t.LabelFr)
+	}
+
+	for {
+		s := strings.TrimSpace(readNewOrKeepDefaultString(toRead + " (number or name)", def))
+
+		if s == def || s == MissingValue {
+			return s
+		}
+		if n, err := strconv.Atoi(s); err == nil && n >= 1 && n <= len(terms) {
+			return terms[n - 1].LabelEn
+		}
+		if t, ok := vocab.lookup(column, s); ok {
+			return t.LabelEn
+		}
+		if column == "milk_type" {
+			// any combination of milk types, e.g. "goat+ewe"
+			if types, unknown := parseMilkTypes(vocab, s); len(types) > 0 && len(unknown) == 0 {
+				return renderMilkTypes(types).String
+			}
+		}
+		fmt.Printf("\nPlease enter a number or a name from the list (or %s).\n", MissingValue)
+	}
+}
+
+// function to print the terms of the vocabularies, all of them when the column is empty
+func printVocabulary(vocab Vocabulary, column string) {
+	rows := [][]string{{"Field", "Code", "English", "French", "Aliases"}}
+
+	for _, f := range vocabularyFields {
+		if column != "" && f.Column != column {
+			continue
+		}
+		for _, t := range vocab.Terms[f.Column] {
+			var aliases []string
+			for alias, code := range vocab.Aliases[f.Column] {
+				if code == t.Code && alias != normalizeName(t.LabelEn) && alias != normalizeName(t.LabelFr) && alias != t.Code {
+					aliases = append(aliases, alias)
+				}
+			}
+			sort.Strings(aliases)
+			rows = append(rows, []string{f.Column, t.Code, t.LabelEn, t.LabelFr, strings.Join(aliases, ", ")})
+		}
+	}
+
+	writeAlignedTable(os.Stdout, rows)
+}
+
+// function to report the values of a data file that do not map onto the vocabularies
+func reportUnmappedValues(database *sql.DB, filePath string) {
+	_, unmapped := canonicalizeRecords(loadVocabulary(database), loadData(filePath, NumRecordsToLoad))
+	printUnmappedValues(unmapped)
+}
+
+// function to view and edit the vocabularies from the menu, returns whether they changed
+func manageVocabularies(database *sql.DB, user User) bool {
+	selection := 0
+
+	fmt.Printf("\nControlled vocabularies...\n\n")
+	fmt.Println(" 1. List the terms")
+	fmt.Println(" 2. Report data file values that do not map onto a term")
+	if hasRole(user, RoleEditor) {
+		fmt.Println(" 3. Add a term")
+		fmt.Println(" 4. Add an alias for a term")
+	}
+
+	// loop until selection is valid
+	for selection == 0 {
+		fmt.Printf("Please choose an option: ")
+
+		_, err := fmt.Scanf("%d", &selection)
+
+		if err != nil || selection < 1 || selection > 4 {
+			selection = 0
+			fmt.Println("\nPlease enter a valid option.")
+		} else if selection > 2 && !hasRole(user, RoleEditor) {
+			writeAuditLog(database, user.Username, "vocabularies", AuditDenied, "role "+user.Role)
+			fmt.Printf("\nYour role (%s) does not allow this option.\n", user.Role)
+			return false
+		}
+	}
+
+	var err error
+
+	switch selection {
+		case 1:
+			printVocabulary(loadVocabulary(database), readColumnChoice("field", vocabularyColumns(), true))
+		case 2:
+			reportUnmappedValues(database, findDataFile(DataFilePath))
+		case 3:
+			t := VocabularyTerm{Field: readColumnChoice("field", vocabularyColumns(), false)}
+			t.LabelEn = readRequiredString("English label")
+			t.LabelFr = readRequiredString("French label")
+			t.Code = readNewOrKeepDefaultString("code", vocabularyCode(t.LabelEn))
+			err = addVocabularyTerm(database, t)
+			if err == nil {
+				writeAuditLog(database, user.Username, "add term", AuditAllowed, t.Field+" "+t.Code)
+				return true
+			}
+		case 4:
+			field := readColumnChoice("field", vocabularyColumns(), false)
+			alias := readRequiredString("alias (another spelling)")
+			code := readRequiredString("code of the term")
+			err = addVocabularyAlias(database, field, alias, code)
+			if err == nil {
+				writeAuditLog(database, user.Username, "add alias", AuditAllowed, field+" "+alias+" to "+code)
+				return true
+			}
+	}
+
+	if err != nil {
+		fmt.Printf("\nError: %v\n", err)
+	}
+	return false
+}
+
+// function to run the "vocab" command
+func vocabCommand(database *sql.DB, user User, args []string) error {
+	column := ""
+	if len(args) > 0 {
+		column = args[0]
+		if !isVocabularyColumn(column) {
+			return fmt.Errorf("%q has no vocabulary, expected one of %v", column, vocabularyColumns())
+		}
+	}
+
+	printVocabulary(loadVocabulary(database), column)
+	return nil
+}
+
+// function to run the "vocab-unmapped" command
+func vocabUnmappedCommand(database *sql.DB, user User, args []string) error {
+	flags := flag.NewFlagSet("vocab-unmapped", flag.ContinueOnError)
+	filePath := flags.String("file", findDataFile(DataFilePath), "data file to check, compressed or \"-\" for stdin")
+	encoding := encodingFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := setDataFileEncoding(*encoding); err != nil {
+		return err
+	}
+
+	reportUnmappedValues(database, *filePath)
+	return nil
+}
+
+// function to run the "vocab-add" command
+func vocabAddCommand(database *sql.DB, user User, args []string) error {
+	flags := flag.NewFlagSet("vocab-add", flag.ContinueOnError)
+	code := flags.String("code", "", "code of the term, derived from the English label when empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 3 {
+		return errors.New("expected a field, an English label and a French label")
+	}
+
+	t := VocabularyTerm{flags.Arg(0), *code, flags.Arg(1), flags.Arg(2)}
+	if t.Code == "" {
+		t.Code = vocabularyCode(t.LabelEn)
+	}
+	return addVocabularyTerm(database, t)
+}
+
+// function to run the "vocab-alias" command
+func vocabAliasCommand(database *sql.DB, user User, args []string) error {
+	if len(args) != 3 {
+		return errors.New("expected a field, an alias and the code of the term")
+	}
+	if !isVocabularyColumn(args[0]) {
+		return fmt.Errorf("%q has no vocabulary, expected one of %v", args[0], vocabularyColumns())
+	}
+	return addVocabularyAlias(database, args[0], args[1], args[2])
+}